@@ -6,6 +6,7 @@ import (
 	"kevlar/module/attr"
 	"kevlar/module/auth"
 	"kevlar/module/sec"
+	"kevlar/module/webhook"
 	"net/http"
 	"time"
 
@@ -123,6 +124,14 @@ func (main Server) AccountHandle() http.HandlerFunc {
 				return
 			}
 
+			main.publish(webhook.AccountCreated, []string{data.UserID}, struct {
+				UserID   string `json:"userID"`
+				Username string `json:"username"`
+			}{
+				UserID:   data.UserID,
+				Username: data.Username,
+			})
+
 			log.WithField("userID", data.UserID).Info("account created")
 
 		} else if action == "login" {
@@ -185,6 +194,12 @@ func (main Server) AccountHandle() http.HandlerFunc {
 				return
 			}
 
+			main.publish(webhook.AccountDeleted, []string{data.UserID}, struct {
+				UserID string `json:"userID"`
+			}{
+				UserID: data.UserID,
+			})
+
 			log.WithField("userID", data.UserID).Info("account deleted")
 
 			response.WriteHeader(201)
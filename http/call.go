@@ -0,0 +1,350 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/call"
+	"kevlar/module/chat"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Socket methods sent by clients, the same heads are used for the events sent to the peer.
+const (
+	CallInvite = "call_invite"
+	CallRing   = "call_ring"
+	CallAccept = "call_accept"
+	CallReject = "call_reject"
+	CallSDP    = "call_sdp"
+	CallICE    = "call_ice"
+	CallHangup = "call_hangup"
+
+	CallIncoming = "call_incoming"
+	CallRinging  = "call_ringing"
+	CallAccepted = "call_accepted"
+	CallEnded    = "call_ended"
+)
+
+var (
+	ErrInvalidSDP = errors.New("invalid session description")
+	ErrInvalidICE = errors.New("invalid ICE candidate")
+)
+
+const (
+	maxSDPLength = 16 * 1024
+	maxICELength = 2 * 1024
+
+	callInterval = time.Second
+)
+
+func (main Server) sendCallEvent(userID, head string, data interface{}) {
+	main.WriteMessage(userID, struct {
+		Head string      `json:"head"`
+		Data interface{} `json:"data"`
+	}{
+		Head: head,
+		Data: data,
+	})
+}
+
+// Tells both participants that the call ended and writes the call log into the conversation.
+func (main Server) endCall(ended call.Call) {
+	main.sendCallEnded(ended, ended.Caller, ended.Callee)
+	main.logCall(ended)
+}
+
+func (main Server) sendCallEnded(ended call.Call, userIDs ...string) {
+	event := struct {
+		CallID string `json:"call_id"`
+		Reason string `json:"reason"`
+	}{
+		CallID: ended.ID,
+		Reason: ended.Outcome,
+	}
+
+	for _, userID := range userIDs {
+		main.sendCallEvent(userID, CallEnded, event)
+	}
+}
+
+// Writes the call log into the conversation, it reaches both participants as a message.
+func (main Server) logCall(ended call.Call) {
+	log := logrus.WithField("method", "logCall").WithField("callID", ended.ID)
+
+	message, err := main.chat.LogCall(ended.Caller, ended.Callee, ended.Log())
+	if err != nil {
+		log.WithError(err).Error("error while logging call")
+		return
+	}
+
+	for _, userID := range []string{ended.Caller, ended.Callee} {
+		main.sendCallEvent(userID, chat.MessageIncoming, struct {
+			ID   string `json:"id"`
+			From string `json:"from"`
+			Type string `json:"type"`
+			Data string `json:"data"`
+		}{
+			ID:   message.ID,
+			From: ended.Caller,
+			Type: message.Type,
+			Data: message.Data,
+		})
+	}
+
+	// Missed calls reach the callee on other devices through web push.
+	if ended.Outcome == chat.CallMissed && !main.IsOnline(ended.Callee) {
+		go main.notify(ended.Callee, message)
+	}
+}
+
+// Ends calls which rang without an answer for longer than the ring timeout.
+func (main Server) ExpireCalls() {
+	ticker := time.NewTicker(callInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, expired := range main.calls.Expired() {
+			main.endCall(expired)
+		}
+	}
+}
+
+// Calls are only allowed between contacts who have not blocked each other.
+func (main Server) checkCall(userID, toUserID string) error {
+	relationship, err := main.chat.Relationship(userID, toUserID)
+	if err != nil {
+		return err
+	}
+	if !relationship.Connected {
+		return chat.ErrContactDoesNotExist
+	}
+
+	err = main.chat.CheckBlocked(userID, toUserID)
+	if err != nil {
+		return err
+	}
+	return main.chat.CheckBlocked(toUserID, userID)
+}
+
+func (main Server) CallInvite() SocketHandler {
+
+	type Request struct {
+		UserID string `json:"userID"`
+		Video  bool   `json:"video"`
+	}
+
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request Request
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		err = main.checkCall(userID, request.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		invited, err := main.calls.Invite(userID, request.UserID, request.Video)
+		if errors.Is(err, call.ErrBusy) && invited.ID != "" {
+			// The callee is in another call and never saw this one, only the caller is told
+			// it ended and the callee finds it in the conversation log.
+			main.sendCallEnded(invited, userID)
+			main.logCall(invited)
+		} else if err != nil {
+			return nil, err
+		} else {
+			main.sendCallEvent(request.UserID, CallIncoming, invited)
+		}
+
+		return struct {
+			Head   string `json:"head"`
+			CallID string `json:"call_id"`
+			State  string `json:"state"`
+			Reason string `json:"reason,omitempty"`
+		}{
+			Head:   CallInvite,
+			CallID: invited.ID,
+			State:  invited.State,
+			Reason: invited.Outcome,
+		}, nil
+	}
+}
+
+type callRequest struct {
+	CallID string `json:"call_id"`
+}
+
+func (main Server) CallRing() SocketHandler {
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request callRequest
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		ringing, err := main.calls.Ring(userID, request.CallID)
+		if err != nil {
+			return nil, err
+		}
+
+		main.sendCallEvent(ringing.Caller, CallRinging, request)
+		return nil, nil
+	}
+}
+
+func (main Server) CallAccept() SocketHandler {
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request callRequest
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		accepted, err := main.calls.Accept(userID, request.CallID)
+		if err != nil {
+			return nil, err
+		}
+
+		main.sendCallEvent(accepted.Caller, CallAccepted, request)
+		return nil, nil
+	}
+}
+
+func (main Server) CallReject() SocketHandler {
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request callRequest
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		rejected, err := main.calls.Reject(userID, request.CallID)
+		if err != nil {
+			return nil, err
+		}
+
+		main.endCall(rejected)
+		return nil, nil
+	}
+}
+
+func (main Server) CallHangup() SocketHandler {
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request callRequest
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		ended, err := main.calls.Hangup(userID, request.CallID)
+		if err != nil {
+			return nil, err
+		}
+
+		main.endCall(ended)
+		return nil, nil
+	}
+}
+
+// Relays an SDP offer or answer to the peer.
+func (main Server) CallSDP() SocketHandler {
+
+	type Request struct {
+		CallID string `json:"call_id"`
+		Type   string `json:"type"`
+		SDP    string `json:"sdp"`
+	}
+
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request Request
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		if (request.Type != "offer" && request.Type != "answer") || request.SDP == "" || len(request.SDP) > maxSDPLength {
+			return nil, ErrInvalidSDP
+		}
+
+		relayed, err := main.calls.Relay(userID, request.CallID)
+		if err != nil {
+			return nil, err
+		}
+
+		main.sendCallEvent(relayed.Peer(userID), CallSDP, request)
+		return nil, nil
+	}
+}
+
+// Relays an ICE candidate to the peer, the candidate is passed through as sent.
+func (main Server) CallICE() SocketHandler {
+
+	type Request struct {
+		CallID    string          `json:"call_id"`
+		Candidate json.RawMessage `json:"candidate"`
+	}
+
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request Request
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(request.Candidate) == 0 || len(request.Candidate) > maxICELength {
+			return nil, ErrInvalidICE
+		}
+
+		relayed, err := main.calls.Relay(userID, request.CallID)
+		if err != nil {
+			return nil, err
+		}
+
+		main.sendCallEvent(relayed.Peer(userID), CallICE, request)
+		return nil, nil
+	}
+}
+
+// Returns the ICE servers clients use to set up peer connections.
+func (main Server) CallConfig() http.HandlerFunc {
+
+	log := logrus.WithField("method", "callConfig")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		data, err := json.Marshal(struct {
+			ICEServers  []call.ICEServer `json:"ice_servers"`
+			RingTimeout int              `json:"ring_timeout"`
+		}{
+			ICEServers:  main.calls.ICEServers(userID),
+			RingTimeout: main.calls.RingTimeout,
+		})
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
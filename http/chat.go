@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"kevlar/module/attr"
+	"kevlar/module/audio"
 	"kevlar/module/chat"
+	"kevlar/module/moderation"
+	"kevlar/module/webhook"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -19,6 +23,9 @@ var (
 	RequestDecideIncoming = "request_decide_incoming"
 	RequestDecideOutgoing = "request_decide_outgoing"
 	OnlineStatus          = "user_online_update"
+	ReactionUpdated       = "reaction_updated"
+	PollUpdated           = "poll_updated"
+	ContactRemoved        = "contact_removed"
 )
 
 func (main Server) GetAttributes() http.HandlerFunc {
@@ -43,7 +50,7 @@ func (main Server) GetAttributes() http.HandlerFunc {
 			return
 		}
 
-		responseData, err := main.chat.GetAttributes(userID, main.IsOnline)
+		responseData, err := main.chat.GetAttributes(userID, main.connection)
 		if err != nil {
 			handler(err, 400, "error while getting attributes")
 			return
@@ -166,6 +173,62 @@ func (main Server) Search() http.HandlerFunc {
 	}
 }
 
+func (main Server) SearchMessages() http.HandlerFunc {
+	type Request struct {
+		chat.MessageSearch
+	}
+	type Response struct {
+		Results []chat.SearchResult `json:"results"`
+	}
+
+	log := logrus.WithField("method", "searchMessages")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		results, err := main.chat.SearchMessages(userID, requestData.MessageSearch)
+		if err != nil {
+			handler(err, 400, "error while searching messages")
+			return
+		}
+
+		data, err := json.Marshal(Response{
+			Results: results,
+		})
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
 func (main Server) Request() http.HandlerFunc {
 	type Request struct {
 		UserID string `json:"userID"`
@@ -225,14 +288,26 @@ func (main Server) Request() http.HandlerFunc {
 			err = main.chat.Request(userID, requestData.UserID)
 
 			if err != nil {
-				if errors.Is(err, chat.ErrRequestSent) {
+				if errors.Is(err, chat.ErrRequestSent) || errors.Is(err, chat.ErrAlreadyContact) {
 					handler(err, 409, "error while adding request")
 					return
 				}
+				if errors.Is(err, chat.ErrBlocked) {
+					handler(err, 403, "error while adding request")
+					return
+				}
 				handler(err, 400, "error while adding request")
 				return
 			}
 
+			main.publish(webhook.RequestSent, []string{userID, requestData.UserID}, struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			}{
+				From: userID,
+				To:   requestData.UserID,
+			})
+
 			// Notify the receiver that request has been sent.
 			main.WriteMessage(requestData.UserID, struct {
 				Head string      `json:"head"`
@@ -301,7 +376,30 @@ func (main Server) Request() http.HandlerFunc {
 				return
 			}
 
+			main.publish(webhook.RequestDecided, []string{userID, requestData.UserID}, struct {
+				From     string `json:"from"`
+				To       string `json:"to"`
+				Decision bool   `json:"decision"`
+			}{
+				From:     requestData.UserID,
+				To:       userID,
+				Decision: requestData.Decide,
+			})
+
 			if requestData.Decide {
+				// Invisible users appear offline to their new contact as well.
+				fromPresence, err := main.chat.ViewPresence(userID, requestData.UserID, main.connection(userID))
+				if err != nil {
+					handler(err, 400, "error while getting presence")
+					return
+				}
+
+				toPresence, err := main.chat.ViewPresence(requestData.UserID, userID, main.connection(requestData.UserID))
+				if err != nil {
+					handler(err, 400, "error while getting presence")
+					return
+				}
+
 				// Notify the receiver that request has been decided.
 				main.WriteMessage(requestData.UserID, struct {
 					Head string      `json:"head"`
@@ -317,7 +415,7 @@ func (main Server) Request() http.HandlerFunc {
 							Online bool `json:"online"`
 						}{
 							User:   fromUser,
-							Online: main.IsOnline(userID),
+							Online: fromPresence.Online,
 						},
 						Decision: requestData.Decide,
 					},
@@ -338,7 +436,7 @@ func (main Server) Request() http.HandlerFunc {
 							Online bool `json:"online"`
 						}{
 							User:   toUser,
-							Online: main.IsOnline(requestData.UserID),
+							Online: toPresence.Online,
 						},
 						Decision: requestData.Decide,
 					},
@@ -381,14 +479,201 @@ func (main Server) Request() http.HandlerFunc {
 	}
 }
 
+// Resolves the attachments of a message sent by userID, checking that userID owns every file.
+func (main Server) attachments(userID string, files []chat.FileReference) ([]chat.Attachment, error) {
+	var attachments []chat.Attachment
+
+	for _, value := range files {
+		attributes, err := main.store.GetFileAttributes(userID, value.FileID)
+		if err != nil {
+			return nil, err
+		}
+
+		if value.Thumbnail != "" {
+			_, err = main.store.GetFileAttributes(userID, value.Thumbnail)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		attachments = append(attachments, chat.Attachment{
+			FileID:    value.FileID,
+			Name:      attributes.Name,
+			Size:      attributes.Size,
+			Mime:      attributes.Mime,
+			Thumbnail: value.Thumbnail,
+			Audio:     attributes.Audio,
+		})
+	}
+
+	return attachments, nil
+}
+
+// Shares the attachments of a message from userID with toUserID, stores the message
+// and pushes it to the recipient.
+func (main Server) deliver(userID, toUserID string, outgoing chat.Outgoing) (chat.Message, error) {
+
+	if chat.Reserved(outgoing.Type) {
+		return chat.Message{}, chat.ErrReservedType
+	}
+
+	// Messages may be rejected or redacted before they are stored, flagged ones are stored for review.
+	result, err := main.moderation.Check(moderation.Message{
+		From: userID,
+		To:   toUserID,
+		Type: outgoing.Type,
+		Data: outgoing.Data,
+	})
+	if err != nil {
+		return chat.Message{}, err
+	}
+	outgoing.Data = result.Data
+
+	// Poll options are checked one by one for their content, repeating an option is no spam.
+	if outgoing.Poll != nil {
+		poll := *outgoing.Poll
+		poll.Options = make([]string, len(outgoing.Poll.Options))
+
+		for index, option := range outgoing.Poll.Options {
+			checked, err := main.moderation.CheckContent(moderation.Message{
+				From: userID,
+				To:   toUserID,
+				Type: outgoing.Type,
+				Data: option,
+			})
+			if err != nil {
+				return chat.Message{}, err
+			}
+
+			poll.Options[index] = checked.Data
+			result.Flagged = result.Flagged || checked.Flagged
+			result.Notes = append(result.Notes, checked.Notes...)
+		}
+
+		outgoing.Poll = &poll
+	}
+
+	attachments, err := main.attachments(userID, outgoing.Files)
+	if err != nil {
+		return chat.Message{}, err
+	}
+
+	// The rest of a voice message is checked when it is stored.
+	if outgoing.Type == chat.TypeVoice {
+		for _, attachment := range attachments {
+			if attachment.Audio == nil {
+				continue
+			}
+
+			err = main.config.Audio.Check(*attachment.Audio)
+			if err != nil {
+				return chat.Message{}, err
+			}
+		}
+	}
+
+	// Shared before the message is stored, so a failure leaves nothing behind to retry over
+	// and the recipient never sees an attachment they cannot open.
+	for _, attachment := range attachments {
+		_, err = main.store.ShareFile(userID, attachment.FileID, toUserID)
+		if err != nil {
+			return chat.Message{}, err
+		}
+
+		if attachment.Thumbnail != "" {
+			_, err = main.store.ShareFile(userID, attachment.Thumbnail, toUserID)
+			if err != nil {
+				return chat.Message{}, err
+			}
+		}
+	}
+
+	message, err := main.chat.StoreMessage(chat.Message{
+		From:        userID,
+		Type:        outgoing.Type,
+		Data:        outgoing.Data,
+		ReplyTo:     outgoing.ReplyTo,
+		Thread:      outgoing.Thread,
+		Attachments: attachments,
+		Poll:        outgoing.Poll,
+	}, toUserID)
+	if err != nil {
+		return chat.Message{}, err
+	}
+
+	head := chat.MessageIncoming
+	if message.Thread != "" {
+		head = chat.ThreadReplyIncoming
+	}
+
+	if result.Flagged {
+		main.flag(message, toUserID, result)
+	}
+
+	main.publish(webhook.MessageStored, []string{userID, toUserID}, struct {
+		ID          string            `json:"id"`
+		From        string            `json:"from"`
+		To          string            `json:"to"`
+		Type        string            `json:"type"`
+		Data        string            `json:"data"`
+		ReplyTo     string            `json:"reply_to,omitempty"`
+		Thread      string            `json:"thread,omitempty"`
+		Attachments []chat.Attachment `json:"attachments,omitempty"`
+		Poll        *chat.Poll        `json:"poll,omitempty"`
+	}{
+		ID:          message.ID,
+		From:        userID,
+		To:          toUserID,
+		Type:        message.Type,
+		Data:        message.Data,
+		ReplyTo:     message.ReplyTo,
+		Thread:      message.Thread,
+		Attachments: message.Attachments,
+		Poll:        message.Poll,
+	})
+
+	// Recipients without a websocket connection are notified through web push.
+	if !main.IsOnline(toUserID) {
+		go main.notify(toUserID, message)
+	}
+
+	main.WriteMessage(toUserID, struct {
+		Head string      `json:"head"`
+		Data interface{} `json:"data"`
+	}{
+		Head: head,
+		Data: struct {
+			ID          string            `json:"id"`
+			From        string            `json:"from"`
+			Type        string            `json:"type"`
+			Data        string            `json:"data"`
+			ReplyTo     string            `json:"reply_to,omitempty"`
+			Thread      string            `json:"thread,omitempty"`
+			Attachments []chat.Attachment `json:"attachments,omitempty"`
+			Poll        *chat.Poll        `json:"poll,omitempty"`
+		}{
+			ID:          message.ID,
+			From:        userID,
+			Type:        message.Type,
+			Data:        message.Data,
+			ReplyTo:     message.ReplyTo,
+			Thread:      message.Thread,
+			Attachments: message.Attachments,
+			Poll:        message.Poll,
+		},
+	})
+
+	return message, nil
+}
+
 func (main Server) Message() http.HandlerFunc {
 	type Request struct {
-		Type string `json:"type"`
-		Data string `json:"data"`
+		chat.Outgoing
 	}
 
 	type Response struct {
-		Read bool `json:"read"`
+		ID     string `json:"id"`
+		Status string `json:"status"`
 	}
 
 	log := logrus.WithField("method", "sendMessage")
@@ -427,32 +712,27 @@ func (main Server) Message() http.HandlerFunc {
 			return
 		}
 
-		_, online := main.socket[toUserID]
-
-		err = main.chat.StoreMessage(requestData.Type, requestData.Data, userID, toUserID, online)
+		message, err := main.deliver(userID, toUserID, requestData.Outgoing)
 		if err != nil {
+			if errors.Is(err, chat.ErrMessageDoesNotExist) {
+				handler(err, 404, "error while storing message")
+				return
+			}
+			if errors.Is(err, chat.ErrBlocked) {
+				handler(err, 403, "error while storing message")
+				return
+			}
+			if errors.Is(err, moderation.ErrRejected) || errors.Is(err, audio.ErrTooLong) {
+				handler(err, 422, "error while storing message")
+				return
+			}
 			handler(err, 400, "error while storing message")
 			return
 		}
 
-		main.WriteMessage(toUserID, struct {
-			Head string      `json:"head"`
-			Data interface{} `json:"data"`
-		}{
-			Head: chat.MessageIncoming,
-			Data: struct {
-				From string `json:"from"`
-				Type string `json:"type"`
-				Data string `json:"data"`
-			}{
-				From: userID,
-				Type: requestData.Type,
-				Data: requestData.Data,
-			},
-		})
-
 		responseData := Response{
-			Read: online,
+			ID:     message.ID,
+			Status: message.Status,
 		}
 
 		data, err := json.Marshal(responseData)
@@ -466,13 +746,67 @@ func (main Server) Message() http.HandlerFunc {
 	}
 }
 
-func (main Server) LoadPrevious() http.HandlerFunc {
+func (main Server) Media() http.HandlerFunc {
 	type Request struct {
-		Since int `json:"since"`
+		chat.PageQuery
 	}
 
-	type Response struct {
-		Messages []chat.Message `json:"messages"`
+	log := logrus.WithField("method", "loadMedia")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		media, err := main.chat.Media(userID, toUserID, requestData.PageQuery)
+		if err != nil {
+			handler(err, 400, "error while loading media")
+			return
+		}
+
+		data, err := json.Marshal(media)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) LoadPrevious() http.HandlerFunc {
+	type Request struct {
+		chat.PageQuery
 	}
 
 	log := logrus.WithField("method", "sendMessage")
@@ -511,17 +845,26 @@ func (main Server) LoadPrevious() http.HandlerFunc {
 			return
 		}
 
-		messages, err := main.chat.LoadMessages(userID, toUserID, requestData.Since)
+		page, err := main.chat.LoadMessages(userID, toUserID, requestData.PageQuery)
 		if err != nil {
-			handler(err, 400, "error while storing message")
+			if errors.Is(err, chat.ErrMessageDoesNotExist) {
+				handler(err, 404, "error while loading messages")
+				return
+			}
+			handler(err, 400, "error while loading messages")
 			return
 		}
 
-		responseData := Response{
-			Messages: messages,
+		// Opening the latest page of the conversation reads it.
+		if page.Previous == "" && len(page.Messages) != 0 {
+			err = main.markRead(userID, toUserID, page.Messages[0].ID)
+			if err != nil {
+				handler(err, 400, "error while marking messages read")
+				return
+			}
 		}
 
-		data, err := json.Marshal(responseData)
+		data, err := json.Marshal(page)
 		if err != nil {
 			handler(err, 400, "error while marshalling response")
 			return
@@ -532,11 +875,1161 @@ func (main Server) LoadPrevious() http.HandlerFunc {
 	}
 }
 
+// Marks the conversation read up to messageID and sends the receipt to the other party.
+func (main Server) markRead(userID, toUserID, messageID string) error {
+	receipt, err := main.chat.MarkRead(userID, toUserID, messageID)
+	if err != nil {
+		return err
+	}
+
+	main.WriteMessage(toUserID, struct {
+		Head string      `json:"head"`
+		Data interface{} `json:"data"`
+	}{
+		Head: chat.ReceiptUpdate,
+		Data: receipt,
+	})
+
+	return nil
+}
+
+func (main Server) Read() http.HandlerFunc {
+	type Request struct {
+		ID string `json:"id"`
+	}
+
+	log := logrus.WithField("method", "markRead")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		err = main.markRead(userID, toUserID, requestData.ID)
+		if err != nil {
+			if errors.Is(err, chat.ErrMessageDoesNotExist) {
+				handler(err, 404, "error while marking messages read")
+				return
+			}
+			handler(err, 400, "error while marking messages read")
+			return
+		}
+
+		response.WriteHeader(200)
+	}
+}
+
+func (main Server) Privacy() http.HandlerFunc {
+	type Request struct {
+		ReadReceipts *bool   `json:"read_receipts,omitempty"`
+		Discoverable *bool   `json:"discoverable,omitempty"`
+		LastSeen     *string `json:"last_seen,omitempty"`
+	}
+
+	log := logrus.WithField("method", "setPrivacy")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		privacy, err := main.chat.GetPrivacy(userID)
+		if err != nil {
+			handler(err, 400, "error while getting privacy settings")
+			return
+		}
+
+		// Only the settings present in the request are changed.
+		if requestData.ReadReceipts != nil {
+			privacy.ReadReceipts = *requestData.ReadReceipts
+		}
+		if requestData.Discoverable != nil {
+			privacy.Discoverable = *requestData.Discoverable
+		}
+		if requestData.LastSeen != nil {
+			privacy.LastSeen = *requestData.LastSeen
+		}
+
+		err = main.chat.SetPrivacy(userID, privacy)
+		if err != nil {
+			handler(err, 400, "error while setting privacy settings")
+			return
+		}
+
+		data, err := json.Marshal(privacy)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) LoadThread() http.HandlerFunc {
+	type Request struct {
+		ID string `json:"id"`
+		chat.PageQuery
+	}
+
+	type Response struct {
+		Root chat.Message `json:"root"`
+		chat.Page
+	}
+
+	log := logrus.WithField("method", "loadThread")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		root, page, err := main.chat.LoadThread(userID, toUserID, requestData.ID, requestData.PageQuery)
+		if err != nil {
+			if errors.Is(err, chat.ErrMessageDoesNotExist) {
+				handler(err, 404, "error while loading thread")
+				return
+			}
+			handler(err, 400, "error while loading thread")
+			return
+		}
+
+		responseData := Response{
+			Root: root,
+			Page: page,
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) React() http.HandlerFunc {
+	type Request struct {
+		ID     string `json:"id"`
+		Emoji  string `json:"emoji"`
+		Remove bool   `json:"remove,omitempty"`
+	}
+
+	type Response struct {
+		Reactions []chat.ReactionCount `json:"reactions"`
+	}
+
+	log := logrus.WithField("method", "reactMessage")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		reactions, err := main.chat.React(userID, toUserID, requestData.ID, requestData.Emoji, requestData.Remove)
+		if err != nil {
+			if errors.Is(err, chat.ErrInvalidReaction) {
+				handler(err, 422, "error while reacting to message")
+				return
+			}
+			handler(err, 400, "error while reacting to message")
+			return
+		}
+
+		// Notify the other party of the updated reactions.
+		main.WriteMessage(toUserID, struct {
+			Head string      `json:"head"`
+			Data interface{} `json:"data"`
+		}{
+			Head: ReactionUpdated,
+			Data: struct {
+				UserID    string               `json:"userID"`
+				ID        string               `json:"id"`
+				Reactions []chat.ReactionCount `json:"reactions"`
+			}{
+				UserID:    userID,
+				ID:        requestData.ID,
+				Reactions: chat.CountReactions(reactions, toUserID),
+			},
+		})
+
+		// Notify the other sessions of the sender.
+		main.WriteMessage(userID, struct {
+			Head string      `json:"head"`
+			Data interface{} `json:"data"`
+		}{
+			Head: ReactionUpdated,
+			Data: struct {
+				UserID    string               `json:"userID"`
+				ID        string               `json:"id"`
+				Reactions []chat.ReactionCount `json:"reactions"`
+			}{
+				UserID:    toUserID,
+				ID:        requestData.ID,
+				Reactions: chat.CountReactions(reactions, userID),
+			},
+		})
+
+		responseData := Response{
+			Reactions: chat.CountReactions(reactions, userID),
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) Vote() http.HandlerFunc {
+	type Request struct {
+		ID      string `json:"id"`
+		Choices []int  `json:"choices"`
+	}
+
+	type Response struct {
+		Results *chat.PollResults `json:"results"`
+	}
+
+	log := logrus.WithField("method", "votePoll")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		poll, err := main.chat.Vote(userID, toUserID, requestData.ID, requestData.Choices)
+		if err != nil {
+			if errors.Is(err, chat.ErrMessageDoesNotExist) {
+				handler(err, 404, "error while voting on poll")
+				return
+			}
+			if errors.Is(err, chat.ErrBlocked) {
+				handler(err, 403, "error while voting on poll")
+				return
+			}
+			if errors.Is(err, chat.ErrPollClosed) {
+				handler(err, 409, "error while voting on poll")
+				return
+			}
+			if errors.Is(err, chat.ErrNotPoll) || errors.Is(err, chat.ErrInvalidVote) {
+				handler(err, 422, "error while voting on poll")
+				return
+			}
+			handler(err, 400, "error while voting on poll")
+			return
+		}
+
+		// Notify the other party and the other sessions of the voter, each with their own choices.
+		for _, participant := range []string{toUserID, userID} {
+			peer := userID
+			if participant == userID {
+				peer = toUserID
+			}
+
+			main.WriteMessage(participant, struct {
+				Head string      `json:"head"`
+				Data interface{} `json:"data"`
+			}{
+				Head: PollUpdated,
+				Data: struct {
+					UserID  string            `json:"userID"`
+					ID      string            `json:"id"`
+					Results *chat.PollResults `json:"results"`
+				}{
+					UserID:  peer,
+					ID:      requestData.ID,
+					Results: chat.TallyPoll(poll, participant),
+				},
+			})
+		}
+
+		responseData := Response{
+			Results: chat.TallyPoll(poll, userID),
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) RemoveContact() http.HandlerFunc {
+	type Request struct {
+		UserID string `json:"userID"`
+		Delete bool   `json:"delete,omitempty"`
+	}
+
+	log := logrus.WithField("method", "removeContact")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		err = main.chat.RemoveContact(userID, requestData.UserID, requestData.Delete)
+		if err != nil {
+			if errors.Is(err, chat.ErrContactDoesNotExist) {
+				handler(err, 404, "error while removing contact")
+				return
+			}
+			handler(err, 400, "error while removing contact")
+			return
+		}
+
+		// Notify the other party that the contact has been removed.
+		main.WriteMessage(requestData.UserID, struct {
+			Head string      `json:"head"`
+			Data interface{} `json:"data"`
+		}{
+			Head: ContactRemoved,
+			Data: struct {
+				UserID string `json:"userID"`
+			}{
+				UserID: userID,
+			},
+		})
+
+		response.WriteHeader(201)
+	}
+}
+
+func (main Server) Timer() http.HandlerFunc {
+	type Request struct {
+		Timer int64 `json:"timer"` // seconds, zero turns the timer off
+	}
+
+	log := logrus.WithField("method", "setTimer")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		conversation, err := main.chat.SetTimer(userID, toUserID, requestData.Timer)
+		if err != nil {
+			if errors.Is(err, chat.ErrInvalidTimer) {
+				handler(err, 422, "error while setting timer")
+				return
+			}
+			handler(err, 400, "error while setting timer")
+			return
+		}
+
+		// Notify both parties of the new timer.
+		for _, pair := range [][2]string{{toUserID, userID}, {userID, toUserID}} {
+			main.WriteMessage(pair[0], struct {
+				Head string      `json:"head"`
+				Data interface{} `json:"data"`
+			}{
+				Head: chat.TimerUpdate,
+				Data: struct {
+					UserID string `json:"userID"`
+					chat.Conversation
+				}{
+					UserID:       pair[1],
+					Conversation: conversation,
+				},
+			})
+		}
+
+		data, err := json.Marshal(conversation)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) Scheduled() http.HandlerFunc {
+	type Request struct {
+		ID      string        `json:"id,omitempty"`
+		UserID  string        `json:"userID,omitempty"`
+		Message chat.Outgoing `json:"message"`
+		SendAt  time.Time     `json:"send_at"`
+		After   string        `json:"after,omitempty"`
+	}
+
+	log := logrus.WithField("method", "scheduledMessage")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		var responseData interface{}
+
+		if action == "create" {
+			responseData, err = main.chat.Schedule(userID, requestData.UserID, requestData.Message, requestData.SendAt)
+			if err != nil {
+				handler(err, 400, "error while scheduling message")
+				return
+			}
+
+		} else if action == "list" {
+			responseData, err = main.chat.ListScheduled(userID, requestData.After)
+			if err != nil {
+				handler(err, 400, "error while listing scheduled messages")
+				return
+			}
+
+		} else if action == "update" {
+			responseData, err = main.chat.UpdateScheduled(userID, requestData.ID, requestData.Message, requestData.SendAt)
+			if err != nil {
+				if errors.Is(err, chat.ErrScheduledDoesNotExist) {
+					handler(err, 404, "error while updating scheduled message")
+					return
+				}
+				handler(err, 400, "error while updating scheduled message")
+				return
+			}
+
+		} else if action == "cancel" {
+			err = main.chat.CancelScheduled(userID, requestData.ID)
+			if err != nil {
+				if errors.Is(err, chat.ErrScheduledDoesNotExist) {
+					handler(err, 404, "error while cancelling scheduled message")
+					return
+				}
+				handler(err, 400, "error while cancelling scheduled message")
+				return
+			}
+
+			response.WriteHeader(201)
+			return
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) Pin() http.HandlerFunc {
+	type Request struct {
+		ID  string `json:"id"`
+		Pin bool   `json:"pin"`
+	}
+
+	log := logrus.WithField("method", "pinMessage")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		message, err := main.chat.Pin(userID, toUserID, requestData.ID, requestData.Pin)
+		if err != nil {
+			if errors.Is(err, chat.ErrMessageDoesNotExist) {
+				handler(err, 404, "error while pinning message")
+				return
+			}
+			handler(err, 400, "error while pinning message")
+			return
+		}
+
+		// Pins are shared, so both parties are notified.
+		for _, pair := range [][2]string{{toUserID, userID}, {userID, toUserID}} {
+			main.WriteMessage(pair[0], struct {
+				Head string      `json:"head"`
+				Data interface{} `json:"data"`
+			}{
+				Head: chat.PinUpdated,
+				Data: struct {
+					UserID   string     `json:"userID"`
+					ID       string     `json:"id"`
+					PinnedBy string     `json:"pinned_by,omitempty"`
+					PinnedAt *time.Time `json:"pinned_at,omitempty"`
+				}{
+					UserID:   pair[1],
+					ID:       message.ID,
+					PinnedBy: message.PinnedBy,
+					PinnedAt: message.PinnedAt,
+				},
+			})
+		}
+
+		response.WriteHeader(201)
+	}
+}
+
+func (main Server) LoadPinned() http.HandlerFunc {
+	type Request struct {
+		chat.PageQuery
+	}
+
+	log := logrus.WithField("method", "loadPinned")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		page, err := main.chat.LoadPinned(userID, toUserID, requestData.PageQuery)
+		if err != nil {
+			handler(err, 400, "error while loading pinned messages")
+			return
+		}
+
+		data, err := json.Marshal(page)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) Star() http.HandlerFunc {
+	type Request struct {
+		ID   string `json:"id"`
+		Star bool   `json:"star"`
+	}
+
+	log := logrus.WithField("method", "starMessage")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		err = main.chat.Star(userID, toUserID, requestData.ID, requestData.Star)
+		if err != nil {
+			if errors.Is(err, chat.ErrAlreadyStarred) {
+				handler(err, 409, "error while starring message")
+				return
+			}
+			if errors.Is(err, chat.ErrMessageDoesNotExist) {
+				handler(err, 404, "error while starring message")
+				return
+			}
+			handler(err, 400, "error while starring message")
+			return
+		}
+
+		response.WriteHeader(201)
+	}
+}
+
+func (main Server) LoadStarred() http.HandlerFunc {
+	type Request struct {
+		LastID string `json:"last_id"`
+	}
+
+	log := logrus.WithField("method", "loadStarred")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		stars, last_id, err := main.chat.ListStarred(userID, requestData.LastID)
+		if err != nil {
+			handler(err, 400, "error while loading starred messages")
+			return
+		}
+
+		responseData := struct {
+			Starred []chat.Star `json:"starred"`
+			LastID  string      `json:"last_id"`
+		}{
+			Starred: stars,
+			LastID:  last_id,
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
+
+func (main Server) Archive() http.HandlerFunc {
+	type Request struct {
+		Archive bool `json:"archive"`
+	}
+
+	log := logrus.WithField("method", "archiveConversation")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		args := mux.Vars(request)
+		toUserID, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		err = main.chat.Archive(userID, toUserID, requestData.Archive)
+		if err != nil {
+			handler(err, 400, "error while archiving conversation")
+			return
+		}
+
+		response.WriteHeader(201)
+	}
+}
+
+func (main Server) Block() http.HandlerFunc {
+	type Request struct {
+		UserID string `json:"userID"`
+	}
+
+	log := logrus.WithField("method", "blockUser")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		if action == "block" {
+			err = main.chat.Block(userID, requestData.UserID)
+			if err != nil {
+				if errors.Is(err, chat.ErrAlreadyBlocked) {
+					handler(err, 409, "error while blocking user")
+					return
+				}
+				handler(err, 400, "error while blocking user")
+				return
+			}
+
+			// The blocked user no longer sees the presence of the user.
+			main.WriteMessage(requestData.UserID, struct {
+				Head string      `json:"head"`
+				Data interface{} `json:"data"`
+			}{
+				Head: OnlineStatus,
+				Data: chat.PresenceView{
+					UserID: userID,
+					Online: false,
+					State:  chat.PresenceOffline,
+				},
+			})
+
+		} else if action == "unblock" {
+			err = main.chat.Unblock(userID, requestData.UserID)
+			if err != nil {
+				handler(err, 400, "error while unblocking user")
+				return
+			}
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		response.WriteHeader(201)
+	}
+}
+
+func (main Server) Mute() http.HandlerFunc {
+	type Request struct {
+		UserID   string `json:"userID"`
+		Duration int64  `json:"duration,omitempty"` // seconds, zero mutes until unmuted
+	}
+
+	log := logrus.WithField("method", "muteConversation")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		if action == "mute" {
+			err = main.chat.Mute(userID, requestData.UserID, time.Duration(requestData.Duration)*time.Second)
+			if err != nil {
+				handler(err, 400, "error while muting conversation")
+				return
+			}
+
+		} else if action == "unmute" {
+			err = main.chat.Unmute(userID, requestData.UserID)
+			if err != nil {
+				handler(err, 400, "error while unmuting conversation")
+				return
+			}
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		response.WriteHeader(201)
+	}
+}
+
 func (main Server) RegisterChatAPIHandlers() {
 	main.HandleFunc("/chat/data/all", main.GetAttributes()).Methods("POST", "OPTIONS")
 	main.HandleFunc("/chat/data/about", main.About()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/data/privacy", main.Privacy()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/presence/{action}", main.Presence()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/call/config", main.CallConfig()).Methods("POST", "OPTIONS")
 	main.HandleFunc("/chat/search", main.Search()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/search/messages", main.SearchMessages()).Methods("POST", "OPTIONS")
 	main.HandleFunc("/chat/request/{action}", main.Request()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/contact/remove", main.RemoveContact()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/push/{action}", main.Push()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/webhook/{action}", main.Webhook()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/moderation/{action}", main.Moderation()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/report/{userID}", main.Report()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/export/{action}", main.Export()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/scheduled/{action}", main.Scheduled()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/timer/{userID}", main.Timer()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/pin/{userID}", main.Pin()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/pinned/{userID}", main.LoadPinned()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/star/{userID}", main.Star()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/starred", main.LoadStarred()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/archive/{userID}", main.Archive()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/block/{action}", main.Block()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/mute/{action}", main.Mute()).Methods("POST", "OPTIONS")
 	main.HandleFunc("/chat/message/{userID}", main.Message()).Methods("POST", "OPTIONS")
 	main.HandleFunc("/chat/history/{userID}", main.LoadPrevious()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/read/{userID}", main.Read()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/media/{userID}", main.Media()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/thread/{userID}", main.LoadThread()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/reaction/{userID}", main.React()).Methods("POST", "OPTIONS")
+	main.HandleFunc("/chat/poll/{userID}", main.Vote()).Methods("POST", "OPTIONS")
 }
@@ -80,34 +80,28 @@ func (main Server) EventDelete(userID string) error {
 		return fmt.Errorf("error while deleting attributes: %w", err)
 	}
 
+	err = main.webhook.DeleteOwner(userID)
+	if err != nil {
+		return fmt.Errorf("error while deleting webhooks: %w", err)
+	}
+
+	err = main.export.DeleteOwner(userID)
+	if err != nil {
+		return fmt.Errorf("error while deleting exports: %w", err)
+	}
+
 	return nil
 }
 
 func (main Server) WebSocketConnected(userID string) error {
 
-	var contacts []chat.User
+	main.activity.touch(userID)
 
-	err := main.attr.GetAttribute(userID, chat.ContactList, &contacts)
+	err := main.broadcastPresence(userID, chat.Connection{Online: true})
 	if err != nil {
 		return err
 	}
 
-	for _, contact := range contacts {
-		main.WriteMessage(contact.UserID, struct {
-			Head string      `json:"head"`
-			Data interface{} `json:"data"`
-		}{
-			Head: OnlineStatus,
-			Data: struct {
-				UserID string `json:"userID"`
-				Online bool   `json:"online"`
-			}{
-				UserID: userID,
-				Online: true,
-			},
-		})
-	}
-
 	err = main.WebsocketConnectionHandler(userID)
 
 	return err
@@ -115,30 +109,25 @@ func (main Server) WebSocketConnected(userID string) error {
 
 func (main Server) WebSocketDisconnected(userID string) error {
 
-	var contacts []chat.User
+	main.activity.forget(userID)
 
-	err := main.attr.GetAttribute(userID, chat.ContactList, &contacts)
+	// Calls do not survive the last connection of a participant.
+	if ended, ok := main.calls.Leave(userID); ok {
+		main.endCall(ended)
+	}
+
+	presence, err := main.chat.GetPresence(userID)
 	if err != nil {
 		return err
 	}
 
-	for _, contact := range contacts {
-		main.WriteMessage(contact.UserID, struct {
-			Head string      `json:"head"`
-			Data interface{} `json:"data"`
-		}{
-			Head: OnlineStatus,
-			Data: struct {
-				UserID string `json:"userID"`
-				Online bool   `json:"online"`
-			}{
-				UserID: userID,
-				Online: false,
-			},
-		})
+	// Invisible users were last seen when they went invisible.
+	if presence.Mode != chat.PresenceInvisible {
+		err = main.attr.SetAttribute(userID, chat.LastSeen, time.Now().Unix())
+		if err != nil {
+			return err
+		}
 	}
 
-	err = main.attr.SetAttribute(userID, chat.LastSeen, time.Now().Unix())
-
-	return err
+	return main.broadcastPresence(userID, chat.Connection{Online: false})
 }
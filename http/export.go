@@ -0,0 +1,260 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kevlar/module/attr"
+	"kevlar/module/chat"
+	"kevlar/module/export"
+	"kevlar/module/file"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ExportUpdated = "export_updated"
+)
+
+var (
+	exportInterval = 5 * time.Second
+)
+
+// Builds pending conversation exports. Jobs are kept in mongo, so exports requested
+// while the server was stopped are built on the next start.
+func (main Server) RunExports() {
+	log := logrus.WithField("method", "runExports")
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			job, err := main.export.Claim()
+			if errors.Is(err, export.ErrExportDoesNotExist) {
+				break
+			}
+			if err != nil {
+				log.WithError(err).Error("error while claiming export")
+				break
+			}
+
+			result, failure := main.buildExport(job)
+			if failure != nil {
+				log.WithField("userID", job.UserID).WithError(failure).Warn("export failed")
+			}
+
+			job, err = main.export.Complete(job, result, failure)
+			if err != nil {
+				log.WithField("userID", job.UserID).WithError(err).Error("error while completing export")
+				continue
+			}
+
+			main.WriteMessage(job.UserID, struct {
+				Head string      `json:"head"`
+				Data interface{} `json:"data"`
+			}{
+				Head: ExportUpdated,
+				Data: job,
+			})
+		}
+	}
+}
+
+// Writes the conversation of the job into a file in the store of its user, which is
+// removed again once the export expires.
+func (main Server) buildExport(job export.Job) (export.Result, error) {
+	header := export.Header{
+		UserID:          job.UserID,
+		Username:        job.UserID,
+		ContactID:       job.Contact,
+		ContactUsername: job.Contact,
+		ExportedAt:      time.Now(),
+	}
+
+	// Accounts deleted since are shown by their ID.
+	if user, err := main.chat.GetInformation(job.UserID); err == nil {
+		header.Username = user.Username
+	}
+	if contact, err := main.chat.GetInformation(job.Contact); err == nil {
+		header.ContactUsername = contact.Username
+	}
+
+	var fetch export.Fetch
+	if job.Attachments {
+		fetch = func(owner, fileID string) ([]byte, error) {
+			file, err := main.store.Download(job.UserID, owner, fileID)
+			if err != nil {
+				return nil, err
+			}
+			return file.Data, nil
+		}
+	}
+
+	// Exports with attachments can be large, they are written to disk rather than held in memory.
+	temp, err := os.CreateTemp("", "kevlar-export-*")
+	if err != nil {
+		return export.Result{}, err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	skipped, err := export.Build(temp, job.Format, header, func(each func(chat.Message) error) error {
+		return main.chat.EachMessage(job.UserID, job.Contact, each)
+	}, fetch, main.export.MaxAttachmentsMB<<20)
+	if err != nil {
+		return export.Result{}, err
+	}
+
+	size, err := temp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return export.Result{}, err
+	}
+
+	_, err = temp.Seek(0, io.SeekStart)
+	if err != nil {
+		return export.Result{}, err
+	}
+
+	mime, err := mimetype.DetectReader(temp)
+	if err != nil {
+		return export.Result{}, err
+	}
+
+	_, err = temp.Seek(0, io.SeekStart)
+	if err != nil {
+		return export.Result{}, err
+	}
+
+	name := fmt.Sprintf("%s-%s", header.ContactUsername, export.FileName(job.Format, job.Attachments))
+
+	exported := file.NewAttributes(int(size), name, mime, []string{job.UserID})
+
+	err = main.store.UploadFrom(job.UserID, exported, temp)
+	if err != nil {
+		return export.Result{}, err
+	}
+
+	expires := time.Now().Add(time.Duration(main.export.ExpiryHours) * time.Hour)
+
+	err = main.chat.ExpireFile(job.UserID, exported.ID, expires)
+	if err != nil {
+		return export.Result{}, err
+	}
+
+	return export.Result{
+		FileID:  exported.ID,
+		URL:     "/store/" + exported.ID,
+		Skipped: skipped,
+	}, nil
+}
+
+func (main Server) Export() http.HandlerFunc {
+	type Request struct {
+		ID          string `json:"id,omitempty"`
+		UserID      string `json:"userID,omitempty"`
+		Format      string `json:"format,omitempty"`
+		Attachments bool   `json:"attachments,omitempty"`
+	}
+
+	log := logrus.WithField("method", "export")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		var responseData interface{}
+		status := 200
+
+		if action == "create" {
+			err = main.chat.CheckConversation(userID, requestData.UserID)
+			if err != nil {
+				if errors.Is(err, chat.ErrContactDoesNotExist) {
+					handler(err, 404, "error while checking conversation")
+					return
+				}
+				handler(err, 400, "error while checking conversation")
+				return
+			}
+
+			responseData, err = main.export.Create(userID, requestData.UserID, requestData.Format, requestData.Attachments)
+			if err != nil {
+				if errors.Is(err, export.ErrExportInProgress) || errors.Is(err, export.ErrTooManyExports) {
+					handler(err, 409, "error while creating export")
+					return
+				}
+				handler(err, 400, "error while creating export")
+				return
+			}
+			status = 201
+
+		} else if action == "list" {
+			responseData, err = main.export.Jobs(userID)
+			if err != nil {
+				handler(err, 400, "error while loading exports")
+				return
+			}
+
+		} else if action == "status" {
+			responseData, err = main.export.Get(userID, requestData.ID)
+			if err != nil {
+				if errors.Is(err, export.ErrExportDoesNotExist) {
+					handler(err, 404, "error while loading export")
+					return
+				}
+				handler(err, 400, "error while loading export")
+				return
+			}
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(status)
+		response.Write(data)
+	}
+}
@@ -17,6 +17,8 @@ type IncomingMessage struct {
 
 const (
 	TypingStatusUpdate = "typing_status_update"
+	MessageDelivered   = "message_delivered"
+	MessageRead        = "message_read"
 )
 
 var (
@@ -38,8 +40,12 @@ func (main Server) TypingStatusUpdate() SocketHandler {
 			return nil, err
 		}
 
-		var contacts []chat.User
-		err = main.attr.GetAttribute(userID, chat.ContactList, &contacts)
+		contacts, err := main.chat.Contacts(userID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = main.chat.CheckBlocked(userID, request.UserID)
 		if err != nil {
 			return nil, err
 		}
@@ -70,7 +76,58 @@ func (main Server) TypingStatusUpdate() SocketHandler {
 	}
 }
 
-func ConnectionHandle(socket *Socket, userID string) {
+func (main Server) MessageDelivered() SocketHandler {
+
+	type Request struct {
+		UserID string   `json:"userID"`
+		IDs    []string `json:"ids"`
+	}
+
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request Request
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		receipt, err := main.chat.MarkDelivered(userID, request.UserID, request.IDs)
+		if err != nil {
+			return nil, err
+		}
+
+		main.WriteMessage(request.UserID, struct {
+			Head string      `json:"head"`
+			Data interface{} `json:"data"`
+		}{
+			Head: chat.ReceiptUpdate,
+			Data: receipt,
+		})
+
+		return nil, nil
+	}
+}
+
+func (main Server) MessageRead() SocketHandler {
+
+	type Request struct {
+		UserID string `json:"userID"`
+		ID     string `json:"id"`
+	}
+
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		var request Request
+		err := json.Unmarshal(data, &request)
+		if err != nil {
+			return nil, err
+		}
+
+		err = main.markRead(userID, request.UserID, request.ID)
+
+		return nil, err
+	}
+}
+
+func (main Server) ConnectionHandle(socket *Socket, userID string) {
 
 	log := logrus.WithField("userID", userID).WithField("method", "websocketHandler")
 
@@ -90,6 +147,30 @@ func ConnectionHandle(socket *Socket, userID string) {
 
 		for head, handler := range Methods {
 			if head == packet.Head {
+				result, err := main.allowSocket(userID, head)
+				if err != nil {
+					log.WithError(err).Error("error while taking rate limit token")
+				} else if !result.Allowed {
+					socket.Send <- struct {
+						Head string      `json:"head"`
+						Data interface{} `json:"data"`
+					}{
+						Head: RateLimited,
+						Data: struct {
+							Method     string `json:"method"`
+							RetryAfter int    `json:"retry_after"`
+						}{
+							Method:     head,
+							RetryAfter: seconds(result.RetryAfter),
+						},
+					}
+					break
+				}
+
+				if userActions[head] {
+					main.touch(userID)
+				}
+
 				response, err := handler(userID, packet.Data)
 				if err != nil {
 					log.WithError(err).Error("error while executing websocket method")
@@ -107,14 +188,14 @@ func ConnectionHandle(socket *Socket, userID string) {
 }
 
 func (main Server) WebsocketConnectionHandler(userID string) error {
-	socket, ok := main.socket[userID]
+	socket, ok := main.socket.Get(userID)
 	if !ok {
 		return ErrSocketDoesNotExist
 	}
 
 	main.RegisterWebsocketMethods()
 
-	go ConnectionHandle(socket, userID)
+	go main.ConnectionHandle(socket, userID)
 
 	return nil
 }
@@ -125,4 +206,14 @@ func register(head string, handler SocketHandler) {
 
 func (main Server) RegisterWebsocketMethods() {
 	register(TypingStatusUpdate, main.TypingStatusUpdate())
+	register(MessageDelivered, main.MessageDelivered())
+	register(MessageRead, main.MessageRead())
+	register(Activity, main.Activity())
+	register(CallInvite, main.CallInvite())
+	register(CallRing, main.CallRing())
+	register(CallAccept, main.CallAccept())
+	register(CallReject, main.CallReject())
+	register(CallSDP, main.CallSDP())
+	register(CallICE, main.CallICE())
+	register(CallHangup, main.CallHangup())
 }
@@ -5,12 +5,18 @@ import (
 	"encoding/json"
 	"kevlar/module/attr"
 	"kevlar/module/auth"
+	"kevlar/module/call"
 	"kevlar/module/chat"
 	"kevlar/module/conf"
 	"kevlar/module/db/minio"
 	"kevlar/module/db/mongo"
+	"kevlar/module/export"
+	"kevlar/module/limit"
+	"kevlar/module/moderation"
+	"kevlar/module/push"
 	"kevlar/module/sec"
 	"kevlar/module/store"
+	"kevlar/module/webhook"
 
 	"net/http"
 	"time"
@@ -76,12 +82,20 @@ func (n notFound) ServeHTTP(response http.ResponseWriter, request *http.Request)
 type Server struct {
 	http.Server
 	*mux.Router
-	attr   attr.Attr
-	auth   auth.Auth
-	store  store.Store
-	chat   chat.Chat
-	config conf.RootConfig
-	socket map[string]*Socket
+	attr       attr.Attr
+	auth       auth.Auth
+	store      store.Store
+	chat       chat.Chat
+	push       push.Push
+	calls      call.Calls
+	webhook    webhook.Webhook
+	export     export.Export
+	moderation moderation.Moderation
+	config     conf.RootConfig
+	socket     *sockets
+	limiter    limit.Limiter
+
+	activity *activity
 }
 
 func New(mongo *mongo.MongoClient, minio *minio.MinioClient, config conf.RootConfig) Server {
@@ -94,18 +108,32 @@ func New(mongo *mongo.MongoClient, minio *minio.MinioClient, config conf.RootCon
 	auth := auth.New(mongo, config.Auth)
 	store := store.New(minio, attr, config.Store)
 	chat := chat.New(attr, mongo)
+	push := push.New(mongo, config.Push)
 
-	sockets := make(map[string]*Socket)
+	// Buckets are shared through mongo when several instances serve the same users.
+	var backend limit.Backend = limit.NewMemory()
+	if config.Limit.Backend == limit.BackendMongo {
+		backend = limit.NewMongo(mongo)
+	}
 
 	server := Server{
-		Router: router,
-		attr:   attr,
-		auth:   auth,
-		store:  store,
-		chat:   chat,
-		config: config,
-		socket: sockets,
+		Router:     router,
+		attr:       attr,
+		auth:       auth,
+		store:      store,
+		chat:       chat,
+		push:       push,
+		calls:      call.New(config.Call),
+		webhook:    webhook.New(mongo, config.Webhook),
+		export:     export.New(mongo, config.Export),
+		moderation: moderation.New(mongo, config.Moderation),
+		config:     config,
+		socket:     newSockets(),
+		limiter:    limit.New(config.Limit, backend),
+		activity:   newActivity(),
 	}
+	router.Use(server.rateLimitMiddleware)
+
 	server.Server = http.Server{
 		Addr:    server.config.Http.Address,
 		Handler: server.Router,
@@ -120,7 +148,32 @@ func (server Server) Ping() http.HandlerFunc {
 	}
 }
 
+// Key of the authentication result in the request context.
+type authenticationKey struct{}
+
+type authentication struct {
+	userID string
+	err    error
+}
+
+// Returns the request with the result of authenticating it, so later calls to authenticate reuse it.
+func withAuthentication(request *http.Request, userID string, err error) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), authenticationKey{}, authentication{
+		userID: userID,
+		err:    err,
+	}))
+}
+
 func (main Server) authenticate(request *http.Request) (string, error) {
+	// Requests are authenticated once, by the rate limiter in front of the handlers.
+	if result, ok := request.Context().Value(authenticationKey{}).(authentication); ok {
+		return result.userID, result.err
+	}
+
+	return main.verifySession(request)
+}
+
+func (main Server) verifySession(request *http.Request) (string, error) {
 	cookie, err := request.Cookie("session")
 	if err != nil {
 		return "", err
@@ -159,7 +212,19 @@ func (main Server) registerAll() {
 	main.RegisterWebsocketHandler(main.config.Http.DomainName)
 }
 
+// Migrates data stored by earlier versions of the server.
+func (server Server) Migrate() error {
+	return server.chat.MigrateRelationships()
+}
+
 func (server Server) Start() {
+	go server.ExpireFiles()
+	go server.DeliverScheduled()
+	go server.DetectIdle()
+	go server.ExpireCalls()
+	go server.RunExports()
+	server.DeliverWebhooks()
+
 	logrus.Trace("started http server")
 	err := server.ListenAndServe()
 	if err != nil {
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"errors"
+	"kevlar/module/attr"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticateReusesResult(t *testing.T) {
+	var main Server
+
+	// Without a stored result the session cookie is checked.
+	request := httptest.NewRequest("GET", "/chat/contacts", nil)
+	if _, err := main.authenticate(request); err == nil {
+		t.Fatal("authenticated a request without a session")
+	}
+
+	authenticated := withAuthentication(request, "user", nil)
+	if userID, err := main.authenticate(authenticated); err != nil || userID != "user" {
+		t.Fatalf("authenticate = %q, %v, expected the stored user", userID, err)
+	}
+
+	expired := withAuthentication(request, "", attr.ErrSessionExpired)
+	if _, err := main.authenticate(expired); !errors.Is(err, attr.ErrSessionExpired) {
+		t.Fatalf("expected the stored error, got %v", err)
+	}
+}
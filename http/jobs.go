@@ -0,0 +1,115 @@
+package http
+
+import (
+	"errors"
+	"kevlar/module/chat"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	expiryInterval   = time.Minute
+	scheduleInterval = 5 * time.Second
+)
+
+// Removes the files of expired disappearing messages from the store. Each tick works through
+// the expired files in batches until a batch comes back short, so a backlog never outgrows it.
+func (main Server) ExpireFiles() {
+	log := logrus.WithField("method", "expireFiles")
+
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			files, err := main.chat.ExpiredFiles()
+			if err != nil {
+				log.WithError(err).Error("error while getting expired files")
+				break
+			}
+
+			removed := 0
+			for _, expired := range files {
+				if main.expireFile(expired) {
+					removed++
+				}
+			}
+
+			// Entries that could not be removed would come back in the next batch, they wait for the next tick.
+			if len(files) < chat.MaxResults || removed < len(files) {
+				break
+			}
+		}
+	}
+}
+
+// Deletes the expired file from the store unless a message that has not expired still
+// holds it, and removes its entry. Reports whether the entry was removed.
+func (main Server) expireFile(expired chat.ExpiringFile) bool {
+	log := logrus.WithField("method", "expireFile").WithField("userID", expired.Owner)
+
+	attached, err := main.chat.FileAttached(expired.Owner, expired.FileID)
+	if err != nil {
+		log.WithError(err).Error("error while checking expired file")
+		return false
+	}
+
+	if !attached {
+		// The file may already have been deleted by its owner.
+		err = main.store.DeleteOne(expired.Owner, expired.FileID)
+		if err != nil {
+			log.WithError(err).Trace("error while deleting expired file")
+		}
+	}
+
+	err = main.chat.RemoveExpiredFile(expired)
+	if err != nil {
+		log.WithError(err).Error("error while removing expired file entry")
+		return false
+	}
+
+	return true
+}
+
+// Sends scheduled messages once they are due. Jobs are kept in mongo, so the
+// ones that came due while the server was stopped are sent on the next start.
+func (main Server) DeliverScheduled() {
+	log := logrus.WithField("method", "deliverScheduled")
+
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			scheduled, err := main.chat.ClaimScheduled()
+			if errors.Is(err, chat.ErrScheduledDoesNotExist) {
+				break
+			}
+			if err != nil {
+				log.WithError(err).Error("error while claiming scheduled message")
+				break
+			}
+
+			// Fails if the contact was removed or blocked since the message was scheduled.
+			message, failure := main.deliver(scheduled.From, scheduled.To, scheduled.Message)
+			if failure != nil {
+				log.WithField("userID", scheduled.From).WithError(failure).Trace("scheduled message failed")
+			}
+
+			scheduled, err = main.chat.CompleteScheduled(scheduled, message.ID, failure)
+			if err != nil {
+				log.WithField("userID", scheduled.From).WithError(err).Error("error while completing scheduled message")
+				continue
+			}
+
+			main.WriteMessage(scheduled.From, struct {
+				Head string      `json:"head"`
+				Data interface{} `json:"data"`
+			}{
+				Head: chat.ScheduledUpdate,
+				Data: scheduled,
+			})
+		}
+	}
+}
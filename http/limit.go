@@ -0,0 +1,121 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"kevlar/module/limit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RateLimited = "rate_limited"
+)
+
+var (
+	ErrRateLimited = errors.New("too many requests")
+)
+
+// Limit classes of routes, routes not listed here use the default class.
+var routeClasses = map[string]string{
+	"/auth/{action}":         limit.Auth,
+	"/chat/message/{userID}": limit.Message,
+	"/chat/search":           limit.Search,
+	"/chat/search/messages":  limit.Search,
+	"/store/upload":          limit.Upload,
+}
+
+func seconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// Returns the address of the client, taken from the proxy in front of the server when trusted.
+func (main Server) remoteAddress(request *http.Request) string {
+	if main.limiter.TrustProxy {
+		forwarded := strings.Split(request.Header.Get("X-Forwarded-For"), ",")
+		if address := strings.TrimSpace(forwarded[len(forwarded)-1]); address != "" {
+			return address
+		}
+	}
+
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// Limits requests per route class, by user for authenticated requests and by remote address
+// otherwise. Authentication routes are always limited by address, so that failed logins
+// count against the client and not the account.
+func (main Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	log := logrus.WithField("method", "rateLimit")
+
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Method == "OPTIONS" {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		class := limit.Default
+		if route := mux.CurrentRoute(request); route != nil {
+			template, err := route.GetPathTemplate()
+			if err == nil && routeClasses[template] != "" {
+				class = routeClasses[template]
+			}
+		}
+
+		key := "ip:" + main.remoteAddress(request)
+		if class != limit.Auth {
+			userID, err := main.authenticate(request)
+			if err == nil {
+				key = "user:" + userID
+			}
+			request = withAuthentication(request, userID, err)
+		}
+
+		result, err := main.limiter.Take(class, key)
+		if err != nil {
+			// A failing backend does not take the server down with it.
+			log.WithError(err).Error("error while taking rate limit token")
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		if rule, ok := main.limiter.Rule(class); ok && main.limiter.Enabled {
+			// Both report the bucket: Burst tokens, refilled in full within the window.
+			response.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit, rule.Window()))
+			response.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			response.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			response.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		}
+
+		if !result.Allowed {
+			response.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+
+			handler := errorHandler(response, request, log.WithField("class", class))
+			handler(ErrRateLimited, 429, "rate limit exceeded")
+			return
+		}
+
+		next.ServeHTTP(response, request)
+	})
+}
+
+// Takes a token for a websocket method of userID. Methods use their own class when one
+// is configured for them, and share the websocket class otherwise.
+func (main Server) allowSocket(userID, head string) (limit.Result, error) {
+	class := limit.Websocket + "." + head
+	if _, ok := main.limiter.Classes[class]; !ok {
+		class = limit.Websocket
+	}
+
+	return main.limiter.Take(class, "user:"+userID)
+}
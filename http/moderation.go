@@ -0,0 +1,344 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/auth"
+	"kevlar/module/chat"
+	"kevlar/module/moderation"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	AccountWarning = "account_warning"
+
+	moderationPageSize = 50
+)
+
+// Queues a stored message the filters flagged for review by an admin.
+func (main Server) flag(message chat.Message, toUserID string, result moderation.Result) {
+	_, err := main.moderation.Enqueue(moderation.Message{
+		From: message.From,
+		To:   toUserID,
+		Type: message.Type,
+		Data: message.Data,
+	}, message.ID, result.Notes)
+	if err != nil {
+		logrus.WithField("messageID", message.ID).WithError(err).Error("error while queueing flagged message")
+	}
+}
+
+// Disables the account of userID, ending its session and websocket connection.
+func (main Server) disable(userID string) error {
+	err := main.auth.SetDisabled(userID, true)
+	if err != nil {
+		return err
+	}
+
+	err = main.attr.DeleteSession(userID)
+	if err != nil {
+		return err
+	}
+
+	if socket, ok := main.socket.Get(userID); ok {
+		socket.Close <- true
+	}
+
+	return nil
+}
+
+// Files a report of an account, optionally with messages of the conversation with it.
+func (main Server) Report() http.HandlerFunc {
+	type Request struct {
+		Reason     string   `json:"reason"`
+		Details    string   `json:"details,omitempty"`
+		MessageIDs []string `json:"message_ids,omitempty"`
+	}
+
+	type Response struct {
+		ID string `json:"id"`
+	}
+
+	log := logrus.WithField("method", "report")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		target, ok := args["userID"]
+		if !ok {
+			handler(errors.New("userID not present"), 404, "userID not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		messageIDs, err := moderation.CheckReport(userID, target, requestData.Reason, requestData.Details, requestData.MessageIDs)
+		if err != nil {
+			handler(err, 400, "error while checking report")
+			return
+		}
+
+		_, err = main.chat.GetInformation(target)
+		if err != nil {
+			handler(err, 404, "error while loading reported user")
+			return
+		}
+
+		// Reported messages are copied, so deleting them later does not destroy the evidence.
+		var evidence []chat.Message
+		if len(messageIDs) != 0 {
+			evidence, err = main.chat.Messages(userID, target, messageIDs)
+			if err != nil {
+				if errors.Is(err, chat.ErrMessageDoesNotExist) || errors.Is(err, chat.ErrContactDoesNotExist) {
+					handler(err, 404, "error while loading reported messages")
+					return
+				}
+				handler(err, 400, "error while loading reported messages")
+				return
+			}
+		}
+
+		report, err := main.moderation.Report(userID, target, requestData.Reason, requestData.Details, evidence)
+		if err != nil {
+			if errors.Is(err, moderation.ErrAlreadyReported) {
+				handler(err, 409, "error while filing report")
+				return
+			}
+			handler(err, 400, "error while filing report")
+			return
+		}
+
+		data, err := json.Marshal(Response{
+			ID: report.ID,
+		})
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(201)
+		response.Write(data)
+	}
+}
+
+// Review queue of flagged messages and reports, only open to admins.
+func (main Server) Moderation() http.HandlerFunc {
+	type Request struct {
+		ID       string    `json:"id,omitempty"`
+		UserID   string    `json:"userID,omitempty"`
+		Status   string    `json:"status,omitempty"`
+		Decision string    `json:"decision,omitempty"`
+		Note     string    `json:"note,omitempty"`
+		Before   time.Time `json:"before,omitempty"`
+	}
+
+	log := logrus.WithField("method", "moderation")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		if !main.auth.IsAdmin(userID) {
+			handler(auth.ErrNotAdmin, 403, "error while authorizing user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		var responseData interface{}
+
+		if action == "queue" {
+			responseData, err = main.moderation.Queue(requestData.Status, requestData.Before, moderationPageSize)
+			if err != nil {
+				handler(err, 400, "error while loading review queue")
+				return
+			}
+
+		} else if action == "review" {
+			flagged, err := main.moderation.Review(requestData.ID, userID, requestData.Decision)
+			if err != nil {
+				if errors.Is(err, moderation.ErrFlaggedDoesNotExist) {
+					handler(err, 404, "error while reviewing message")
+					return
+				}
+				handler(err, 400, "error while reviewing message")
+				return
+			}
+
+			if flagged.Status == moderation.ReviewRemoved {
+				// The message may already have been removed with its conversation.
+				err = main.chat.RemoveMessage(flagged.From, flagged.To, flagged.MessageID)
+				if err != nil && !errors.Is(err, chat.ErrMessageDoesNotExist) && !errors.Is(err, chat.ErrContactDoesNotExist) {
+					handler(err, 400, "error while removing message")
+					return
+				}
+
+				for _, participant := range []string{flagged.From, flagged.To} {
+					main.WriteMessage(participant, struct {
+						Head string      `json:"head"`
+						Data interface{} `json:"data"`
+					}{
+						Head: chat.MessageRemoved,
+						Data: struct {
+							ID     string `json:"id"`
+							UserID string `json:"userID"`
+						}{
+							ID:     flagged.MessageID,
+							UserID: flagged.Peer(participant),
+						},
+					})
+				}
+			}
+
+			responseData = flagged
+
+		} else if action == "reports" {
+			responseData, err = main.moderation.Reports(requestData.Status, requestData.UserID, requestData.Before, moderationPageSize)
+			if err != nil {
+				handler(err, 400, "error while loading reports")
+				return
+			}
+
+		} else if action == "report" {
+			responseData, err = main.moderation.GetReport(requestData.ID)
+			if err != nil {
+				if errors.Is(err, moderation.ErrReportDoesNotExist) {
+					handler(err, 404, "error while loading report")
+					return
+				}
+				handler(err, 400, "error while loading report")
+				return
+			}
+
+		} else if action == "decide" {
+			// The account is disabled before the decision is recorded, so a failure leaves
+			// the report open to retry and the audit trail never claims what did not happen.
+			if requestData.Decision == moderation.ActionDisable {
+				open, err := main.moderation.GetReport(requestData.ID)
+				if err == nil && open.Status != moderation.ReportOpen {
+					err = moderation.ErrReportDoesNotExist
+				}
+				if err != nil {
+					if errors.Is(err, moderation.ErrReportDoesNotExist) {
+						handler(err, 404, "error while deciding report")
+						return
+					}
+					handler(err, 400, "error while deciding report")
+					return
+				}
+
+				err = main.disable(open.Target)
+				if err != nil {
+					handler(err, 400, "error while disabling account")
+					return
+				}
+			}
+
+			report, err := main.moderation.Decide(requestData.ID, userID, requestData.Decision, requestData.Note)
+			if err != nil {
+				if errors.Is(err, moderation.ErrReportDoesNotExist) {
+					handler(err, 404, "error while deciding report")
+					return
+				}
+				handler(err, 400, "error while deciding report")
+				return
+			}
+
+			if requestData.Decision == moderation.ActionWarn {
+				main.WriteMessage(report.Target, struct {
+					Head string      `json:"head"`
+					Data interface{} `json:"data"`
+				}{
+					Head: AccountWarning,
+					Data: struct {
+						Reason string `json:"reason"`
+						Note   string `json:"note,omitempty"`
+					}{
+						Reason: report.Reason,
+						Note:   report.Note,
+					},
+				})
+			}
+
+			responseData = report
+
+		} else if action == "audit" {
+			responseData, err = main.moderation.AuditTrail(requestData.UserID, requestData.Before, moderationPageSize)
+			if err != nil {
+				handler(err, 400, "error while loading audit trail")
+				return
+			}
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
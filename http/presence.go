@@ -0,0 +1,292 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/chat"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	Activity = "activity"
+)
+
+var (
+	idleAfter    = 5 * time.Minute
+	idleInterval = 30 * time.Second
+)
+
+// Last websocket activity of connected users, users without activity for idleAfter are idle.
+type activity struct {
+	lock *sync.Mutex
+	last map[string]time.Time
+	idle map[string]bool
+}
+
+func newActivity() *activity {
+	return &activity{
+		lock: &sync.Mutex{},
+		last: make(map[string]time.Time),
+		idle: make(map[string]bool),
+	}
+}
+
+// Records activity of userID, returning true if the user was idle.
+func (activity *activity) touch(userID string) bool {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	activity.last[userID] = time.Now()
+
+	idle := activity.idle[userID]
+	delete(activity.idle, userID)
+
+	return idle
+}
+
+func (activity *activity) forget(userID string) {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	delete(activity.last, userID)
+	delete(activity.idle, userID)
+}
+
+// Marks users without recent activity as idle and returns the newly idle users.
+func (activity *activity) sweep() []string {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	var idle []string
+
+	for userID, last := range activity.last {
+		if !activity.idle[userID] && time.Since(last) > idleAfter {
+			activity.idle[userID] = true
+			idle = append(idle, userID)
+		}
+	}
+
+	return idle
+}
+
+func (activity *activity) isIdle(userID string) bool {
+	activity.lock.Lock()
+	defer activity.lock.Unlock()
+
+	return activity.idle[userID]
+}
+
+// Returns the websocket connection state of userID.
+func (main Server) connection(userID string) chat.Connection {
+	return chat.Connection{
+		Online: main.IsOnline(userID),
+		Idle:   main.activity.isIdle(userID),
+	}
+}
+
+// Records websocket activity of userID, telling contacts when the user is back from idle.
+func (main Server) touch(userID string) {
+	if main.activity.touch(userID) {
+		err := main.broadcastPresence(userID, main.connection(userID))
+		if err != nil {
+			logrus.WithField("userID", userID).WithError(err).Error("error while broadcasting presence")
+		}
+	}
+}
+
+// Sends the presence of userID to viewer.
+func (main Server) sendPresence(userID, viewer string, connection chat.Connection) error {
+	presence, err := main.chat.ViewPresence(userID, viewer, connection)
+	if err != nil {
+		return err
+	}
+
+	main.WriteMessage(viewer, struct {
+		Head string      `json:"head"`
+		Data interface{} `json:"data"`
+	}{
+		Head: OnlineStatus,
+		Data: presence,
+	})
+
+	return nil
+}
+
+// Sends the presence of userID to every contact who has not been blocked by the user.
+func (main Server) broadcastPresence(userID string, connection chat.Connection) error {
+	contacts, err := main.chat.Contacts(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, contact := range contacts {
+		// Blocked users do not see the presence of the user.
+		blocked, err := main.chat.IsBlockedBy(contact.UserID, userID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			continue
+		}
+
+		err = main.sendPresence(userID, contact.UserID, connection)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tells contacts of users who stopped being active that they are away.
+func (main Server) DetectIdle() {
+	log := logrus.WithField("method", "detectIdle")
+
+	ticker := time.NewTicker(idleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, userID := range main.activity.sweep() {
+			err := main.broadcastPresence(userID, main.connection(userID))
+			if err != nil {
+				log.WithField("userID", userID).WithError(err).Error("error while broadcasting presence")
+			}
+		}
+	}
+}
+
+// Socket methods sent on behalf of the user, other frames such as keepalives and delivery
+// receipts are sent by clients on their own and do not keep the user active.
+var userActions = map[string]bool{
+	Activity:           true,
+	TypingStatusUpdate: true,
+	MessageRead:        true,
+	CallInvite:         true,
+	CallAccept:         true,
+	CallReject:         true,
+	CallHangup:         true,
+}
+
+// Socket method clients send on user input, so users reading without sending stay active.
+func (main Server) Activity() SocketHandler {
+	return func(userID string, data json.RawMessage) (interface{}, error) {
+		return nil, nil
+	}
+}
+
+func (main Server) Presence() http.HandlerFunc {
+	type Request struct {
+		State    string `json:"state,omitempty"`
+		Text     string `json:"text,omitempty"`
+		Emoji    string `json:"emoji,omitempty"`
+		Duration int64  `json:"duration,omitempty"` // seconds, zero keeps the status until cleared
+	}
+
+	log := logrus.WithField("method", "setPresence")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		if action == "state" {
+			err = main.chat.SetPresence(userID, requestData.State)
+			if err != nil {
+				handler(err, 400, "error while setting presence")
+				return
+			}
+
+			// Contacts last saw the user when they went invisible.
+			if requestData.State == chat.PresenceInvisible && main.IsOnline(userID) {
+				err = main.attr.SetAttribute(userID, chat.LastSeen, time.Now().Unix())
+				if err != nil {
+					handler(err, 400, "error while setting last seen")
+					return
+				}
+			}
+
+		} else if action == "status" {
+			status := &chat.CustomStatus{
+				Text:  requestData.Text,
+				Emoji: requestData.Emoji,
+			}
+			if requestData.Duration > 0 {
+				expires := time.Now().Add(time.Duration(requestData.Duration) * time.Second)
+				status.ExpiresAt = &expires
+			}
+
+			err = main.chat.SetStatus(userID, status)
+			if err != nil {
+				handler(err, 400, "error while setting status")
+				return
+			}
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		err = main.broadcastPresence(userID, main.connection(userID))
+		if err != nil {
+			handler(err, 400, "error while broadcasting presence")
+			return
+		}
+
+		presence, err := main.chat.GetPresence(userID)
+		if err != nil {
+			handler(err, 400, "error while getting presence")
+			return
+		}
+
+		data, err := json.Marshal(struct {
+			State  string             `json:"state"`
+			Status *chat.CustomStatus `json:"status,omitempty"`
+		}{
+			State:  presence.Mode,
+			Status: presence.Status,
+		})
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
@@ -0,0 +1,192 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/chat"
+	"kevlar/module/push"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rivo/uniseg"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Previews are cut to keep the notification well below the push payload limit.
+	maxPushPreview = 200
+)
+
+func (main Server) Push() http.HandlerFunc {
+	type Request struct {
+		Subscription push.Subscription `json:"subscription"`
+		Device       string            `json:"device"`
+	}
+
+	log := logrus.WithField("method", "webPush")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		if action == "key" {
+			key, err := main.push.PublicKey()
+			if err != nil {
+				handler(err, 500, "error while loading push key")
+				return
+			}
+
+			data, err := json.Marshal(struct {
+				PublicKey string `json:"public_key"`
+			}{
+				PublicKey: key,
+			})
+			if err != nil {
+				handler(err, 400, "error while marshalling response")
+				return
+			}
+
+			response.WriteHeader(200)
+			response.Write(data)
+			return
+
+		} else if action == "register" {
+			err = main.push.Validate(requestData.Subscription)
+			if err != nil {
+				handler(err, 400, "error while validating subscription")
+				return
+			}
+
+			requestData.Subscription.Device = requestData.Device
+
+			err = main.chat.Subscribe(userID, requestData.Subscription)
+			if err != nil {
+				handler(err, 400, "error while registering subscription")
+				return
+			}
+
+		} else if action == "unregister" {
+			err = main.chat.Unsubscribe(userID, requestData.Subscription.Endpoint)
+			if err != nil {
+				handler(err, 400, "error while unregistering subscription")
+				return
+			}
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		response.WriteHeader(201)
+	}
+}
+
+// Sends a push notification for the message to every device of userID, unless the conversation is muted.
+// Subscriptions the push service no longer knows are removed.
+func (main Server) notify(userID string, message chat.Message) {
+	log := logrus.WithFields(logrus.Fields{
+		"method": "notify",
+		"userID": userID,
+	})
+
+	muted, err := main.chat.Muted(userID, message.From)
+	if err != nil {
+		log.WithError(err).Error("error while checking mute")
+		return
+	}
+	if muted {
+		return
+	}
+
+	subscriptions, err := main.chat.Subscriptions(userID)
+	if err != nil {
+		log.WithError(err).Error("error while loading subscriptions")
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	from, err := main.chat.GetInformation(message.From)
+	if err != nil {
+		log.WithError(err).Error("error while loading sender")
+		return
+	}
+
+	preview := chat.Preview(message)
+
+	graphemes := uniseg.NewGraphemes(preview)
+	for count := 0; graphemes.Next(); count++ {
+		if count == maxPushPreview {
+			start, _ := graphemes.Positions()
+			preview = preview[:start] + "…"
+			break
+		}
+	}
+
+	payload, err := json.Marshal(struct {
+		Head string      `json:"head"`
+		Data interface{} `json:"data"`
+	}{
+		Head: chat.MessageIncoming,
+		Data: struct {
+			ID       string `json:"id"`
+			From     string `json:"from"`
+			Username string `json:"username"`
+			Type     string `json:"type"`
+			Preview  string `json:"preview"`
+			Thread   string `json:"thread,omitempty"`
+		}{
+			ID:       message.ID,
+			From:     message.From,
+			Username: from.Username,
+			Type:     message.Type,
+			Preview:  preview,
+			Thread:   message.Thread,
+		},
+	})
+	if err != nil {
+		log.WithError(err).Error("error while marshalling notification")
+		return
+	}
+
+	failures := main.push.SendAll(subscriptions, payload, func(subscription push.Subscription) error {
+		log.WithField("device", subscription.Device).Trace("removing expired push subscription")
+		return main.chat.Unsubscribe(userID, subscription.Endpoint)
+	})
+	for device, err := range failures {
+		log.WithField("device", device).WithError(err).Error("error while sending push notification")
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"hash/crc32"
 	"io"
 	"kevlar/module/attr"
+	"kevlar/module/audio"
 	"kevlar/module/file"
 	"kevlar/module/img"
+	"kevlar/module/webhook"
 	"net/http"
 	"path/filepath"
 	"strings"
@@ -104,6 +106,14 @@ func (main Server) Upload() http.HandlerFunc {
 
 		file := file.New(data, name, perm)
 
+		// Readable audio keeps its duration and waveform, so voice messages can be drawn without downloading them.
+		info, err := audio.Parse(data, main.config.Audio.WaveformSamples)
+		if err == nil {
+			file.Attributes.Audio = &info
+		} else if !errors.Is(err, audio.ErrUnsupportedFormat) {
+			log.WithError(err).Debug("error while reading audio")
+		}
+
 		err = main.store.Upload(userID, &file)
 		if err != nil {
 			handler(err, 400, "error while uploading file")
@@ -118,6 +128,20 @@ func (main Server) Upload() http.HandlerFunc {
 			return
 		}
 
+		main.publish(webhook.FileUploaded, []string{userID}, struct {
+			UserID string `json:"userID"`
+			FileID string `json:"fileID"`
+			Name   string `json:"name"`
+			Mime   string `json:"mime"`
+			Size   int    `json:"size"`
+		}{
+			UserID: userID,
+			FileID: file.Attributes.ID,
+			Name:   file.Attributes.Name,
+			Mime:   file.Attributes.Mime,
+			Size:   file.Attributes.Size,
+		})
+
 		log.Info("file uploaded")
 
 		response.WriteHeader(200)
@@ -0,0 +1,202 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/auth"
+	"kevlar/module/webhook"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	webhookInterval = time.Second
+	webhookWorkers  = 4
+)
+
+const webhookPageSize = 50
+
+// Queues the event for webhook subscribers, users are the users the event involves.
+// Failures are logged, webhooks never fail the request that caused the event.
+func (main Server) publish(event string, users []string, data interface{}) {
+	err := main.webhook.Publish(event, users, data)
+	if err != nil {
+		logrus.WithField("event", event).WithError(err).Error("error while publishing webhook event")
+	}
+}
+
+// Sends due webhook deliveries. Deliveries are kept in mongo, so several workers and
+// several instances of the server can share them.
+func (main Server) DeliverWebhooks() {
+	for worker := 0; worker < webhookWorkers; worker++ {
+		go main.deliverWebhooks()
+	}
+}
+
+func (main Server) deliverWebhooks() {
+	log := logrus.WithField("method", "deliverWebhooks")
+
+	ticker := time.NewTicker(webhookInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			delivery, err := main.webhook.Claim()
+			if errors.Is(err, webhook.ErrDeliveryDoesNotExist) {
+				break
+			}
+			if err != nil {
+				log.WithError(err).Error("error while claiming webhook delivery")
+				break
+			}
+
+			delivery, err = main.webhook.Send(delivery)
+			if errors.Is(err, webhook.ErrSubscriptionDoesNotExist) {
+				continue
+			}
+			if err != nil {
+				log.WithField("deliveryID", delivery.ID).WithError(err).Error("error while sending webhook delivery")
+				continue
+			}
+
+			if delivery.Status == webhook.DeliveryDead {
+				log.WithField("deliveryID", delivery.ID).Warn("webhook delivery ran out of attempts")
+			}
+		}
+	}
+}
+
+func (main Server) Webhook() http.HandlerFunc {
+	type Request struct {
+		ID     string    `json:"id,omitempty"`
+		URL    string    `json:"url,omitempty"`
+		Events []string  `json:"events,omitempty"`
+		All    bool      `json:"all,omitempty"`
+		Status string    `json:"status,omitempty"`
+		Before time.Time `json:"before,omitempty"`
+	}
+
+	log := logrus.WithField("method", "webhook")
+
+	return func(response http.ResponseWriter, request *http.Request) {
+
+		defer request.Body.Close()
+
+		// Register handlers
+		handler := errorHandler(response, request, log)
+
+		// Get vars
+		args := mux.Vars(request)
+		action, ok := args["action"]
+		if !ok {
+			handler(errors.New("action not present"), 404, "action not present")
+			return
+		}
+
+		// Authenticate user
+		userID, err := main.authenticate(request)
+		if err != nil {
+			if err == attr.ErrSessionExpired {
+				handler(err, 401, "error while verifying session")
+				return
+			}
+			handler(err, 400, "error while authenticating user")
+			return
+		}
+
+		// Get request data
+		var requestData Request
+		err = loadBody(request, &requestData)
+
+		if err != nil {
+			handler(err, 400, "error while parsing body")
+			return
+		}
+
+		var responseData interface{}
+
+		if action == "create" {
+			// Only admins receive events that do not involve them.
+			if requestData.All && !main.auth.IsAdmin(userID) {
+				handler(auth.ErrNotAdmin, 403, "error while creating webhook")
+				return
+			}
+
+			responseData, err = main.webhook.Subscribe(userID, requestData.URL, requestData.Events, requestData.All)
+			if err != nil {
+				if errors.Is(err, webhook.ErrTooManySubscriptions) {
+					handler(err, 409, "error while creating webhook")
+					return
+				}
+				handler(err, 400, "error while creating webhook")
+				return
+			}
+
+			data, err := json.Marshal(responseData)
+			if err != nil {
+				handler(err, 400, "error while marshalling response")
+				return
+			}
+
+			response.WriteHeader(201)
+			response.Write(data)
+			return
+
+		} else if action == "list" {
+			responseData, err = main.webhook.Subscriptions(userID)
+			if err != nil {
+				handler(err, 400, "error while listing webhooks")
+				return
+			}
+
+		} else if action == "delete" {
+			err = main.webhook.Unsubscribe(userID, requestData.ID)
+			if err != nil {
+				if errors.Is(err, webhook.ErrSubscriptionDoesNotExist) {
+					handler(err, 404, "error while deleting webhook")
+					return
+				}
+				handler(err, 400, "error while deleting webhook")
+				return
+			}
+
+			response.WriteHeader(200)
+			return
+
+		} else if action == "deliveries" {
+			responseData, err = main.webhook.Deliveries(userID, requestData.ID, requestData.Status, requestData.Before, webhookPageSize)
+			if err != nil {
+				handler(err, 400, "error while loading webhook deliveries")
+				return
+			}
+
+		} else if action == "redeliver" {
+			responseData, err = main.webhook.Redeliver(userID, requestData.ID)
+			if err != nil {
+				if errors.Is(err, webhook.ErrDeliveryDoesNotExist) {
+					handler(err, 404, "error while redelivering webhook")
+					return
+				}
+				handler(err, 400, "error while redelivering webhook")
+				return
+			}
+
+		} else {
+			main.NotFoundHandler.ServeHTTP(response, request)
+			return
+		}
+
+		data, err := json.Marshal(responseData)
+		if err != nil {
+			handler(err, 400, "error while marshalling response")
+			return
+		}
+
+		response.WriteHeader(200)
+		response.Write(data)
+	}
+}
@@ -1,9 +1,13 @@
 package http
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"kevlar/module/attr"
+	"kevlar/module/chat"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -20,6 +24,69 @@ type Socket struct {
 	Close chan bool
 }
 
+// Queues a message for the connection without waiting on it, so a socket busy replaying or
+// behind on a slow network never blocks the sender. A socket too far behind is closed, the
+// client reconnects and replays what it missed from the event log.
+func (socket *Socket) Queue(message interface{}) bool {
+	select {
+	case socket.Send <- message:
+		return true
+	default:
+	}
+
+	select {
+	case socket.Close <- true:
+	default:
+	}
+	return false
+}
+
+// Open websockets by userID, used by the websocket handler and by every goroutine sending events.
+type sockets struct {
+	lock *sync.RWMutex
+	open map[string]*Socket
+}
+
+func newSockets() *sockets {
+	return &sockets{
+		lock: &sync.RWMutex{},
+		open: make(map[string]*Socket),
+	}
+}
+
+// Returns the open socket of userID.
+func (sockets *sockets) Get(userID string) (*Socket, bool) {
+	sockets.lock.RLock()
+	defer sockets.lock.RUnlock()
+
+	socket, ok := sockets.open[userID]
+	return socket, ok
+}
+
+// Registers socket as the open socket of userID and returns the socket it replaced.
+func (sockets *sockets) Set(userID string, socket *Socket) (*Socket, bool) {
+	sockets.lock.Lock()
+	defer sockets.lock.Unlock()
+
+	old, ok := sockets.open[userID]
+	sockets.open[userID] = socket
+	return old, ok
+}
+
+// Removes socket if it is still the open socket of userID, returning true if it was.
+// A socket replaced by a newer connection leaves the newer one in place.
+func (sockets *sockets) Remove(userID string, socket *Socket) bool {
+	sockets.lock.Lock()
+	defer sockets.lock.Unlock()
+
+	if sockets.open[userID] != socket {
+		return false
+	}
+
+	delete(sockets.open, userID)
+	return true
+}
+
 var (
 	upgrader      = websocket.Upgrader{}
 	readDeadline  = 30 * time.Second
@@ -27,7 +94,22 @@ var (
 
 	pingDuration = 20 * time.Second
 
+	// Messages queued for a socket before it counts as too far behind.
+	sendBuffer = 64
+
 	ErrChannelClosed = errors.New("channel is closed")
+
+	// Events delivered live only, they are stale by the time a client could replay them.
+	// Call signaling is among them, only the end of a call and its log are kept.
+	ephemeral = map[string]bool{
+		TypingStatusUpdate: true,
+
+		CallIncoming: true,
+		CallRinging:  true,
+		CallAccepted: true,
+		CallSDP:      true,
+		CallICE:      true,
+	}
 )
 
 // Handler for realtime websocket connections.
@@ -51,15 +133,6 @@ func (main Server) WebSocketHandler() http.HandlerFunc {
 			return
 		}
 
-		// Close previous connection
-		old, ok := main.socket[userID]
-		if ok {
-			old.Close <- true
-
-			log.WithField("userID", userID).Trace("closing existing websocket connection")
-			old.Wait.Wait()
-		}
-
 		// Upgrade to websocket
 		conn, err := upgrader.Upgrade(response, request, nil)
 		if err != nil {
@@ -72,13 +145,37 @@ func (main Server) WebSocketHandler() http.HandlerFunc {
 		socket := Socket{
 			Wait: &sync.WaitGroup{},
 
-			Send:    make(chan interface{}, 10),
+			Send:    make(chan interface{}, sendBuffer),
 			Recieve: make(chan []byte, 10),
 
 			Close: make(chan bool, 10),
 		}
 
-		main.socket[userID] = &socket
+		// Close previous connection, it is replaced first so its closing does not disconnect the user.
+		old, ok := main.socket.Set(userID, &socket)
+		if ok {
+			old.Close <- true
+
+			log.WithField("userID", userID).Trace("closing existing websocket connection")
+			old.Wait.Wait()
+		}
+
+		// Removed on every way out, unless a newer connection replaced it meanwhile.
+		defer func() {
+			socket.Wait.Wait()
+			main.socket.Remove(userID, &socket)
+
+			logrus.WithField("userID", userID).Trace("connection closed")
+		}()
+
+		// Missed events are replayed before live traffic, live events already replayed are skipped.
+		replayed, err := main.replay(conn, userID, request.URL.Query().Get("last_seq"))
+		if err != nil {
+			log.WithField("userID", userID).WithError(err).Error("error while replaying events")
+
+			conn.Close()
+			return
+		}
 
 		// Websocket connection event
 		err = main.WebSocketConnected(userID)
@@ -122,6 +219,11 @@ func (main Server) WebSocketHandler() http.HandlerFunc {
 						return
 					}
 
+					event, isEvent := message.(queued)
+					if isEvent && event.seq <= replayed {
+						continue
+					}
+
 					conn.SetWriteDeadline(time.Now().Add(writeDeadline))
 
 					err = conn.WriteJSON(message)
@@ -153,36 +255,137 @@ func (main Server) WebSocketHandler() http.HandlerFunc {
 			return
 		}
 
+		// A connection replaced by a newer one of the same user leaves the user connected.
+		if !main.socket.Remove(userID, &socket) {
+			return
+		}
+
 		err = main.WebSocketDisconnected(userID)
 		if err != nil {
 			log.WithField("userID", userID).WithError(err).Error("error while calling websocket disconnected event")
 			return
 		}
+	}
+}
 
-		defer func() {
-			socket.Wait.Wait()
-			delete(main.socket, userID)
+// Event waiting to be written to a websocket, the payload already carries the sequence number.
+type queued struct {
+	seq     int64
+	payload json.RawMessage
+}
 
-			logrus.WithField("userID", userID).Trace("connection closed")
-		}()
+func (event queued) MarshalJSON() ([]byte, error) {
+	return event.payload, nil
+}
+
+// Adds the sequence number to an encoded event, events are always JSON objects.
+func stamp(seq int64, payload []byte) json.RawMessage {
+	return append([]byte(fmt.Sprintf(`{"seq":%d,`, seq)), payload[1:]...)
+}
+
+// Writes the events of userID after the sequence number last to the websocket and returns the latest
+// sequence number. Clients without a sequence number, or too far behind, are told where the log stands.
+func (main Server) replay(conn *websocket.Conn, userID, last string) (int64, error) {
+
+	sync := func(head string, seq int64) error {
+		conn.SetWriteDeadline(time.Now().Add(writeDeadline))
+
+		return conn.WriteJSON(struct {
+			Head string      `json:"head"`
+			Data interface{} `json:"data"`
+		}{
+			Head: head,
+			Data: struct {
+				Seq int64 `json:"seq"`
+			}{
+				Seq: seq,
+			},
+		})
+	}
+
+	if last == "" {
+		latest, err := main.chat.LatestEvent(userID)
+		if err != nil {
+			return 0, err
+		}
+
+		// Nothing is skipped, the client has not seen any events yet.
+		return 0, sync(chat.EventSync, latest)
+	}
+
+	seq, err := strconv.ParseInt(last, 10, 64)
+	if err != nil {
+		seq = -1
+	}
+
+	events, latest, err := main.chat.EventsSince(userID, seq)
+	if err == chat.ErrResyncRequired {
+		return latest, sync(chat.ResyncRequired, latest)
+	}
+	if err != nil {
+		return 0, err
 	}
+
+	for _, event := range events {
+		conn.SetWriteDeadline(time.Now().Add(writeDeadline))
+
+		err = conn.WriteMessage(websocket.TextMessage, stamp(event.Seq, event.Payload))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return latest, sync(chat.EventSync, latest)
 }
 
-// Try to write the message to websocket if user is online, otherwise throw error.
+// Logs the event for replay and writes it to the websocket if the user is online, otherwise throws error.
 func (main Server) WriteMessage(userID string, data interface{}) error {
-	sender, ok := main.socket[userID]
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var event struct {
+		Head string `json:"head"`
+	}
+	json.Unmarshal(payload, &event)
+
+	if ephemeral[event.Head] {
+		sender, ok := main.socket.Get(userID)
+		if !ok {
+			return ErrChannelClosed
+		}
+		sender.Queue(json.RawMessage(payload))
+		return nil
+	}
+
+	seq, err := main.chat.AppendEvent(userID, payload)
+	if err != nil {
+		logrus.WithField("userID", userID).WithError(err).Error("error while logging event")
+	}
+
+	sender, ok := main.socket.Get(userID)
 	if !ok {
 		return ErrChannelClosed
 	}
 
-	sender.Send <- data
+	// Events that could not be logged are still delivered live, without a sequence number.
+	if err != nil {
+		sender.Queue(json.RawMessage(payload))
+		return nil
+	}
+
+	sender.Queue(queued{
+		seq:     seq,
+		payload: stamp(seq, payload),
+	})
 
 	return nil
 }
 
 // Check if user is online (connected to websocket)
 func (main Server) IsOnline(userID string) bool {
-	_, ok := main.socket[userID]
+	_, ok := main.socket.Get(userID)
 	return ok
 }
 
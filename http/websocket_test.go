@@ -0,0 +1,86 @@
+package http
+
+import (
+	"fmt"
+	"kevlar/module/chat"
+	"sync"
+	"testing"
+)
+
+func TestSocketsRemoveKeepsNewer(t *testing.T) {
+	sockets := newSockets()
+
+	old, newer := &Socket{}, &Socket{}
+
+	sockets.Set("user", old)
+	sockets.Set("user", newer)
+
+	if sockets.Remove("user", old) {
+		t.Fatal("removed a socket that was already replaced")
+	}
+	if socket, ok := sockets.Get("user"); !ok || socket != newer {
+		t.Fatal("newer socket is no longer registered")
+	}
+	if !sockets.Remove("user", newer) {
+		t.Fatal("did not remove the open socket")
+	}
+	if _, ok := sockets.Get("user"); ok {
+		t.Fatal("socket still registered after removal")
+	}
+}
+
+func TestSocketsConcurrentAccess(t *testing.T) {
+	sockets := newSockets()
+
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+
+			userID := fmt.Sprint("user", i%2)
+			for j := 0; j < 1000; j++ {
+				socket := &Socket{}
+				sockets.Set(userID, socket)
+				sockets.Get(userID)
+				sockets.Remove(userID, socket)
+			}
+		}(i)
+	}
+	wait.Wait()
+}
+
+// Call signaling is only meaningful live, while the end of a call and its log are kept for replay.
+func TestCallEventsEphemeral(t *testing.T) {
+	for _, head := range []string{CallIncoming, CallRinging, CallAccepted, CallSDP, CallICE} {
+		if !ephemeral[head] {
+			t.Errorf("%s is logged", head)
+		}
+	}
+	for _, head := range []string{CallEnded, chat.MessageIncoming} {
+		if ephemeral[head] {
+			t.Errorf("%s is not logged", head)
+		}
+	}
+}
+
+// A full socket is closed instead of blocking the sender, the client then replays what it missed.
+func TestSocketQueueDoesNotBlock(t *testing.T) {
+	socket := &Socket{
+		Send:  make(chan interface{}, 1),
+		Close: make(chan bool, 1),
+	}
+
+	if !socket.Queue("first") {
+		t.Fatal("did not queue on an empty socket")
+	}
+	if socket.Queue("second") {
+		t.Fatal("queued on a full socket")
+	}
+	if len(socket.Close) != 1 {
+		t.Fatal("full socket was not closed")
+	}
+
+	// Closing twice does not block either.
+	socket.Queue("third")
+}
@@ -0,0 +1,90 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"math"
+)
+
+// Containers the server can read.
+const (
+	FormatWAV  = "wav"
+	FormatOpus = "opus"
+
+	// Waveform values range from zero to this.
+	MaxLevel = 100
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("audio format is not supported")
+	ErrInvalidAudio      = errors.New("audio file is malformed")
+	ErrTooLong           = errors.New("audio is longer than allowed")
+)
+
+type Config struct {
+	// Longest voice message in seconds.
+	MaxVoiceDuration int `default:"300"`
+	// Number of points in the waveform stored with voice messages.
+	WaveformSamples int `default:"64"`
+}
+
+// Details of an audio file kept in its metadata, enough for clients to draw a voice
+// message without downloading it.
+type Info struct {
+	Format     string  `bson:"format" json:"format"`
+	Duration   float64 `bson:"duration" json:"duration"` // seconds
+	SampleRate int     `bson:"sample_rate" json:"sample_rate"`
+	Channels   int     `bson:"channels" json:"channels"`
+	Waveform   []int   `bson:"waveform" json:"waveform"`
+}
+
+// Reads the container headers of data and computes a waveform of samples points.
+func Parse(data []byte, samples int) (Info, error) {
+	if len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WAVE")) {
+		return parseWAV(data, samples)
+	}
+	if len(data) >= 4 && bytes.Equal(data[0:4], []byte("OggS")) {
+		return parseOgg(data, samples)
+	}
+	return Info{}, ErrUnsupportedFormat
+}
+
+// Checks info against the voice message limits of config.
+func (config Config) Check(info Info) error {
+	if config.MaxVoiceDuration > 0 && info.Duration > float64(config.MaxVoiceDuration) {
+		return ErrTooLong
+	}
+	return nil
+}
+
+// Reduces levels to samples points, taking the loudest level of each span, and scales
+// them so the loudest point reaches MaxLevel.
+func downsample(levels []float64, samples int) []int {
+	waveform := []int{}
+	if samples <= 0 || len(levels) == 0 {
+		return waveform
+	}
+
+	if samples > len(levels) {
+		samples = len(levels)
+	}
+
+	points := make([]float64, samples)
+	loudest := 0.0
+
+	for index, level := range levels {
+		point := index * samples / len(levels)
+		points[point] = math.Max(points[point], level)
+		loudest = math.Max(loudest, level)
+	}
+
+	for _, point := range points {
+		if loudest == 0 {
+			waveform = append(waveform, 0)
+			continue
+		}
+		waveform = append(waveform, int(math.Round(point/loudest*MaxLevel)))
+	}
+
+	return waveform
+}
@@ -0,0 +1,41 @@
+package audio
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDownsample(t *testing.T) {
+	tests := []struct {
+		levels   []float64
+		samples  int
+		expected []int
+	}{
+		{nil, 4, []int{}},
+		{[]float64{1, 2}, 0, []int{}},
+		{[]float64{0, 0, 0}, 3, []int{0, 0, 0}},
+		{[]float64{1, 2}, 8, []int{50, 100}},
+		{[]float64{1, 4, 2, 2}, 2, []int{100, 50}},
+	}
+
+	for _, test := range tests {
+		if waveform := downsample(test.levels, test.samples); !reflect.DeepEqual(waveform, test.expected) {
+			t.Errorf("downsample(%v, %d) = %v, expected %v", test.levels, test.samples, waveform, test.expected)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	config := Config{MaxVoiceDuration: 60}
+
+	if err := config.Check(Info{Duration: 60}); err != nil {
+		t.Errorf("rejected a message of the maximum duration: %v", err)
+	}
+	if err := config.Check(Info{Duration: 60.5}); !errors.Is(err, ErrTooLong) {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+	if err := (Config{}).Check(Info{Duration: 3600}); err != nil {
+		t.Errorf("a limit of zero should allow any duration: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Opus always runs at 48kHz, granule positions count samples at this rate.
+const opusRate = 48000
+
+// Reads the pages of the first logical stream of an Ogg file holding Opus.
+//
+// Opus is not decoded, so the waveform is drawn from the size of each packet. Voice
+// encoders spend more bytes on louder and busier frames, which is close enough for
+// a preview.
+func parseOgg(data []byte, samples int) (Info, error) {
+	var serial uint32
+	var head []byte
+
+	granule := int64(-1)
+
+	// Sizes of complete packets, a packet continues onto the next page after a lacing value of 255.
+	var packets []float64
+	packet := 0
+
+	for offset := 0; offset < len(data); {
+		if offset+27 > len(data) || !bytes.Equal(data[offset:offset+4], []byte("OggS")) {
+			return Info{}, ErrInvalidAudio
+		}
+
+		segments := int(data[offset+26])
+		body := offset + 27 + segments
+		if body > len(data) {
+			return Info{}, ErrInvalidAudio
+		}
+
+		table := data[offset+27 : body]
+
+		size := 0
+		for _, lacing := range table {
+			size += int(lacing)
+		}
+		if body+size > len(data) {
+			return Info{}, ErrInvalidAudio
+		}
+
+		page_serial := binary.LittleEndian.Uint32(data[offset+14 : offset+18])
+
+		// The identification header is alone on the first page of the stream.
+		if head == nil {
+			serial = page_serial
+			head = data[body : body+size]
+		}
+
+		if page_serial == serial {
+			// Pages on which no packet ends have a granule position of -1.
+			if position := int64(binary.LittleEndian.Uint64(data[offset+6 : offset+14])); position != -1 {
+				granule = position
+			}
+
+			for _, lacing := range table {
+				packet += int(lacing)
+				if lacing < 255 {
+					packets = append(packets, float64(packet))
+					packet = 0
+				}
+			}
+		}
+
+		offset = body + size
+	}
+
+	if len(head) < 19 || !bytes.Equal(head[0:8], []byte("OpusHead")) {
+		return Info{}, ErrUnsupportedFormat
+	}
+
+	channels := int(head[9])
+	skip := int64(binary.LittleEndian.Uint16(head[10:12]))
+	rate := int(binary.LittleEndian.Uint32(head[12:16]))
+
+	// The identification and comment headers come before the audio packets.
+	if channels == 0 || len(packets) < 2 || granule < skip {
+		return Info{}, ErrInvalidAudio
+	}
+
+	// The input rate is informational, playback is at 48kHz when it is not given.
+	if rate == 0 {
+		rate = opusRate
+	}
+
+	return Info{
+		Format:     FormatOpus,
+		Duration:   float64(granule-skip) / opusRate,
+		SampleRate: rate,
+		Channels:   channels,
+		Waveform:   downsample(packets[2:], samples),
+	}, nil
+}
@@ -0,0 +1,105 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// Builds an Ogg page of one logical stream holding the given packets.
+func oggPage(serial uint32, granule int64, packets ...[]byte) []byte {
+	var table []byte
+	var body []byte
+
+	for _, packet := range packets {
+		size := len(packet)
+		for size >= 255 {
+			table = append(table, 255)
+			size -= 255
+		}
+		table = append(table, byte(size))
+		body = append(body, packet...)
+	}
+
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	binary.LittleEndian.PutUint64(header[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(header[14:18], serial)
+	header[26] = byte(len(table))
+
+	page := append(header, table...)
+	return append(page, body...)
+}
+
+func opusHead(channels int, skip uint16, rate uint32) []byte {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:12], skip)
+	binary.LittleEndian.PutUint32(head[12:16], rate)
+	return head
+}
+
+func TestParseOgg(t *testing.T) {
+	var data []byte
+	data = append(data, oggPage(7, 0, opusHead(1, 312, 16000))...)
+	data = append(data, oggPage(7, 0, []byte("OpusTags"))...)
+	// Packets of another stream in the same file are ignored.
+	data = append(data, oggPage(9, 0, make([]byte, 500))...)
+	data = append(data, oggPage(7, -1, make([]byte, 10))...)
+	data = append(data, oggPage(7, 312+2*opusRate, make([]byte, 300))...)
+
+	info, err := Parse(data, 2)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	expected := Info{
+		Format:     FormatOpus,
+		Duration:   2,
+		SampleRate: 16000,
+		Channels:   1,
+		Waveform:   []int{3, 100},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatalf("Parse = %+v, expected %+v", info, expected)
+	}
+}
+
+func TestParseOggInvalid(t *testing.T) {
+	valid := func(head []byte) []byte {
+		var data []byte
+		data = append(data, oggPage(1, 0, head)...)
+		data = append(data, oggPage(1, 0, []byte("OpusTags"))...)
+		data = append(data, oggPage(1, opusRate, make([]byte, 20))...)
+		return data
+	}
+
+	vorbis := append([]byte("\x01vorbis"), make([]byte, 23)...)
+
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"vorbis", valid(vorbis), ErrUnsupportedFormat},
+		{"no channels", valid(opusHead(0, 0, 48000)), ErrInvalidAudio},
+		{"truncated", valid(opusHead(1, 0, 48000))[:60], ErrInvalidAudio},
+		{"no audio", oggPage(1, 0, opusHead(1, 0, 48000)), ErrInvalidAudio},
+		{"not a container", []byte("ID3\x03 something"), ErrUnsupportedFormat},
+	}
+
+	for _, test := range tests {
+		if _, err := Parse(test.data, 8); !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, expected %v", test.name, err, test.err)
+		}
+	}
+
+	// The input rate defaults to 48kHz when not given.
+	info, err := Parse(valid(opusHead(2, 0, 0)), 8)
+	if err != nil || info.SampleRate != opusRate || info.Channels != 2 || info.Duration != 1 {
+		t.Errorf("unexpected info %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,144 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Sample formats of the WAVE fmt chunk.
+const (
+	wavePCM        = 0x0001
+	waveFloat      = 0x0003
+	waveExtensible = 0xfffe
+)
+
+// Reads the fmt and data chunks of a RIFF WAVE file. Only uncompressed samples are
+// supported, the waveform is the loudness of each span of samples.
+func parseWAV(data []byte, samples int) (Info, error) {
+	var format, channels, rate, align, bits int
+	var pcm []byte
+
+	found := false
+
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+
+		body := data[offset+8:]
+		if size > len(body) {
+			// Recorders that stream to disk may never fill in the size of the data chunk.
+			if id != "data" {
+				return Info{}, ErrInvalidAudio
+			}
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return Info{}, ErrInvalidAudio
+			}
+
+			format = int(binary.LittleEndian.Uint16(body[0:2]))
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			align = int(binary.LittleEndian.Uint16(body[12:14]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+
+			// Extensible files keep the actual format at the start of the sub format GUID.
+			if format == waveExtensible && size >= 26 {
+				format = int(binary.LittleEndian.Uint16(body[24:26]))
+			}
+			found = true
+
+		case "data":
+			pcm = body
+		}
+
+		offset += 8 + size + size%2
+	}
+
+	if !found || pcm == nil {
+		return Info{}, ErrInvalidAudio
+	}
+	if channels == 0 || rate == 0 || bits%8 != 0 || align < channels*bits/8 {
+		return Info{}, ErrInvalidAudio
+	}
+
+	sample := sampleReader(format, bits)
+	if sample == nil {
+		return Info{}, ErrUnsupportedFormat
+	}
+
+	frames := len(pcm) / align
+	width := bits / 8
+
+	if samples > frames {
+		samples = frames
+	}
+	if samples < 0 {
+		samples = 0
+	}
+
+	levels := make([]float64, samples)
+	counts := make([]int, samples)
+
+	for frame := 0; frame < frames && samples != 0; frame++ {
+		point := frame * samples / frames
+		start := frame * align
+
+		for channel := 0; channel < channels; channel++ {
+			value := sample(pcm[start+channel*width:])
+			levels[point] += value * value
+		}
+		counts[point] += channels
+	}
+
+	// Root mean square of each span.
+	for index := range levels {
+		if counts[index] != 0 {
+			levels[index] = math.Sqrt(levels[index] / float64(counts[index]))
+		}
+	}
+
+	return Info{
+		Format:     FormatWAV,
+		Duration:   float64(frames) / float64(rate),
+		SampleRate: rate,
+		Channels:   channels,
+		Waveform:   downsample(levels, samples),
+	}, nil
+}
+
+// Returns a reader of one sample scaled to [-1, 1], or nil for unsupported formats.
+func sampleReader(format, bits int) func(data []byte) float64 {
+	switch {
+	case format == wavePCM && bits == 8:
+		return func(data []byte) float64 {
+			return (float64(data[0]) - 128) / 128
+		}
+	case format == wavePCM && bits == 16:
+		return func(data []byte) float64 {
+			return float64(int16(binary.LittleEndian.Uint16(data))) / (1 << 15)
+		}
+	case format == wavePCM && bits == 24:
+		return func(data []byte) float64 {
+			value := int32(uint32(data[0])<<8|uint32(data[1])<<16|uint32(data[2])<<24) >> 8
+			return float64(value) / (1 << 23)
+		}
+	case format == wavePCM && bits == 32:
+		return func(data []byte) float64 {
+			return float64(int32(binary.LittleEndian.Uint32(data))) / (1 << 31)
+		}
+	case format == waveFloat && bits == 32:
+		return func(data []byte) float64 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+		}
+	case format == waveFloat && bits == 64:
+		return func(data []byte) float64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(data))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// Builds a RIFF WAVE file with a fmt chunk and the given data chunk.
+func wav(format, channels, rate, bits int, pcm []byte) []byte {
+	var buffer bytes.Buffer
+
+	chunk := func(id string, body []byte) {
+		buffer.WriteString(id)
+		binary.Write(&buffer, binary.LittleEndian, uint32(len(body)))
+		buffer.Write(body)
+		if len(body)%2 == 1 {
+			buffer.WriteByte(0)
+		}
+	}
+
+	align := channels * bits / 8
+
+	fmt := new(bytes.Buffer)
+	binary.Write(fmt, binary.LittleEndian, uint16(format))
+	binary.Write(fmt, binary.LittleEndian, uint16(channels))
+	binary.Write(fmt, binary.LittleEndian, uint32(rate))
+	binary.Write(fmt, binary.LittleEndian, uint32(rate*align))
+	binary.Write(fmt, binary.LittleEndian, uint16(align))
+	binary.Write(fmt, binary.LittleEndian, uint16(bits))
+
+	buffer.WriteString("RIFF")
+	binary.Write(&buffer, binary.LittleEndian, uint32(0))
+	buffer.WriteString("WAVE")
+	chunk("fmt ", fmt.Bytes())
+	chunk("data", pcm)
+
+	return buffer.Bytes()
+}
+
+// 16 bit samples, silent for the first half and at full scale for the second.
+func halfLoud(frames int) []byte {
+	pcm := new(bytes.Buffer)
+	for frame := 0; frame < frames; frame++ {
+		value := int16(0)
+		if frame >= frames/2 {
+			value = math.MaxInt16
+		}
+		binary.Write(pcm, binary.LittleEndian, value)
+	}
+	return pcm.Bytes()
+}
+
+func TestParseWAV(t *testing.T) {
+	info, err := Parse(wav(wavePCM, 1, 8000, 16, halfLoud(8000)), 4)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	expected := Info{
+		Format:     FormatWAV,
+		Duration:   1,
+		SampleRate: 8000,
+		Channels:   1,
+		Waveform:   []int{0, 0, 100, 100},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatalf("Parse = %+v, expected %+v", info, expected)
+	}
+}
+
+func TestParseWAVFormats(t *testing.T) {
+	frames := 4000
+
+	tests := []struct {
+		name     string
+		data     []byte
+		duration float64
+		err      error
+	}{
+		{"8 bit", wav(wavePCM, 1, 4000, 8, bytes.Repeat([]byte{128}, frames)), 1, nil},
+		{"16 bit stereo", wav(wavePCM, 2, 2000, 16, make([]byte, frames*4)), 2, nil},
+		{"24 bit", wav(wavePCM, 1, 4000, 24, make([]byte, frames*3)), 1, nil},
+		{"32 bit float", wav(waveFloat, 1, 4000, 32, make([]byte, frames*4)), 1, nil},
+		{"64 bit float", wav(waveFloat, 1, 4000, 64, make([]byte, frames*8)), 1, nil},
+		{"compressed", wav(0x0002, 1, 4000, 16, make([]byte, frames*2)), 0, ErrUnsupportedFormat},
+		{"no channels", wav(wavePCM, 0, 4000, 16, make([]byte, frames*2)), 0, ErrInvalidAudio},
+		{"no data chunk", wav(wavePCM, 1, 4000, 16, nil)[:36], 0, ErrInvalidAudio},
+	}
+
+	for _, test := range tests {
+		info, err := Parse(test.data, 16)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, expected %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && info.Duration != test.duration {
+			t.Errorf("%s: duration %v, expected %v", test.name, info.Duration, test.duration)
+		}
+	}
+}
+
+func TestParseWAVUnfinishedSize(t *testing.T) {
+	data := wav(wavePCM, 1, 8000, 16, make([]byte, 16000))
+
+	// Recorders streaming to disk leave the size of the data chunk at its maximum.
+	binary.LittleEndian.PutUint32(data[40:44], 0xffffffff)
+
+	info, err := Parse(data, 8)
+	if err != nil || info.Duration != 1 {
+		t.Fatalf("Parse = %+v, %v, expected a duration of 1s", info, err)
+	}
+}
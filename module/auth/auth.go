@@ -24,6 +24,7 @@ var (
 	ErrInvalidCharacter     = errors.New("invalid character in input")
 	ErrAccountDisabled      = errors.New("account is disabled")
 	ErrLoginIncorrect       = errors.New("login details incorrect")
+	ErrNotAdmin             = errors.New("user is not an admin")
 )
 
 type Config struct {
@@ -31,6 +32,8 @@ type Config struct {
 	InputLengthCheck bool   `default:"true"`
 	MaxInputLength   int    `default:"30"`
 	MinInputLength   int    `default:"7"`
+	// UserIDs allowed to administer the server.
+	Admins []string `default:"[]"`
 }
 
 type Auth struct {
@@ -45,6 +48,15 @@ func New(mongo *mongodb.MongoClient, config Config) Auth {
 	}
 }
 
+func (auth Auth) IsAdmin(userID string) bool {
+	for _, admin := range auth.Admins {
+		if admin == userID {
+			return true
+		}
+	}
+	return false
+}
+
 type User struct {
 	UserID   string `bson:"userID"`
 	Username string `bson:"username"`
@@ -139,6 +151,34 @@ func (auth Auth) Disabled(userID string) error {
 	return nil
 }
 
+// Disables or enables the account of userID. Disabling also revokes the relogin key,
+// so the user cannot log back in.
+func (auth Auth) SetDisabled(userID string, disabled bool) error {
+	context, cancel := auth.DefaultContext()
+	defer cancel()
+
+	result, err := auth.Database(mongodb.Users).Collection(mongodb.Accounts).UpdateOne(context, bson.D{
+		{Key: "userID", Value: userID},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "disabled", Value: disabled}}},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	if !disabled {
+		return nil
+	}
+
+	_, err = auth.Database(mongodb.Users).Collection(mongodb.Relogin).DeleteMany(context, bson.D{
+		{Key: "userID", Value: userID},
+	})
+	return err
+}
+
 func (auth Auth) Create(userID, username, password string) (string, error) {
 	context, cancel := auth.DefaultContext()
 	defer cancel()
@@ -0,0 +1,304 @@
+package call
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"kevlar/module/chat"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// States of a call, ended calls are forgotten.
+const (
+	StateInviting = "inviting"
+	StateRinging  = "ringing"
+	StateActive   = "active"
+	StateEnded    = "ended"
+)
+
+var (
+	ErrBusy             = errors.New("user is already in a call")
+	ErrSelfCall         = errors.New("cannot call self")
+	ErrCallDoesNotExist = errors.New("call does not exist")
+	ErrInvalidState     = errors.New("call is not in a state allowing this")
+)
+
+type Config struct {
+	// Seconds a call may ring before it is missed.
+	RingTimeout int      `default:"45"`
+	STUNServers []string `default:"[\"stun:stun.l.google.com:19302\"]"`
+	TURNServers []string `default:"[]"`
+	// Shared secret of the TURN server, used for time limited credentials.
+	TURNSecret    string `default:""`
+	CredentialTTL int    `default:"86400"`
+}
+
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+type Call struct {
+	ID     string `json:"call_id"`
+	Caller string `json:"caller"`
+	Callee string `json:"callee"`
+	Video  bool   `json:"video"`
+	State  string `json:"state"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	AnsweredAt *time.Time `json:"answered_at,omitempty"`
+
+	// Set once the call has ended.
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Returns the other participant of the call.
+func (call Call) Peer(userID string) string {
+	if userID == call.Caller {
+		return call.Callee
+	}
+	return call.Caller
+}
+
+// Log written into the conversation for the ended call.
+func (call Call) Log() chat.CallLog {
+	log := chat.CallLog{
+		CallID:  call.ID,
+		Video:   call.Video,
+		Outcome: call.Outcome,
+	}
+
+	if call.AnsweredAt != nil {
+		log.Duration = int64(time.Since(*call.AnsweredAt).Seconds())
+	}
+
+	return log
+}
+
+// Calls in progress, a user takes part in at most one call.
+type Calls struct {
+	Config
+
+	lock  *sync.Mutex
+	calls map[string]*Call
+	users map[string]string
+}
+
+func New(config Config) Calls {
+	return Calls{
+		Config: config,
+		lock:   &sync.Mutex{},
+		calls:  make(map[string]*Call),
+		users:  make(map[string]string),
+	}
+}
+
+func (calls Calls) end(call *Call, outcome string) Call {
+	call.State = StateEnded
+	call.Outcome = outcome
+
+	delete(calls.calls, call.ID)
+	delete(calls.users, call.Caller)
+	delete(calls.users, call.Callee)
+
+	return *call
+}
+
+// Returns the call with id if userID takes part in it.
+func (calls Calls) find(userID, id string) (*Call, error) {
+	call, ok := calls.calls[id]
+	if !ok || (call.Caller != userID && call.Callee != userID) {
+		return nil, ErrCallDoesNotExist
+	}
+	return call, nil
+}
+
+// Starts a call from caller to callee. ErrBusy is returned with the ended call when the callee
+// is already in a call, so that it can be logged.
+func (calls Calls) Invite(caller, callee string, video bool) (Call, error) {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	if caller == callee {
+		return Call{}, ErrSelfCall
+	}
+
+	if _, ok := calls.users[caller]; ok {
+		return Call{}, ErrBusy
+	}
+
+	call := &Call{
+		ID:        uuid.New().String(),
+		Caller:    caller,
+		Callee:    callee,
+		Video:     video,
+		State:     StateInviting,
+		CreatedAt: time.Now(),
+	}
+
+	if _, ok := calls.users[callee]; ok {
+		call.State = StateEnded
+		call.Outcome = chat.CallBusy
+		return *call, ErrBusy
+	}
+
+	calls.calls[call.ID] = call
+	calls.users[caller] = call.ID
+	calls.users[callee] = call.ID
+
+	return *call, nil
+}
+
+// Marks the call as ringing on a device of the callee.
+func (calls Calls) Ring(userID, id string) (Call, error) {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	call, err := calls.find(userID, id)
+	if err != nil {
+		return Call{}, err
+	}
+
+	if userID != call.Callee || call.State != StateInviting {
+		return Call{}, ErrInvalidState
+	}
+
+	call.State = StateRinging
+	return *call, nil
+}
+
+func (calls Calls) Accept(userID, id string) (Call, error) {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	call, err := calls.find(userID, id)
+	if err != nil {
+		return Call{}, err
+	}
+
+	if userID != call.Callee || (call.State != StateInviting && call.State != StateRinging) {
+		return Call{}, ErrInvalidState
+	}
+
+	now := time.Now()
+
+	call.State = StateActive
+	call.AnsweredAt = &now
+	return *call, nil
+}
+
+func (calls Calls) Reject(userID, id string) (Call, error) {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	call, err := calls.find(userID, id)
+	if err != nil {
+		return Call{}, err
+	}
+
+	if userID != call.Callee || (call.State != StateInviting && call.State != StateRinging) {
+		return Call{}, ErrInvalidState
+	}
+
+	return calls.end(call, chat.CallRejected), nil
+}
+
+// Ends the call for both participants. Calls hung up before they were answered are
+// cancelled by the caller, or rejected by the callee.
+func (calls Calls) Hangup(userID, id string) (Call, error) {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	call, err := calls.find(userID, id)
+	if err != nil {
+		return Call{}, err
+	}
+
+	return calls.hangup(userID, call), nil
+}
+
+func (calls Calls) hangup(userID string, call *Call) Call {
+	switch {
+	case call.State == StateActive:
+		return calls.end(call, chat.CallCompleted)
+	case userID == call.Caller:
+		return calls.end(call, chat.CallCancelled)
+	}
+	return calls.end(call, chat.CallRejected)
+}
+
+// Ends the call of userID, if any, as if they hung up.
+func (calls Calls) Leave(userID string) (Call, bool) {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	id, ok := calls.users[userID]
+	if !ok {
+		return Call{}, false
+	}
+
+	return calls.hangup(userID, calls.calls[id]), true
+}
+
+// Returns the call for relaying session descriptions and candidates between its participants.
+func (calls Calls) Relay(userID, id string) (Call, error) {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	call, err := calls.find(userID, id)
+	if err != nil {
+		return Call{}, err
+	}
+
+	return *call, nil
+}
+
+// Ends the calls which rang for longer than the ring timeout as missed, and returns them.
+func (calls Calls) Expired() []Call {
+	calls.lock.Lock()
+	defer calls.lock.Unlock()
+
+	timeout := time.Duration(calls.RingTimeout) * time.Second
+
+	var expired []Call
+
+	for _, call := range calls.calls {
+		if call.State != StateActive && time.Since(call.CreatedAt) > timeout {
+			expired = append(expired, calls.end(call, chat.CallMissed))
+		}
+	}
+
+	return expired
+}
+
+// Returns the ICE servers for userID. TURN credentials follow the REST API scheme of
+// coturn, a username of expiry:userID signed with the shared secret.
+func (calls Calls) ICEServers(userID string) []ICEServer {
+	servers := []ICEServer{}
+
+	if len(calls.STUNServers) != 0 {
+		servers = append(servers, ICEServer{URLs: calls.STUNServers})
+	}
+
+	if len(calls.TURNServers) != 0 && calls.TURNSecret != "" {
+		expiry := time.Now().Add(time.Duration(calls.CredentialTTL) * time.Second).Unix()
+		username := fmt.Sprintf("%d:%s", expiry, userID)
+
+		mac := hmac.New(sha1.New, []byte(calls.TURNSecret))
+		mac.Write([]byte(username))
+
+		servers = append(servers, ICEServer{
+			URLs:       calls.TURNServers,
+			Username:   username,
+			Credential: base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+		})
+	}
+
+	return servers
+}
@@ -0,0 +1,231 @@
+package call
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"kevlar/module/chat"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCalls() Calls {
+	return New(Config{
+		RingTimeout:   45,
+		STUNServers:   []string{"stun:stun.example.com:3478"},
+		TURNServers:   []string{"turn:turn.example.com:3478"},
+		TURNSecret:    "secret",
+		CredentialTTL: 3600,
+	})
+}
+
+func invite(t *testing.T, calls Calls) Call {
+	t.Helper()
+
+	call, err := calls.Invite("alice", "bob", true)
+	if err != nil {
+		t.Fatalf("Invite: %v", err)
+	}
+	if call.State != StateInviting || call.Peer("alice") != "bob" || call.Peer("bob") != "alice" {
+		t.Fatalf("unexpected call %+v", call)
+	}
+	return call
+}
+
+func TestInvite(t *testing.T) {
+	calls := testCalls()
+
+	if _, err := calls.Invite("alice", "alice", false); !errors.Is(err, ErrSelfCall) {
+		t.Fatalf("expected ErrSelfCall, got %v", err)
+	}
+
+	invite(t, calls)
+
+	if _, err := calls.Invite("alice", "carol", false); !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected ErrBusy for a caller in a call, got %v", err)
+	}
+
+	// A busy callee ends the new call right away, so it can be logged.
+	busy, err := calls.Invite("carol", "bob", false)
+	if !errors.Is(err, ErrBusy) || busy.State != StateEnded || busy.Outcome != chat.CallBusy {
+		t.Fatalf("expected a busy call, got %+v, %v", busy, err)
+	}
+	if _, err := calls.Invite("carol", "dave", false); err != nil {
+		t.Fatalf("busy call was kept for the caller: %v", err)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	calls := testCalls()
+	call := invite(t, calls)
+
+	if _, err := calls.Accept("alice", call.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("caller accepted the call: %v", err)
+	}
+	if _, err := calls.Ring("alice", call.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("caller rang the call: %v", err)
+	}
+	if _, err := calls.Accept("carol", call.ID); !errors.Is(err, ErrCallDoesNotExist) {
+		t.Fatalf("outsider accepted the call: %v", err)
+	}
+
+	ringing, err := calls.Ring("bob", call.ID)
+	if err != nil || ringing.State != StateRinging {
+		t.Fatalf("Ring: %+v, %v", ringing, err)
+	}
+	if _, err := calls.Ring("bob", call.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("rang a ringing call: %v", err)
+	}
+
+	active, err := calls.Accept("bob", call.ID)
+	if err != nil || active.State != StateActive || active.AnsweredAt == nil {
+		t.Fatalf("Accept: %+v, %v", active, err)
+	}
+	if _, err := calls.Reject("bob", call.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("rejected an active call: %v", err)
+	}
+
+	ended, err := calls.Hangup("alice", call.ID)
+	if err != nil || ended.State != StateEnded || ended.Outcome != chat.CallCompleted {
+		t.Fatalf("Hangup: %+v, %v", ended, err)
+	}
+	if _, err := calls.Relay("bob", call.ID); !errors.Is(err, ErrCallDoesNotExist) {
+		t.Fatalf("ended call still exists: %v", err)
+	}
+}
+
+func TestReject(t *testing.T) {
+	calls := testCalls()
+	call := invite(t, calls)
+
+	if _, err := calls.Reject("alice", call.ID); !errors.Is(err, ErrInvalidState) {
+		t.Fatalf("caller rejected the call: %v", err)
+	}
+
+	ended, err := calls.Reject("bob", call.ID)
+	if err != nil || ended.Outcome != chat.CallRejected {
+		t.Fatalf("Reject: %+v, %v", ended, err)
+	}
+
+	// Both participants are free again.
+	invite(t, calls)
+}
+
+func TestHangupOutcome(t *testing.T) {
+	tests := []struct {
+		name    string
+		by      string
+		accept  bool
+		outcome string
+	}{
+		{"caller before answer", "alice", false, chat.CallCancelled},
+		{"callee before answer", "bob", false, chat.CallRejected},
+		{"caller after answer", "alice", true, chat.CallCompleted},
+		{"callee after answer", "bob", true, chat.CallCompleted},
+	}
+
+	for _, test := range tests {
+		calls := testCalls()
+		call := invite(t, calls)
+
+		if test.accept {
+			if _, err := calls.Accept("bob", call.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ended, err := calls.Hangup(test.by, call.ID)
+		if err != nil || ended.Outcome != test.outcome {
+			t.Errorf("%s: outcome %q, %v, expected %q", test.name, ended.Outcome, err, test.outcome)
+		}
+	}
+}
+
+func TestLeave(t *testing.T) {
+	calls := testCalls()
+
+	if _, ok := calls.Leave("alice"); ok {
+		t.Fatal("left a call without being in one")
+	}
+
+	call := invite(t, calls)
+	calls.Accept("bob", call.ID)
+
+	ended, ok := calls.Leave("bob")
+	if !ok || ended.ID != call.ID || ended.Outcome != chat.CallCompleted {
+		t.Fatalf("Leave: %+v, %v", ended, ok)
+	}
+	if _, ok := calls.Leave("alice"); ok {
+		t.Fatal("call still exists for the other participant")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	calls := testCalls()
+
+	ringing := invite(t, calls)
+	calls.Ring("bob", ringing.ID)
+
+	active, err := calls.Invite("carol", "dave", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls.Accept("dave", active.ID)
+
+	if expired := calls.Expired(); len(expired) != 0 {
+		t.Fatalf("calls expired before the ring timeout: %+v", expired)
+	}
+
+	for _, call := range calls.calls {
+		call.CreatedAt = time.Now().Add(-time.Minute)
+	}
+
+	expired := calls.Expired()
+	if len(expired) != 1 || expired[0].ID != ringing.ID || expired[0].Outcome != chat.CallMissed {
+		t.Fatalf("expected only the ringing call to be missed, got %+v", expired)
+	}
+	if _, err := calls.Relay("carol", active.ID); err != nil {
+		t.Fatalf("active call expired: %v", err)
+	}
+}
+
+func TestICEServers(t *testing.T) {
+	calls := testCalls()
+
+	before := time.Now()
+	servers := calls.ICEServers("alice")
+
+	if len(servers) != 2 || servers[0].URLs[0] != "stun:stun.example.com:3478" || servers[0].Username != "" {
+		t.Fatalf("unexpected servers %+v", servers)
+	}
+
+	turn := servers[1]
+
+	// coturn REST API credentials: username is expiry:userID, credential is base64(HMAC-SHA1(secret, username)).
+	parts := strings.SplitN(turn.Username, ":", 2)
+	if len(parts) != 2 || parts[1] != "alice" {
+		t.Fatalf("unexpected username %q", turn.Username)
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		t.Fatalf("expiry is not a unix timestamp: %q", parts[0])
+	}
+	if ttl := time.Unix(expiry, 0).Sub(before); ttl < 3599*time.Second || ttl > 3601*time.Second {
+		t.Fatalf("credential valid for %v, expected CredentialTTL", ttl)
+	}
+
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(turn.Username))
+	if turn.Credential != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("credential %q does not match the username", turn.Credential)
+	}
+
+	calls.TURNSecret = ""
+	if servers := calls.ICEServers("alice"); len(servers) != 1 {
+		t.Fatalf("TURN servers returned without a secret: %+v", servers)
+	}
+}
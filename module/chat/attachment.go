@@ -0,0 +1,111 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/audio"
+	"kevlar/module/db/mongo"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	TypeAttachment = "attachment"
+
+	MaxAttachments = 10
+)
+
+var (
+	ErrNoAttachments        = errors.New("attachment message has no attachments")
+	ErrTooManyAttachments   = errors.New("too many attachments in message")
+	ErrUnexpectedAttachment = errors.New("attachments are only allowed on attachment messages")
+)
+
+// File shared in a conversation, stored in the store of the message sender.
+type Attachment struct {
+	FileID    string `bson:"fileID" json:"fileID"`
+	Name      string `bson:"name" json:"name"`
+	Size      int    `bson:"size" json:"size"`
+	Mime      string `bson:"mime" json:"mime"`
+	Thumbnail string `bson:"thumbnail,omitempty" json:"thumbnail,omitempty"`
+
+	Audio *audio.Info `bson:"audio,omitempty" json:"audio,omitempty"`
+}
+
+// Files referenced by a message as composed by a client, resolved into attachments when sent.
+type FileReference struct {
+	FileID    string `bson:"fileID" json:"fileID"`
+	Thumbnail string `bson:"thumbnail,omitempty" json:"thumbnail,omitempty"`
+}
+
+type MediaItem struct {
+	Attachment
+	MessageID string    `json:"id"`
+	From      string    `json:"from"`
+	Time      time.Time `json:"time"`
+}
+
+type MediaPage struct {
+	Media    []MediaItem `json:"media"`
+	Next     string      `json:"next,omitempty"`
+	Previous string      `json:"previous,omitempty"`
+}
+
+func checkAttachments(message Message) error {
+	if message.Type == TypeVoice {
+		return checkVoice(message)
+	}
+
+	if message.Type != TypeAttachment {
+		if len(message.Attachments) != 0 {
+			return ErrUnexpectedAttachment
+		}
+		return nil
+	}
+
+	if len(message.Attachments) == 0 {
+		return ErrNoAttachments
+	}
+	if len(message.Attachments) > MaxAttachments {
+		return ErrTooManyAttachments
+	}
+
+	return nil
+}
+
+// Lists the attachments shared in the conversation between from and to, newest first.
+func (chat Chat) Media(from, to string, query PageQuery) (MediaPage, error) {
+
+	contact, err := chat.conversation(from, to)
+	if err != nil {
+		return MediaPage{}, err
+	}
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	page, err := chat.page(collection, bson.D{
+		{Key: "type", Value: TypeAttachment},
+	}, query, from)
+	if err != nil {
+		return MediaPage{}, err
+	}
+
+	media := MediaPage{
+		Media:    []MediaItem{},
+		Next:     page.Next,
+		Previous: page.Previous,
+	}
+
+	for _, message := range page.Messages {
+		for _, attachment := range message.Attachments {
+			media.Media = append(media.Media, MediaItem{
+				Attachment: attachment,
+				MessageID:  message.ID,
+				From:       message.From,
+				Time:       message.Time,
+			})
+		}
+	}
+
+	return media, nil
+}
@@ -1,79 +1,136 @@
 package chat
 
-import "time"
+import (
+	"sort"
+	"time"
+)
 
-func (chat Chat) GetAttributes(userID string, isOnline func(string) bool) (interface{}, error) {
+func (chat Chat) GetAttributes(userID string, connection func(string) Connection) (interface{}, error) {
 
 	type Contact struct {
 		User
-		Online   bool  `json:"online"`
-		LastSeen int64 `json:"last_seen,omitempty"`
+		Online     bool          `json:"online"`
+		State      string        `json:"state"`
+		Status     *CustomStatus `json:"status,omitempty"`
+		LastSeen   int64         `json:"last_seen,omitempty"`
+		Muted      bool          `json:"muted"`
+		MutedUntil *time.Time    `json:"muted_until,omitempty"`
+
+		Summary
+		Timer    int64      `json:"timer"`
+		Archived *time.Time `json:"archived,omitempty"`
 	}
 
-	var contacts []Contact
-
-	err := chat.GetAttribute(userID, ContactList, &contacts)
+	users, err := chat.Contacts(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if contacts == nil {
-		contacts = []Contact{}
+	contacts := []Contact{}
+	for _, user := range users {
+		contacts = append(contacts, Contact{User: user})
 	}
 
-	for index, contact := range contacts {
-		contacts[index].Online = isOnline(contact.UserID)
-
-		var seen int64
-
-		err = chat.GetAttribute(contact.UserID, LastSeen, &seen)
-		if err != nil {
-			chat.SetAttribute(contact.UserID, LastSeen, time.Now().Unix())
-		}
-
-		contacts[index].LastSeen = seen
+	blocked_by, err := chat.Blockers(userID)
+	if err != nil {
+		return nil, err
 	}
 
-	var outgoing []User
-	err = chat.GetAttribute(userID, OutgoingList, &outgoing)
+	mutes, err := chat.Mutes(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if outgoing == nil {
-		outgoing = []User{}
+	archives, err := chat.Archived(userID)
+	if err != nil {
+		return nil, err
 	}
 
-	for index, value := range outgoing {
-		var about string
+	for index, contact := range contacts {
+		contacts[index].Summary, err = chat.summary(contact.User)
+		if err != nil {
+			return nil, err
+		}
 
-		err = chat.GetAttribute(value.UserID, About, &about)
+		conversation, err := chat.conversationSettings(contact.Store)
 		if err != nil {
 			return nil, err
 		}
+		contacts[index].Timer = conversation.Timer
+
+		if until, ok := mutes[contact.UserID]; ok {
+			contacts[index].Muted = true
+			if !until.IsZero() {
+				contacts[index].MutedUntil = &until
+			}
+		}
+
+		// Presence of contacts who blocked the user is hidden.
+		contacts[index].State = PresenceOffline
+		if has(blocked_by, contact.UserID) {
+			continue
+		}
 
-		outgoing[index].Message = about
+		presence, err := chat.ViewPresence(contact.UserID, userID, connection(contact.UserID))
+		if err != nil {
+			return nil, err
+		}
+
+		contacts[index].Online = presence.Online
+		contacts[index].State = presence.State
+		contacts[index].Status = presence.Status
+		contacts[index].LastSeen = presence.LastSeen
+	}
+
+	// Most recently active conversations first, contacts without messages last.
+	sort.SliceStable(contacts, func(i, j int) bool {
+		if contacts[j].LastMessage == nil {
+			return contacts[i].LastMessage != nil
+		}
+		if contacts[i].LastMessage == nil {
+			return false
+		}
+		return contacts[i].LastMessage.Time.After(contacts[j].LastMessage.Time)
+	})
+
+	// Archived conversations leave the main list until a newer message arrives.
+	active := []Contact{}
+	archived := []Contact{}
+
+	for _, contact := range contacts {
+		at, ok := archives[contact.UserID]
+		if ok && (contact.LastMessage == nil || !contact.LastMessage.Time.After(at)) {
+			contact.Archived = &at
+			archived = append(archived, contact)
+			continue
+		}
+		active = append(active, contact)
 	}
 
-	var incoming []User
-	err = chat.GetAttribute(userID, IncomingList, &incoming)
+	starred, err := chat.CountStarred(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if incoming == nil {
-		incoming = []User{}
+	// Request lists show the about of each user.
+	outgoing, err := chat.Outgoing(userID)
+	if err != nil {
+		return nil, err
 	}
 
-	for index, value := range incoming {
-		var about string
+	incoming, err := chat.Incoming(userID)
+	if err != nil {
+		return nil, err
+	}
 
-		err = chat.GetAttribute(value.UserID, About, &about)
-		if err != nil {
-			return nil, err
-		}
+	blocked, err := chat.Blocked(userID)
+	if err != nil {
+		return nil, err
+	}
 
-		incoming[index].Message = about
+	former, err := chat.FormerContacts(userID)
+	if err != nil {
+		return nil, err
 	}
 
 	user, err := chat.GetInformation(userID)
@@ -83,19 +140,27 @@ func (chat Chat) GetAttributes(userID string, isOnline func(string) bool) (inter
 
 	return struct {
 		Contacts []Contact `json:"chat_contact_list"`
+		Archived []Contact `json:"chat_archived_list"`
 		Outgoing []User    `json:"chat_outgoing_list"`
 		Incoming []User    `json:"chat_incoming_list"`
+		Blocked  []User    `json:"chat_block_list"`
+		Former   []User    `json:"chat_former_contact_list"`
 
 		Username string `json:"username"`
 		UserID   string `json:"userID"`
 		About    string `json:"about"`
+		Starred  int64  `json:"starred"`
 	}{
-		Contacts: contacts,
+		Contacts: active,
+		Archived: archived,
 		Outgoing: outgoing,
 		Incoming: incoming,
+		Blocked:  blocked,
+		Former:   former,
 
 		Username: user.Username,
 		UserID:   userID,
 		About:    user.Message,
+		Starred:  starred,
 	}, nil
 }
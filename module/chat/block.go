@@ -0,0 +1,143 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/attr"
+	"time"
+)
+
+const (
+	MuteList = "chat_mute_list"
+)
+
+var (
+	ErrBlocked        = errors.New("user is blocked")
+	ErrSelfBlock      = errors.New("cannot block self")
+	ErrAlreadyBlocked = errors.New("user is already blocked")
+	ErrNotBlocked     = errors.New("user is not blocked")
+)
+
+// Blocks to for from, cancelling any pending request between them.
+func (chat Chat) Block(from, to string) error {
+	if from == to {
+		return ErrSelfBlock
+	}
+
+	_, err := chat.GetInformation(to)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = chat.transition(from, to, func(relationship *Relationship) error {
+		if has(relationship.BlockedBy, from) {
+			return ErrAlreadyBlocked
+		}
+
+		relationship.BlockedBy = append(relationship.BlockedBy, from)
+		return nil
+	})
+
+	return err
+}
+
+func (chat Chat) Unblock(from, to string) error {
+
+	_, _, err := chat.transition(from, to, func(relationship *Relationship) error {
+		if !has(relationship.BlockedBy, from) {
+			return ErrNotBlocked
+		}
+
+		relationship.BlockedBy = remove(relationship.BlockedBy, from)
+		return nil
+	})
+
+	return err
+}
+
+// Reports if userID has been blocked by blocker.
+func (chat Chat) IsBlockedBy(userID, blocker string) (bool, error) {
+	relationship, err := chat.Relationship(userID, blocker)
+	if err != nil {
+		return false, err
+	}
+
+	return has(relationship.BlockedBy, blocker), nil
+}
+
+// Returns ErrBlocked if either user has blocked the other.
+func (chat Chat) CheckBlocked(from, to string) error {
+	relationship, err := chat.Relationship(from, to)
+	if err != nil {
+		return err
+	}
+
+	if relationship.State == RelationBlocked {
+		return ErrBlocked
+	}
+
+	return nil
+}
+
+// Returns the conversations muted by userID with the time each mute ends,
+// a zero time means the conversation is muted until unmuted.
+func (chat Chat) Mutes(userID string) (map[string]time.Time, error) {
+	mutes := make(map[string]time.Time)
+
+	err := chat.GetAttribute(userID, MuteList, &mutes)
+	if err != nil && !errors.Is(err, attr.ErrKeyDoesNotExist) {
+		return nil, err
+	}
+
+	// Drop expired mutes.
+	for contact, until := range mutes {
+		if !until.IsZero() && time.Now().After(until) {
+			delete(mutes, contact)
+		}
+	}
+
+	return mutes, nil
+}
+
+// Mutes the conversation with to for duration, a zero duration mutes until unmuted.
+func (chat Chat) Mute(from, to string, duration time.Duration) error {
+	_, err := chat.contact(from, to)
+	if err != nil {
+		return err
+	}
+
+	mutes, err := chat.Mutes(from)
+	if err != nil {
+		return err
+	}
+
+	var until time.Time
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+
+	mutes[to] = until
+
+	return chat.SetAttribute(from, MuteList, mutes)
+}
+
+func (chat Chat) Unmute(from, to string) error {
+	mutes, err := chat.Mutes(from)
+	if err != nil {
+		return err
+	}
+
+	delete(mutes, to)
+
+	return chat.SetAttribute(from, MuteList, mutes)
+}
+
+// Reports if userID has muted the conversation with contactID, used to suppress notifications.
+func (chat Chat) Muted(userID, contactID string) (bool, error) {
+	mutes, err := chat.Mutes(userID)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := mutes[contactID]
+	return ok, nil
+}
@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	TypeCall = "call"
+
+	CallCompleted = "completed"
+	CallMissed    = "missed"
+	CallRejected  = "rejected"
+	CallBusy      = "busy"
+	CallCancelled = "cancelled"
+)
+
+var (
+	ErrReservedType = errors.New("message type is reserved for the server")
+)
+
+// Call log written into the conversation once a call ends, stored as the data of a call message.
+type CallLog struct {
+	CallID   string `json:"call_id"`
+	Video    bool   `json:"video"`
+	Outcome  string `json:"outcome"`
+	Duration int64  `json:"duration,omitempty"` // seconds, for completed calls
+}
+
+// Reports if messages of the type are only written by the server.
+func Reserved(kind string) bool {
+	return kind == TypeCall
+}
+
+// Writes the call log from the caller into the conversation with the callee.
+func (chat Chat) LogCall(caller, callee string, log CallLog) (Message, error) {
+	data, err := json.Marshal(log)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return chat.StoreMessage(Message{
+		From: caller,
+		Type: TypeCall,
+		Data: string(data),
+	}, callee)
+}
+
+func callPreview(message Message) string {
+	var log CallLog
+
+	err := json.Unmarshal([]byte(message.Data), &log)
+	if err != nil {
+		return "📞 Call"
+	}
+
+	icon, title, kind := "📞", "Voice call", "voice call"
+	if log.Video {
+		icon, title, kind = "📹", "Video call", "video call"
+	}
+
+	switch log.Outcome {
+	case CallCompleted:
+		return fmt.Sprintf("%s %s (%d:%02d)", icon, title, log.Duration/60, log.Duration%60)
+	case CallMissed, CallBusy:
+		return fmt.Sprintf("%s Missed %s", icon, kind)
+	case CallRejected:
+		return fmt.Sprintf("%s Declined %s", icon, kind)
+	}
+
+	return fmt.Sprintf("%s Cancelled %s", icon, kind)
+}
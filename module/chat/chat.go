@@ -14,12 +14,9 @@ import (
 )
 
 const (
-	ContactList  = "chat_contact_list"
-	IncomingList = "chat_incoming_list"
-	OutgoingList = "chat_outgoing_list"
-	LastSeen     = "last_seen"
-	Username     = "username"
-	About        = "about"
+	LastSeen = "last_seen"
+	Username = "username"
+	About    = "about"
 
 	WebPushSubscription = "web_push_subscription"
 
@@ -55,9 +52,47 @@ type Message struct {
 	Type string `bson:"type" json:"type"`
 	Data string `bson:"data" json:"data"`
 
-	Read bool `bson:"read" json:"read"`
+	// Read is set once the recipient has read the message, Status is what the sender
+	// is shown and only reaches StatusRead when the recipient sends read receipts.
+	Read        bool       `bson:"read" json:"read"`
+	Status      string     `bson:"status" json:"status"`
+	DeliveredAt *time.Time `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	ReadAt      *time.Time `bson:"read_at,omitempty" json:"read_at,omitempty"`
 
 	Time time.Time `bson:"time" json:"time"`
+
+	// ID of the quoted message, resolved into Quote when loaded.
+	ReplyTo string `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	Quote   *Quote `bson:"-" json:"quote,omitempty"`
+
+	// ID of the thread root for thread replies, thread roots keep the reply count.
+	Thread     string     `bson:"thread,omitempty" json:"thread,omitempty"`
+	ReplyCount int        `bson:"reply_count,omitempty" json:"reply_count,omitempty"`
+	LastReply  *time.Time `bson:"last_reply,omitempty" json:"last_reply,omitempty"`
+
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+
+	Poll *Poll `bson:"poll,omitempty" json:"poll,omitempty"`
+
+	PinnedBy string     `bson:"pinned_by,omitempty" json:"pinned_by,omitempty"`
+	PinnedAt *time.Time `bson:"pinned_at,omitempty" json:"pinned_at,omitempty"`
+	Starred  bool       `bson:"-" json:"starred,omitempty"`
+
+	// Set from the disappearing message timer, the message is removed by a TTL index.
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expires_at,omitempty"`
+
+	Reactions      []Reaction      `bson:"reactions,omitempty" json:"-"`
+	ReactionCounts []ReactionCount `bson:"-" json:"reactions"`
+}
+
+// Message as composed by a client, before it is stored.
+type Outgoing struct {
+	Type    string          `bson:"type" json:"type"`
+	Data    string          `bson:"data" json:"data"`
+	ReplyTo string          `bson:"reply_to,omitempty" json:"reply_to,omitempty"`
+	Thread  string          `bson:"thread,omitempty" json:"thread,omitempty"`
+	Files   []FileReference `bson:"files,omitempty" json:"attachments,omitempty"`
+	Poll    *Poll           `bson:"poll,omitempty" json:"poll,omitempty"`
 }
 
 func New(attr attr.Attr, mongo *mongo.MongoClient) Chat {
@@ -67,197 +102,223 @@ func New(attr attr.Attr, mongo *mongo.MongoClient) Chat {
 	}
 }
 
-func (chat Chat) StoreMessage(message_type, message_data, from, to string, online bool) error {
+// Stores the message from message.From to the conversation with to and returns the stored message.
+func (chat Chat) StoreMessage(message Message, to string) (Message, error) {
 
 	context, cancel := chat.DefaultContext()
 	defer cancel()
 
-	from_user, err := chat.GetInformation(from)
+	err := checkAttachments(message)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
-	var from_contacts []User
+	err = checkPoll(message.Type, message.Data, message.Poll, time.Now())
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Attachments may be sent without a caption.
+	trimmed_text := strings.TrimSpace(message.Data)
+	if trimmed_text == "" && len(message.Attachments) == 0 {
+		return Message{}, ErrMessageBlank
+	}
 
-	err = chat.GetAttribute(from, ContactList, &from_contacts)
+	from := message.From
+
+	relationship, err := chat.Relationship(from, to)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
-	var to_contacts []User
+	if relationship.State == RelationBlocked {
+		return Message{}, ErrBlocked
+	}
+	if !relationship.Connected {
+		return Message{}, ErrContactDoesNotExist
+	}
 
-	err = chat.GetAttribute(to, ContactList, &to_contacts)
+	from_user, err := chat.GetInformation(from)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
-	subline := fmt.Sprintf("%s: %s", from_user.Username, message_data)
+	subline := fmt.Sprintf("%s: %s", from_user.Username, Preview(message))
 
-	var store string
-	var found bool
+	store := relationship.Store
+
+	collection := chat.Database(mongo.Chat).Collection(store)
 
-	for index, value := range from_contacts {
-		if value.UserID == to {
-			from_contacts[index].Message = subline
-			store = value.Store
-			found = true
+	// Quoted messages and thread roots must belong to this conversation.
+	if message.ReplyTo != "" {
+		_, err = chat.findMessage(collection, message.ReplyTo)
+		if err != nil {
+			return Message{}, err
 		}
 	}
-	if !found {
-		return ErrContactDoesNotExist
-	}
-
-	found = false
 
-	for index, value := range to_contacts {
-		if value.UserID == from {
-			to_contacts[index].Message = subline
-			found = true
+	if message.Thread != "" {
+		root, err := chat.findMessage(collection, message.Thread)
+		if err != nil {
+			return Message{}, err
+		}
+		if root.Thread != "" {
+			return Message{}, ErrNestedThread
 		}
-	}
-	if !found {
-		return ErrContactDoesNotExist
 	}
 
-	err = chat.SetAttribute(from, ContactList, from_contacts)
+	err = chat.setSubline(from, to, subline)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
-	err = chat.SetAttribute(to, ContactList, to_contacts)
+	message.ID = uuid.New().String()
+	message.Read = false
+	message.Status = StatusSent
+	message.Time = time.Now()
+
+	err = chat.expire(store, &message)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 
-	trimmed_text := strings.TrimSpace(message_data)
-	if trimmed_text == "" {
-		return ErrMessageBlank
+	_, err = collection.InsertOne(context, message)
+	if err != nil {
+		return Message{}, err
 	}
 
-	collection := chat.Database(mongo.Chat).Collection(store)
+	if message.Thread != "" {
+		err = chat.countReply(collection, message)
+		if err != nil {
+			return Message{}, err
+		}
+	}
 
-	_, err = collection.InsertOne(context, Message{
-		ID:   uuid.New().String(),
-		From: from,
-		Type: message_type,
-		Data: message_data,
-		Read: online,
-		Time: time.Now(),
-	})
-	return err
+	return message, nil
 }
 
-func (chat Chat) MarkRead(from, to string) error {
-	context, cancel := chat.DefaultContext()
-	defer cancel()
-
-	var contacts []User
+// Loads a page of the conversation between from and to, excluding thread replies.
+func (chat Chat) LoadMessages(from, to string, query PageQuery) (Page, error) {
 
-	err := chat.GetAttribute(from, ContactList, &contacts)
+	contact, err := chat.conversation(from, to)
 	if err != nil {
-		return err
-	}
-
-	var contact User
-	var found bool
-
-	for _, value := range contacts {
-		if value.UserID == to {
-			contact = value
-			found = true
-		}
-	}
-	if !found {
-		return ErrContactDoesNotExist
+		return Page{}, err
 	}
 
-	options := options.Find()
-	options.Sort = bson.D{{Key: "time", Value: -1}}
-
 	collection := chat.Database(mongo.Chat).Collection(contact.Store)
 
-	cursor, err := collection.Find(context, bson.D{}, options)
+	// Thread replies are loaded separately with LoadThread.
+	page, err := chat.page(collection, bson.D{
+		{Key: "thread", Value: bson.D{
+			{Key: "$exists", Value: false},
+		}},
+	}, query, from)
 	if err != nil {
-		return err
+		return Page{}, err
 	}
 
-	for cursor.Next(context) {
-		var message Message
-
-		err = cursor.Decode(&message)
-
-		if err != nil {
-			return err
-		}
-
-		if message.From == to {
-			if message.Read {
-				break
-			}
-			message.Read = true
-		}
-
-		err = collection.FindOneAndReplace(context, bson.D{{Key: "id", Value: message.ID}}, message).Err()
-
-		if err != nil {
-			return err
-		}
+	err = chat.markStarred(from, page.Messages)
+	if err != nil {
+		return Page{}, err
 	}
 
-	return nil
+	return page, nil
 }
 
-func (chat Chat) LoadMessages(from, to string, since int) ([]Message, error) {
+// Returns the messages with ids in the conversation between from and to, in the order they were sent.
+func (chat Chat) Messages(from, to string, ids []string) ([]Message, error) {
 	context, cancel := chat.DefaultContext()
 	defer cancel()
 
-	var contacts []User
-
-	err := chat.GetAttribute(from, ContactList, &contacts)
+	contact, err := chat.conversation(from, to)
 	if err != nil {
 		return nil, err
 	}
 
-	var contact User
-	var found bool
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
 
-	for _, value := range contacts {
-		if value.UserID == to {
-			contact = value
-			found = true
-		}
-	}
-	if !found {
-		return nil, ErrContactDoesNotExist
+	cursor, err := collection.Find(context, bson.D{
+		{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}},
+	}, options.Find().SetSort(bson.D{{Key: "time", Value: 1}}))
+	if err != nil {
+		return nil, err
 	}
 
-	err = chat.MarkRead(from, to)
+	messages := []Message{}
+
+	err = cursor.All(context, &messages)
 	if err != nil {
 		return nil, err
 	}
 
-	options := options.Find()
-	options.Sort = bson.D{{Key: "time", Value: -1}}
+	if len(messages) != len(ids) {
+		return nil, ErrMessageDoesNotExist
+	}
 
-	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+	return messages, nil
+}
 
-	cursor, err := collection.Find(context, bson.D{}, options)
+// Number of messages read per request by EachMessage.
+const eachBatch = 500
+
+// Calls each with every message of the conversation between from and to as seen by from,
+// thread replies included, in the order they were sent. Messages are read in batches, each
+// with its own deadline, so long conversations are never loaded or timed out at once.
+func (chat Chat) EachMessage(from, to string, each func(Message) error) error {
+	contact, err := chat.conversation(from, to)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var messages []Message
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	next := func(after *Message) ([]Message, error) {
+		context, cancel := chat.DefaultContext()
+		defer cancel()
 
-	defer cursor.Close(context)
+		filter := bson.D{}
+		if after != nil {
+			filter = bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "time", Value: bson.D{{Key: "$gt", Value: after.Time}}}},
+				bson.D{{Key: "time", Value: after.Time}, {Key: "id", Value: bson.D{{Key: "$gt", Value: after.ID}}}},
+			}}}
+		}
 
-	for i := 0; cursor.Next(context) && i <= (since+MaxResults); i++ {
-		var message Message
-		err = cursor.Decode(&message)
+		cursor, err := collection.Find(context, filter, options.Find().SetSort(bson.D{
+			{Key: "time", Value: 1},
+			{Key: "id", Value: 1},
+		}).SetLimit(eachBatch))
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, message)
+
+		messages := []Message{}
+
+		err = cursor.All(context, &messages)
+		return messages, err
 	}
 
-	return messages[since:], nil
+	var after *Message
+
+	for {
+		messages, err := next(after)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			message.ReactionCounts = CountReactions(message.Reactions, from)
+			tallyMessage(&message, from)
+
+			err = each(message)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(messages) < eachBatch {
+			return nil
+		}
+		after = &messages[len(messages)-1]
+	}
 }
@@ -0,0 +1,86 @@
+package chat
+
+// Returns the contact entry of to for from.
+func (chat Chat) contact(from, to string) (User, error) {
+	relationship, err := chat.Relationship(from, to)
+	if err != nil {
+		return User{}, err
+	}
+
+	if !relationship.Connected {
+		return User{}, ErrContactDoesNotExist
+	}
+
+	users, err := chat.users(from, []Relationship{relationship})
+	if err != nil {
+		return User{}, err
+	}
+
+	return users[0], nil
+}
+
+// Returns the conversation with to, including conversations kept after the contact was removed.
+func (chat Chat) conversation(from, to string) (User, error) {
+	relationship, err := chat.Relationship(from, to)
+	if err != nil {
+		return User{}, err
+	}
+
+	if !relationship.Connected && !has(relationship.Kept, from) {
+		return User{}, ErrContactDoesNotExist
+	}
+
+	users, err := chat.users(from, []Relationship{relationship})
+	if err != nil {
+		return User{}, err
+	}
+
+	return users[0], nil
+}
+
+// Checks that from can read the conversation with to, as a contact or by having kept it.
+func (chat Chat) CheckConversation(from, to string) error {
+	_, err := chat.conversation(from, to)
+	return err
+}
+
+// Removes the pair as contacts. The conversation is kept for to, and kept for from
+// unless remove is set, it is dropped once neither side keeps it. A user who kept
+// the conversation can remove it later the same way.
+func (chat Chat) RemoveContact(from, to string, remove_conversation bool) error {
+
+	before, after, err := chat.transition(from, to, func(relationship *Relationship) error {
+		if !relationship.Connected && !has(relationship.Kept, from) {
+			return ErrContactDoesNotExist
+		}
+
+		// The other side keeps the conversation until they remove it.
+		if relationship.Connected {
+			relationship.Connected = false
+			relationship.Kept = []string{to}
+		}
+
+		relationship.Kept = remove(relationship.Kept, from)
+		if !remove_conversation {
+			relationship.Kept = append(relationship.Kept, from)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = chat.Unmute(from, to)
+	if err != nil {
+		return err
+	}
+
+	if after.Store == "" {
+		err = chat.dropConversation(before.Store)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"kevlar/module/db/mongo"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MaxQueryLength = 64
+)
+
+// Case insensitive comparison used by the directory, the search indexes use the same collation.
+var DirectoryCollation = &options.Collation{
+	Locale:   "en",
+	Strength: 2,
+}
+
+// Position in the ranked directory results.
+type directoryCursor struct {
+	Rank     int
+	ID       primitive.ObjectID
+	Username string
+}
+
+func (cursor directoryCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(
+		fmt.Sprintf("%d:%s:%s", cursor.Rank, cursor.ID.Hex(), cursor.Username)))
+}
+
+func decodeDirectoryCursor(value string) (directoryCursor, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return directoryCursor{}, false
+	}
+
+	parts := strings.SplitN(string(data), ":", 3)
+	if len(parts) != 3 {
+		return directoryCursor{}, false
+	}
+
+	rank, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return directoryCursor{}, false
+	}
+
+	id, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return directoryCursor{}, false
+	}
+
+	return directoryCursor{
+		Rank:     rank,
+		ID:       id,
+		Username: parts[2],
+	}, true
+}
+
+// Matches values starting with prefix under DirectoryCollation. U+FFFF sorts after
+// every other character in the root collation, so the range covers the whole prefix.
+func prefixRange(prefix string) bson.D {
+	return bson.D{
+		{Key: "$gte", Value: prefix},
+		{Key: "$lt", Value: prefix + "\uffff"},
+	}
+}
+
+// Searches the directory by userID and username prefix. Exact matches come first, then the
+// rest by username. Contacts, pending requests, users who blocked from and users who opted
+// out of discovery are left out by the query itself. last is the cursor returned by the
+// previous page, the nil ObjectID is returned once there are no more results.
+func (chat Chat) Search(from, query string, last string) ([]User, string, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	query = strings.TrimSpace(query)
+	if query == "" || query == "*" || utf8.RuneCountInString(query) > MaxQueryLength {
+		return nil, "", ErrInvalidQuery
+	}
+
+	relationships, err := chat.relationships(from, bson.D{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	excluded := bson.A{from}
+	for _, relationship := range relationships {
+		other := relationship.Other(from)
+		if relationship.Connected || relationship.State == RelationPending || has(relationship.BlockedBy, other) {
+			excluded = append(excluded, other)
+		}
+	}
+
+	pipeline := []bson.D{
+		{{Key: "$match", Value: bson.D{
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "userID", Value: prefixRange(query)}},
+				bson.D{{Key: "username", Value: prefixRange(query)}},
+			}},
+			{Key: "userID", Value: bson.D{{Key: "$nin", Value: excluded}}},
+			{Key: "discoverable", Value: bson.D{{Key: "$ne", Value: false}}},
+			{Key: "disabled", Value: bson.D{{Key: "$ne", Value: true}}},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "userID", Value: 1},
+			{Key: "username", Value: 1},
+			{Key: "rank", Value: bson.D{
+				{Key: "$cond", Value: bson.A{
+					bson.D{{Key: "$or", Value: bson.A{
+						bson.D{{Key: "$eq", Value: bson.A{"$userID", query}}},
+						bson.D{{Key: "$eq", Value: bson.A{"$username", query}}},
+					}}},
+					0,
+					1,
+				}},
+			}},
+		}}},
+	}
+
+	cursor, ok := decodeDirectoryCursor(last)
+	if ok {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: "rank", Value: bson.D{{Key: "$gt", Value: cursor.Rank}}}},
+				bson.D{
+					{Key: "rank", Value: cursor.Rank},
+					{Key: "username", Value: bson.D{{Key: "$gt", Value: cursor.Username}}},
+				},
+				bson.D{
+					{Key: "rank", Value: cursor.Rank},
+					{Key: "username", Value: cursor.Username},
+					{Key: "_id", Value: bson.D{{Key: "$gt", Value: cursor.ID}}},
+				},
+			}},
+		}}})
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{
+			{Key: "rank", Value: 1},
+			{Key: "username", Value: 1},
+			{Key: "_id", Value: 1},
+		}}},
+		bson.D{{Key: "$limit", Value: MaxResults}},
+	)
+
+	options := options.Aggregate().SetCollation(DirectoryCollation)
+
+	results, err := chat.Database(mongo.Users).Collection(mongo.Accounts).Aggregate(context, pipeline, options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var accounts []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		UserID   string             `bson:"userID"`
+		Username string             `bson:"username"`
+		Rank     int                `bson:"rank"`
+	}
+
+	err = results.All(context, &accounts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	userIDs := bson.A{}
+	for _, account := range accounts {
+		userIDs = append(userIDs, account.UserID)
+	}
+
+	abouts, err := chat.abouts(userIDs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	users := []User{}
+	for _, account := range accounts {
+		users = append(users, User{
+			UserID:   account.UserID,
+			Username: account.Username,
+			Message:  abouts[account.UserID],
+		})
+	}
+
+	if len(accounts) < MaxResults {
+		return users, primitive.NilObjectID.Hex(), nil
+	}
+
+	end := accounts[len(accounts)-1]
+
+	return users, directoryCursor{
+		Rank:     end.Rank,
+		ID:       end.ID,
+		Username: end.Username,
+	}.encode(), nil
+}
+
+// Loads the about of every user in userIDs with a single query.
+func (chat Chat) abouts(userIDs bson.A) (map[string]string, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	abouts := map[string]string{}
+
+	if len(userIDs) == 0 {
+		return abouts, nil
+	}
+
+	options := options.Find().SetProjection(bson.D{
+		{Key: "userID", Value: 1},
+		{Key: "attributes." + About, Value: 1},
+	})
+
+	cursor, err := chat.Database(mongo.Users).Collection(mongo.Attributes).Find(context, bson.D{
+		{Key: "userID", Value: bson.D{{Key: "$in", Value: userIDs}}},
+	}, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var attributes []struct {
+		UserID     string            `bson:"userID"`
+		Attributes map[string][]byte `bson:"attributes"`
+	}
+
+	err = cursor.All(context, &attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range attributes {
+		data, ok := value.Attributes[About]
+		if !ok {
+			continue
+		}
+
+		var about string
+
+		err = gob.NewDecoder(bytes.NewBuffer(data)).Decode(&about)
+		if err != nil {
+			return nil, err
+		}
+
+		abouts[value.UserID] = about
+	}
+
+	return abouts, nil
+}
@@ -0,0 +1,181 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/db/mongo"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Events kept per user, older events are dropped as new ones arrive.
+	MaxEvents      = 1000
+	EventRetention = 7 * 24 * time.Hour
+
+	EventSync      = "event_sync"
+	ResyncRequired = "resync_required"
+)
+
+var (
+	ErrResyncRequired = errors.New("event log does not cover the requested sequence")
+)
+
+// Websocket event kept for replay, Payload is the JSON encoded event without its sequence number.
+type Event struct {
+	UserID    string    `bson:"userID"`
+	Seq       int64     `bson:"seq"`
+	Payload   []byte    `bson:"payload"`
+	Time      time.Time `bson:"time"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// Returns the sequence number of the latest event of userID, zero if there are none.
+func (chat Chat) LatestEvent(userID string) (int64, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	var sequence struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	err := chat.Database(mongo.Users).Collection(mongo.EventSequences).FindOne(context, bson.D{
+		{Key: "userID", Value: userID},
+	}).Decode(&sequence)
+	if err == mongodb.ErrNoDocuments {
+		return 0, nil
+	}
+
+	return sequence.Seq, err
+}
+
+// Appends the event to the log of userID and returns its sequence number. The number is taken
+// from the counter of the user with one atomic increment. A number taken by an append that
+// failed, or by one still being stored, shows up as a gap, which makes a replay over it fall
+// back to a resync rather than skip the event.
+func (chat Chat) AppendEvent(userID string, payload []byte) (int64, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	options := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var sequence struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	err := chat.Database(mongo.Users).Collection(mongo.EventSequences).FindOneAndUpdate(context, bson.D{
+		{Key: "userID", Value: userID},
+	}, bson.D{
+		{Key: "$inc", Value: bson.D{
+			{Key: "seq", Value: int64(1)},
+		}},
+	}, options).Decode(&sequence)
+	if err != nil {
+		return 0, err
+	}
+
+	seq := sequence.Seq
+	now := time.Now()
+
+	_, err = chat.Database(mongo.Users).Collection(mongo.Events).InsertOne(context, Event{
+		UserID:    userID,
+		Seq:       seq,
+		Payload:   payload,
+		Time:      now,
+		ExpiresAt: now.Add(EventRetention),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = chat.Database(mongo.Users).Collection(mongo.Events).DeleteMany(context, bson.D{
+		{Key: "userID", Value: userID},
+		{Key: "seq", Value: bson.D{
+			{Key: "$lte", Value: seq - MaxEvents},
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return seq, nil
+}
+
+// Reports whether events hold every sequence number after last, up to at least latest.
+func contiguous(events []Event, last, latest int64) bool {
+	for index, event := range events {
+		if event.Seq != last+int64(index)+1 {
+			return false
+		}
+	}
+	return last+int64(len(events)) >= latest
+}
+
+// Returns the events of userID after the sequence number last, in order, and the latest sequence number.
+// ErrResyncRequired is returned when some of those events are no longer kept.
+func (chat Chat) EventsSince(userID string, last int64) ([]Event, int64, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	latest, err := chat.LatestEvent(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// A client ahead of the server has a position from a log that no longer exists.
+	if last > latest || last < 0 {
+		return nil, latest, ErrResyncRequired
+	}
+
+	options := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+
+	cursor, err := chat.Database(mongo.Users).Collection(mongo.Events).Find(context, bson.D{
+		{Key: "userID", Value: userID},
+		{Key: "seq", Value: bson.D{
+			{Key: "$gt", Value: last},
+		}},
+	}, options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []Event{}
+
+	err = cursor.All(context, &events)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Events dropped from the log, or missing for any other reason, can not be replayed.
+	if !contiguous(events, last, latest) {
+		return nil, latest, ErrResyncRequired
+	}
+
+	// Events appended while loading are included as well.
+	if len(events) != 0 && events[len(events)-1].Seq > latest {
+		latest = events[len(events)-1].Seq
+	}
+
+	return events, latest, nil
+}
+
+// Removes the event log of userID.
+func (chat Chat) DeleteEvents(userID string) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "userID", Value: userID},
+	}
+
+	_, err := chat.Database(mongo.Users).Collection(mongo.Events).DeleteMany(context, filter)
+	if err != nil {
+		return err
+	}
+
+	_, err = chat.Database(mongo.Users).Collection(mongo.EventSequences).DeleteOne(context, filter)
+
+	return err
+}
@@ -0,0 +1,36 @@
+package chat
+
+import "testing"
+
+func logged(seqs ...int64) []Event {
+	events := []Event{}
+	for _, seq := range seqs {
+		events = append(events, Event{Seq: seq})
+	}
+	return events
+}
+
+func TestContiguous(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []Event
+		last   int64
+		latest int64
+		valid  bool
+	}{
+		{"up to date", logged(), 5, 5, true},
+		{"complete", logged(6, 7, 8), 5, 8, true},
+		{"appended while loading", logged(6, 7, 8, 9), 5, 8, true},
+		{"first dropped", logged(7, 8), 5, 8, false},
+		{"gap in the middle", logged(6, 8), 5, 8, false},
+		{"missing at the end", logged(6, 7), 5, 8, false},
+		{"none kept", logged(), 5, 8, false},
+		{"out of order", logged(7, 6), 5, 7, false},
+	}
+
+	for _, test := range tests {
+		if valid := contiguous(test.events, test.last, test.latest); valid != test.valid {
+			t.Errorf("%s: contiguous = %v, expected %v", test.name, valid, test.valid)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/gob"
+	"kevlar/module/db/mongo"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Attribute keys of the contact graph before it moved to relationships.
+const (
+	ContactList       = "chat_contact_list"
+	IncomingList      = "chat_incoming_list"
+	OutgoingList      = "chat_outgoing_list"
+	BlockList         = "chat_block_list"
+	BlockedByList     = "chat_blocked_by"
+	FormerContactList = "chat_former_contact_list"
+)
+
+// Number of documents read or written per database request during the migration.
+const migrationBatch = 500
+
+// Moves the contact graph of every user from attributes to relationships. The attributes
+// are removed only after every relationship is written, so an interrupted run is repeated.
+func (chat Chat) MigrateRelationships() error {
+	keys := []string{ContactList, IncomingList, OutgoingList, BlockList, BlockedByList, FormerContactList}
+
+	var filter bson.A
+	for _, key := range keys {
+		filter = append(filter, bson.D{
+			{Key: "attributes." + key, Value: bson.D{{Key: "$exists", Value: true}}},
+		})
+	}
+
+	projection := bson.D{{Key: "userID", Value: 1}}
+	for _, key := range keys {
+		projection = append(projection, bson.E{Key: "attributes." + key, Value: 1})
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Attributes)
+
+	type document struct {
+		ID         bson.RawValue     `bson:"_id"`
+		UserID     string            `bson:"userID"`
+		Attributes map[string][]byte `bson:"attributes"`
+	}
+
+	// Documents are read in batches by _id, each with its own deadline.
+	next := func(after *bson.RawValue) ([]document, error) {
+		context, cancel := chat.DefaultContext()
+		defer cancel()
+
+		query := bson.D{{Key: "$or", Value: filter}}
+		if after != nil {
+			query = append(query, bson.E{Key: "_id", Value: bson.D{{Key: "$gt", Value: *after}}})
+		}
+
+		cursor, err := collection.Find(context, query, options.Find().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetLimit(migrationBatch).
+			SetProjection(projection))
+		if err != nil {
+			return nil, err
+		}
+
+		var documents []document
+
+		err = cursor.All(context, &documents)
+		return documents, err
+	}
+
+	relationships := map[string]*Relationship{}
+
+	get := func(from, to string) *Relationship {
+		key, users := pair(from, to)
+
+		relationship, ok := relationships[key]
+		if !ok {
+			relationship = &Relationship{
+				Pair:  key,
+				Users: users,
+			}
+			relationships[key] = relationship
+		}
+		return relationship
+	}
+
+	add := func(document document) error {
+		userID := document.UserID
+
+		list := func(key string) ([]User, error) {
+			var users []User
+
+			data, ok := document.Attributes[key]
+			if !ok {
+				return users, nil
+			}
+
+			err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(&users)
+			return users, err
+		}
+
+		for _, key := range keys {
+			users, err := list(key)
+			if err != nil {
+				return err
+			}
+
+			for _, user := range users {
+				if user.UserID == userID {
+					continue
+				}
+
+				relationship := get(userID, user.UserID)
+
+				switch key {
+				case ContactList:
+					relationship.Connected = true
+					relationship.Store = user.Store
+					relationship.Subline = user.Message
+				case OutgoingList:
+					if relationship.RequestedBy == "" {
+						relationship.RequestedBy = userID
+					}
+				case IncomingList:
+					if relationship.RequestedBy == "" {
+						relationship.RequestedBy = user.UserID
+					}
+				case BlockList:
+					if !has(relationship.BlockedBy, userID) {
+						relationship.BlockedBy = append(relationship.BlockedBy, userID)
+					}
+				case BlockedByList:
+					if !has(relationship.BlockedBy, user.UserID) {
+						relationship.BlockedBy = append(relationship.BlockedBy, user.UserID)
+					}
+				case FormerContactList:
+					if !has(relationship.Kept, userID) {
+						relationship.Kept = append(relationship.Kept, userID)
+					}
+					if relationship.Store == "" {
+						relationship.Store = user.Store
+						relationship.Subline = user.Message
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	var after *bson.RawValue
+	found := 0
+
+	for {
+		documents, err := next(after)
+		if err != nil {
+			return err
+		}
+
+		found += len(documents)
+
+		for _, document := range documents {
+			err = add(document)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(documents) < migrationBatch {
+			break
+		}
+		after = &documents[len(documents)-1].ID
+	}
+
+	if found == 0 {
+		return nil
+	}
+
+	relationshipsCollection := chat.Database(mongo.Users).Collection(mongo.Relationships)
+
+	write := func(batch []*Relationship) error {
+		context, cancel := chat.DefaultContext()
+		defer cancel()
+
+		for _, relationship := range batch {
+			_, err := relationshipsCollection.ReplaceOne(context, bson.D{
+				{Key: "pair", Value: relationship.Pair},
+			}, relationship, options.Replace().SetUpsert(true))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var batch []*Relationship
+
+	for _, relationship := range relationships {
+		relationship.settle()
+		relationship.UpdatedAt = time.Now()
+
+		if relationship.State == RelationNone && relationship.Store == "" {
+			continue
+		}
+
+		batch = append(batch, relationship)
+		if len(batch) == migrationBatch {
+			err := write(batch)
+			if err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+
+	err := write(batch)
+	if err != nil {
+		return err
+	}
+
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	unset := bson.D{}
+	for _, key := range keys {
+		unset = append(unset, bson.E{Key: "attributes." + key, Value: ""})
+	}
+
+	_, err = collection.UpdateMany(context, bson.D{{Key: "$or", Value: filter}}, bson.D{
+		{Key: "$unset", Value: unset},
+	})
+
+	return err
+}
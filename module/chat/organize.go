@@ -0,0 +1,272 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/db/mongo"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ArchiveList = "chat_archive_list"
+
+	PinUpdated = "pin_updated"
+)
+
+var (
+	ErrAlreadyStarred = errors.New("message is already starred")
+)
+
+// Message starred by UserID in the conversation with Contact.
+type Star struct {
+	ObjectID  primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserID    string             `bson:"userID" json:"-"`
+	Contact   string             `bson:"contact" json:"userID"`
+	Store     string             `bson:"store" json:"-"`
+	MessageID string             `bson:"id" json:"id"`
+	Time      time.Time          `bson:"time" json:"time"`
+
+	// Resolved when listed, Deleted is set when the message no longer exists.
+	Message *Message `bson:"-" json:"message,omitempty"`
+	Deleted bool     `bson:"-" json:"deleted,omitempty"`
+}
+
+// Pins or unpins a message in the conversation between from and to, pins are seen by both.
+func (chat Chat) Pin(from, to, messageID string, pin bool) (Message, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	contact, err := chat.contact(from, to)
+	if err != nil {
+		return Message{}, err
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "pinned_by", Value: from},
+			{Key: "pinned_at", Value: time.Now()},
+		}},
+	}
+	if !pin {
+		update = bson.D{
+			{Key: "$unset", Value: bson.D{
+				{Key: "pinned_by", Value: ""},
+				{Key: "pinned_at", Value: ""},
+			}},
+		}
+	}
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	var message Message
+
+	err = collection.FindOneAndUpdate(context, bson.D{
+		{Key: "id", Value: messageID},
+	}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&message)
+	if err == mongodb.ErrNoDocuments {
+		return Message{}, ErrMessageDoesNotExist
+	}
+
+	return message, err
+}
+
+// Loads a page of the pinned messages in the conversation between from and to.
+func (chat Chat) LoadPinned(from, to string, query PageQuery) (Page, error) {
+	contact, err := chat.conversation(from, to)
+	if err != nil {
+		return Page{}, err
+	}
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	return chat.page(collection, bson.D{
+		{Key: "pinned_at", Value: bson.D{
+			{Key: "$exists", Value: true},
+		}},
+	}, query, from)
+}
+
+// Stars a message for from only, or removes the star.
+func (chat Chat) Star(from, to, messageID string, star bool) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Stars)
+
+	if !star {
+		_, err := collection.DeleteOne(context, bson.D{
+			{Key: "userID", Value: from},
+			{Key: "id", Value: messageID},
+		})
+		return err
+	}
+
+	contact, err := chat.conversation(from, to)
+	if err != nil {
+		return err
+	}
+
+	_, err = chat.findMessage(chat.Database(mongo.Chat).Collection(contact.Store), messageID)
+	if err != nil {
+		return err
+	}
+
+	_, err = collection.InsertOne(context, Star{
+		UserID:    from,
+		Contact:   to,
+		Store:     contact.Store,
+		MessageID: messageID,
+		Time:      time.Now(),
+	})
+	if mongodb.IsDuplicateKeyError(err) {
+		return ErrAlreadyStarred
+	}
+
+	return err
+}
+
+// Lists the starred messages of userID, newest star first, continuing after last.
+func (chat Chat) ListStarred(userID, last string) ([]Star, string, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "userID", Value: userID},
+	}
+
+	last_id, err := primitive.ObjectIDFromHex(last)
+	if err == nil && last_id != primitive.NilObjectID {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{
+			{Key: "$lt", Value: last_id},
+		}})
+	}
+
+	options := options.Find().SetLimit(MaxResults)
+	options.Sort = bson.D{{Key: "_id", Value: -1}}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Stars)
+
+	cursor, err := collection.Find(context, filter, options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	stars := []Star{}
+
+	err = cursor.All(context, &stars)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for index, star := range stars {
+		message, err := chat.findMessage(chat.Database(mongo.Chat).Collection(star.Store), star.MessageID)
+		if err == ErrMessageDoesNotExist {
+			stars[index].Deleted = true
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		message.ReactionCounts = CountReactions(message.Reactions, userID)
+		tallyMessage(&message, userID)
+		stars[index].Message = &message
+	}
+
+	if len(stars) < MaxResults {
+		return stars, primitive.NilObjectID.Hex(), nil
+	}
+
+	return stars, stars[len(stars)-1].ObjectID.Hex(), nil
+}
+
+func (chat Chat) CountStarred(userID string) (int64, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Stars)
+
+	return collection.CountDocuments(context, bson.D{
+		{Key: "userID", Value: userID},
+	})
+}
+
+// Marks the messages that userID has starred.
+func (chat Chat) markStarred(userID string, messages []Message) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var ids []string
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Stars)
+
+	cursor, err := collection.Find(context, bson.D{
+		{Key: "userID", Value: userID},
+		{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var stars []Star
+
+	err = cursor.All(context, &stars)
+	if err != nil {
+		return err
+	}
+
+	for _, star := range stars {
+		for index := range messages {
+			if messages[index].ID == star.MessageID {
+				messages[index].Starred = true
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the conversations archived by userID with the time they were archived.
+func (chat Chat) Archived(userID string) (map[string]time.Time, error) {
+	archived := make(map[string]time.Time)
+
+	err := chat.GetAttribute(userID, ArchiveList, &archived)
+	if err != nil && !errors.Is(err, attr.ErrKeyDoesNotExist) {
+		return nil, err
+	}
+
+	return archived, nil
+}
+
+// Archives the conversation with to for from, it stays archived until a newer message arrives.
+func (chat Chat) Archive(from, to string, archive bool) error {
+	_, err := chat.contact(from, to)
+	if err != nil && archive {
+		return err
+	}
+
+	archived, err := chat.Archived(from)
+	if err != nil {
+		return err
+	}
+
+	if archive {
+		archived[to] = time.Now()
+	} else {
+		delete(archived, to)
+	}
+
+	return chat.SetAttribute(from, ArchiveList, archived)
+}
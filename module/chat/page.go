@@ -0,0 +1,229 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"kevlar/module/sec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+)
+
+// Position in a conversation, ordered by message time and then message ID.
+type Cursor struct {
+	Time time.Time
+	ID   string
+}
+
+// Selects a page of messages. At most one of Before, After and Around is used,
+// with none set the latest page is loaded.
+type PageQuery struct {
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Around string `json:"around,omitempty"` // message ID
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// Messages are ordered newest first. Next loads older messages and Previous loads newer
+// messages, both are empty when there is nothing more in that direction.
+type Page struct {
+	Messages []Message `json:"messages"`
+	Next     string    `json:"next,omitempty"`
+	Previous string    `json:"previous,omitempty"`
+}
+
+func (cursor Cursor) Encode() string {
+	return sec.EncodeBase64([]byte(fmt.Sprintf("%d:%s", cursor.Time.UnixMilli(), cursor.ID)))
+}
+
+func DecodeCursor(encoded string) (Cursor, error) {
+	data, err := sec.DecodeBase64(encoded)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	milli, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return Cursor{
+		Time: time.UnixMilli(milli),
+		ID:   parts[1],
+	}, nil
+}
+
+func messageCursor(message Message) Cursor {
+	return Cursor{
+		Time: message.Time,
+		ID:   message.ID,
+	}
+}
+
+// Filter for messages strictly older (operator $lt) or newer ($gt) than the cursor.
+func cursorFilter(cursor Cursor, operator string) bson.D {
+	return bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{
+				{Key: "time", Value: bson.D{{Key: operator, Value: cursor.Time}}},
+			},
+			bson.D{
+				{Key: "time", Value: cursor.Time},
+				{Key: "id", Value: bson.D{{Key: operator, Value: cursor.ID}}},
+			},
+		}},
+	}
+}
+
+// Loads up to limit messages matching filter beyond the cursor, closest to the cursor
+// first. The boolean reports if more messages exist beyond the returned ones.
+func (chat Chat) fetch(collection *mongodb.Collection, filter bson.D, cursor *Cursor, older bool, limit int) ([]Message, bool, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	operator, order := "$lt", -1
+	if !older {
+		operator, order = "$gt", 1
+	}
+
+	if cursor != nil {
+		filter = bson.D{
+			{Key: "$and", Value: bson.A{filter, cursorFilter(*cursor, operator)}},
+		}
+	}
+
+	options := options.Find().SetLimit(int64(limit + 1))
+	options.Sort = bson.D{{Key: "time", Value: order}, {Key: "id", Value: order}}
+
+	results, err := collection.Find(context, filter, options)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var messages []Message
+
+	err = results.All(context, &messages)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(messages) > limit {
+		return messages[:limit], true, nil
+	}
+	return messages, false, nil
+}
+
+func reverse(messages []Message) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
+
+// Loads the page of messages matching filter selected by query, as seen by userID.
+func (chat Chat) page(collection *mongodb.Collection, filter bson.D, query PageQuery, userID string) (Page, error) {
+
+	limit := query.Limit
+	if limit <= 0 || limit > MaxResults {
+		limit = MaxResults
+	}
+
+	var messages []Message
+	var older, newer bool
+
+	if query.Around != "" {
+		target, err := chat.findMessage(collection, query.Around)
+		if err != nil {
+			return Page{}, err
+		}
+
+		cursor := messageCursor(target)
+
+		after, more, err := chat.fetch(collection, filter, &cursor, false, limit/2)
+		if err != nil {
+			return Page{}, err
+		}
+		newer = more
+
+		before, more, err := chat.fetch(collection, filter, &cursor, true, limit-len(after)-1)
+		if err != nil {
+			return Page{}, err
+		}
+		older = more
+
+		reverse(after)
+		messages = append(after, target)
+		messages = append(messages, before...)
+
+	} else if query.After != "" {
+		cursor, err := DecodeCursor(query.After)
+		if err != nil {
+			return Page{}, err
+		}
+
+		messages, newer, err = chat.fetch(collection, filter, &cursor, false, limit)
+		if err != nil {
+			return Page{}, err
+		}
+		reverse(messages)
+		older = true
+
+	} else {
+		var cursor *Cursor
+
+		if query.Before != "" {
+			decoded, err := DecodeCursor(query.Before)
+			if err != nil {
+				return Page{}, err
+			}
+			cursor = &decoded
+			newer = true
+		}
+
+		var err error
+
+		messages, older, err = chat.fetch(collection, filter, cursor, true, limit)
+		if err != nil {
+			return Page{}, err
+		}
+	}
+
+	page := Page{
+		Messages: []Message{},
+	}
+
+	err := chat.resolveQuotes(collection, messages)
+	if err != nil {
+		return Page{}, err
+	}
+
+	for _, message := range messages {
+		message.ReactionCounts = CountReactions(message.Reactions, userID)
+		tallyMessage(&message, userID)
+
+		page.Messages = append(page.Messages, message)
+	}
+
+	if len(page.Messages) != 0 {
+		if older {
+			page.Next = messageCursor(page.Messages[len(page.Messages)-1]).Encode()
+		}
+		if newer {
+			page.Previous = messageCursor(page.Messages[0]).Encode()
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,37 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/sec"
+	"testing"
+	"time"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	at := time.UnixMilli(1700000000123)
+
+	tests := []struct {
+		name    string
+		encoded string
+		cursor  Cursor
+		err     error
+	}{
+		{"round trip", Cursor{Time: at, ID: "message"}.Encode(), Cursor{Time: at, ID: "message"}, nil},
+		{"ID with colons", Cursor{Time: at, ID: "a:b"}.Encode(), Cursor{Time: at, ID: "a:b"}, nil},
+		{"empty ID", Cursor{Time: at}.Encode(), Cursor{Time: at}, nil},
+		{"not base64", "%%%", Cursor{}, ErrInvalidCursor},
+		{"no separator", sec.EncodeBase64([]byte("1700000000123")), Cursor{}, ErrInvalidCursor},
+		{"time not a number", sec.EncodeBase64([]byte("yesterday:message")), Cursor{}, ErrInvalidCursor},
+	}
+
+	for _, test := range tests {
+		cursor, err := DecodeCursor(test.encoded)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: error %v, expected %v", test.name, err, test.err)
+			continue
+		}
+		if !cursor.Time.Equal(test.cursor.Time) || cursor.ID != test.cursor.ID {
+			t.Errorf("%s: cursor %+v, expected %+v", test.name, cursor, test.cursor)
+		}
+	}
+}
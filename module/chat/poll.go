@@ -0,0 +1,245 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/db/mongo"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	TypePoll = "poll"
+
+	MinPollOptions = 2
+	MaxPollOptions = 10
+
+	MaxPollQuestion = 300
+	MaxPollOption   = 100
+)
+
+var (
+	ErrInvalidPoll    = errors.New("poll is invalid")
+	ErrUnexpectedPoll = errors.New("polls are only allowed on poll messages")
+	ErrNotPoll        = errors.New("message is not a poll")
+	ErrPollClosed     = errors.New("poll is closed")
+	ErrInvalidVote    = errors.New("vote does not match the poll options")
+)
+
+// Poll attached to a poll message, the question is the data of the message.
+type Poll struct {
+	Options   []string   `bson:"options" json:"options"`
+	Multiple  bool       `bson:"multiple" json:"multiple"`
+	Anonymous bool       `bson:"anonymous" json:"anonymous"`
+	ClosesAt  *time.Time `bson:"closes_at,omitempty" json:"closes_at,omitempty"`
+
+	Votes   []Vote       `bson:"votes,omitempty" json:"-"`
+	Results *PollResults `bson:"-" json:"results,omitempty"`
+}
+
+// Choices of a voter as indexes into the poll options.
+type Vote struct {
+	UserID  string    `bson:"userID" json:"userID"`
+	Choices []int     `bson:"choices" json:"choices"`
+	Time    time.Time `bson:"time" json:"time"`
+}
+
+// Tally of a poll as seen by one participant. Voters are left out of anonymous polls.
+type PollResults struct {
+	Counts  []int      `json:"counts"`
+	Voters  [][]string `json:"voters,omitempty"`
+	Total   int        `json:"total"`
+	Choices []int      `json:"choices"`
+	Closed  bool       `json:"closed"`
+}
+
+func (poll Poll) Closed(now time.Time) bool {
+	return poll.ClosesAt != nil && !now.Before(*poll.ClosesAt)
+}
+
+// Checks the poll of a poll message with question sent at sentAt.
+func checkPoll(kind, question string, poll *Poll, sentAt time.Time) error {
+	if kind != TypePoll {
+		if poll != nil {
+			return ErrUnexpectedPoll
+		}
+		return nil
+	}
+
+	if poll == nil {
+		return ErrInvalidPoll
+	}
+	if utf8.RuneCountInString(question) > MaxPollQuestion {
+		return ErrInvalidPoll
+	}
+	if len(poll.Options) < MinPollOptions || len(poll.Options) > MaxPollOptions {
+		return ErrInvalidPoll
+	}
+
+	seen := make(map[string]bool)
+	for _, option := range poll.Options {
+		trimmed := strings.TrimSpace(option)
+		if trimmed == "" || utf8.RuneCountInString(trimmed) > MaxPollOption || seen[trimmed] {
+			return ErrInvalidPoll
+		}
+		seen[trimmed] = true
+	}
+
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(sentAt) {
+		return ErrInvalidPoll
+	}
+
+	return nil
+}
+
+// Checks choices against the options of poll, an empty vote takes the vote back.
+func checkVote(poll Poll, choices []int) error {
+	if len(choices) > 1 && !poll.Multiple {
+		return ErrInvalidVote
+	}
+
+	seen := make(map[int]bool)
+	for _, choice := range choices {
+		if choice < 0 || choice >= len(poll.Options) || seen[choice] {
+			return ErrInvalidVote
+		}
+		seen[choice] = true
+	}
+
+	return nil
+}
+
+// Tallies the votes on poll as seen by userID.
+func TallyPoll(poll Poll, userID string) *PollResults {
+	results := &PollResults{
+		Counts:  make([]int, len(poll.Options)),
+		Total:   len(poll.Votes),
+		Choices: []int{},
+		Closed:  poll.Closed(time.Now()),
+	}
+
+	if !poll.Anonymous {
+		results.Voters = make([][]string, len(poll.Options))
+		for index := range results.Voters {
+			results.Voters[index] = []string{}
+		}
+	}
+
+	for _, vote := range poll.Votes {
+		for _, choice := range vote.Choices {
+			if choice < 0 || choice >= len(poll.Options) {
+				continue
+			}
+
+			results.Counts[choice]++
+			if !poll.Anonymous {
+				results.Voters[choice] = append(results.Voters[choice], vote.UserID)
+			}
+		}
+
+		if vote.UserID == userID {
+			results.Choices = vote.Choices
+		}
+	}
+
+	return results
+}
+
+// Sets the results of the poll on a loaded message as seen by userID.
+func tallyMessage(message *Message, userID string) {
+	if message.Poll != nil {
+		message.Poll.Results = TallyPoll(*message.Poll, userID)
+	}
+}
+
+// Replaces the vote of from on a poll in the conversation with to, and returns the updated poll.
+func (chat Chat) Vote(from, to, messageID string, choices []int) (Poll, error) {
+	err := chat.CheckBlocked(from, to)
+	if err != nil {
+		return Poll{}, err
+	}
+
+	contact, err := chat.contact(from, to)
+	if err != nil {
+		return Poll{}, err
+	}
+
+	return chat.vote(contact.Store, from, messageID, choices)
+}
+
+// Votes on a poll in the conversation kept in store. Polls only depend on the store, so
+// they work the same for any number of participants.
+func (chat Chat) vote(store, userID, messageID string, choices []int) (Poll, error) {
+	collection := chat.Database(mongo.Chat).Collection(store)
+
+	message, err := chat.findMessage(collection, messageID)
+	if err != nil {
+		return Poll{}, err
+	}
+	if message.Type != TypePoll || message.Poll == nil {
+		return Poll{}, ErrNotPoll
+	}
+
+	now := time.Now()
+
+	if message.Poll.Closed(now) {
+		return Poll{}, ErrPollClosed
+	}
+
+	err = checkVote(*message.Poll, choices)
+	if err != nil {
+		return Poll{}, err
+	}
+
+	// The previous vote is dropped and the new one added in a single pipeline update, and
+	// the close time is checked again so no vote lands after the poll closed.
+	votes := bson.D{{Key: "$filter", Value: bson.D{
+		{Key: "input", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$poll.votes", bson.A{}}}}},
+		{Key: "as", Value: "vote"},
+		{Key: "cond", Value: bson.D{{Key: "$ne", Value: bson.A{"$$vote.userID", userID}}}},
+	}}}
+
+	if len(choices) != 0 {
+		votes = bson.D{{Key: "$concatArrays", Value: bson.A{
+			votes,
+			bson.D{{Key: "$literal", Value: bson.A{Vote{
+				UserID:  userID,
+				Choices: choices,
+				Time:    now,
+			}}}},
+		}}}
+	}
+
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	var updated Message
+
+	err = collection.FindOneAndUpdate(context, bson.D{
+		{Key: "id", Value: messageID},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "poll.closes_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "poll.closes_at", Value: bson.D{{Key: "$gt", Value: now}}}},
+		}},
+	}, bson.A{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "poll.votes", Value: votes},
+		}}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+	if err == mongodb.ErrNoDocuments {
+		return Poll{}, ErrPollClosed
+	}
+	if err != nil {
+		return Poll{}, err
+	}
+
+	return *updated.Poll, nil
+}
+
+func pollPreview(message Message) string {
+	return "📊 " + message.Data
+}
@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPoll(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	options := func(options ...string) *Poll {
+		return &Poll{Options: options}
+	}
+
+	tests := []struct {
+		name     string
+		kind     string
+		question string
+		poll     *Poll
+		err      error
+	}{
+		{"text without poll", "text", "hello", nil, nil},
+		{"text with poll", "text", "hello", options("a", "b"), ErrUnexpectedPoll},
+		{"valid", TypePoll, "lunch?", options("pizza", "sushi"), nil},
+		{"missing poll", TypePoll, "lunch?", nil, ErrInvalidPoll},
+		{"one option", TypePoll, "lunch?", options("pizza"), ErrInvalidPoll},
+		{"too many options", TypePoll, "count", options(strings.Split("a b c d e f g h i j k", " ")...), ErrInvalidPoll},
+		{"blank option", TypePoll, "lunch?", options("pizza", "  "), ErrInvalidPoll},
+		{"duplicate option", TypePoll, "lunch?", options("pizza", " pizza "), ErrInvalidPoll},
+		{"option too long", TypePoll, "lunch?", options("pizza", strings.Repeat("x", MaxPollOption+1)), ErrInvalidPoll},
+		{"question too long", TypePoll, strings.Repeat("?", MaxPollQuestion+1), options("a", "b"), ErrInvalidPoll},
+		{"closes later", TypePoll, "lunch?", &Poll{Options: []string{"a", "b"}, ClosesAt: &later}, nil},
+		{"closes before sent", TypePoll, "lunch?", &Poll{Options: []string{"a", "b"}, ClosesAt: &earlier}, ErrInvalidPoll},
+	}
+
+	for _, test := range tests {
+		if err := checkPoll(test.kind, test.question, test.poll, now); !errors.Is(err, test.err) {
+			t.Errorf("%s: checkPoll = %v, expected %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestCheckVote(t *testing.T) {
+	single := Poll{Options: []string{"a", "b", "c"}}
+	multiple := Poll{Options: []string{"a", "b", "c"}, Multiple: true}
+
+	tests := []struct {
+		name    string
+		poll    Poll
+		choices []int
+		err     error
+	}{
+		{"one choice", single, []int{1}, nil},
+		{"taken back", single, []int{}, nil},
+		{"several on single choice", single, []int{0, 1}, ErrInvalidVote},
+		{"several on multiple choice", multiple, []int{0, 2}, nil},
+		{"out of range", multiple, []int{3}, ErrInvalidVote},
+		{"negative", multiple, []int{-1}, ErrInvalidVote},
+		{"repeated", multiple, []int{1, 1}, ErrInvalidVote},
+	}
+
+	for _, test := range tests {
+		if err := checkVote(test.poll, test.choices); !errors.Is(err, test.err) {
+			t.Errorf("%s: checkVote = %v, expected %v", test.name, err, test.err)
+		}
+	}
+}
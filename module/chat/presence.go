@@ -0,0 +1,168 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/attr"
+	"strings"
+	"time"
+
+	"github.com/rivo/uniseg"
+)
+
+const (
+	PresenceSettings = "chat_presence"
+
+	PresenceOnline    = "online"
+	PresenceAway      = "away"
+	PresenceBusy      = "busy"
+	PresenceInvisible = "invisible"
+	PresenceOffline   = "offline"
+
+	LastSeenEveryone = "everyone"
+	LastSeenContacts = "contacts"
+	LastSeenNobody   = "nobody"
+
+	MaxStatusLength = 100
+)
+
+var (
+	ErrInvalidPresence = errors.New("invalid presence state")
+	ErrInvalidStatus   = errors.New("invalid custom status")
+	ErrInvalidPrivacy  = errors.New("invalid privacy setting")
+)
+
+type CustomStatus struct {
+	Text      string     `json:"text,omitempty"`
+	Emoji     string     `json:"emoji,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Presence as chosen by the user, Mode is one of online, away, busy or invisible.
+type Presence struct {
+	Mode   string
+	Status *CustomStatus
+}
+
+// Websocket connection of a user as tracked by the server.
+type Connection struct {
+	Online bool
+	Idle   bool
+}
+
+// Presence of a user as shown to another user.
+type PresenceView struct {
+	UserID   string        `json:"userID"`
+	Online   bool          `json:"online"`
+	State    string        `json:"state"`
+	Status   *CustomStatus `json:"status,omitempty"`
+	LastSeen int64         `json:"last_seen,omitempty"`
+}
+
+// Returns the presence chosen by userID, expired custom statuses are dropped.
+func (chat Chat) GetPresence(userID string) (Presence, error) {
+	presence := Presence{Mode: PresenceOnline}
+
+	err := chat.GetAttribute(userID, PresenceSettings, &presence)
+	if err != nil && !errors.Is(err, attr.ErrKeyDoesNotExist) {
+		return Presence{}, err
+	}
+
+	if presence.Status != nil && presence.Status.ExpiresAt != nil && time.Now().After(*presence.Status.ExpiresAt) {
+		presence.Status = nil
+	}
+
+	return presence, nil
+}
+
+func (chat Chat) SetPresence(userID, mode string) error {
+	switch mode {
+	case PresenceOnline, PresenceAway, PresenceBusy, PresenceInvisible:
+	default:
+		return ErrInvalidPresence
+	}
+
+	presence, err := chat.GetPresence(userID)
+	if err != nil {
+		return err
+	}
+
+	presence.Mode = mode
+
+	return chat.SetAttribute(userID, PresenceSettings, presence)
+}
+
+// Sets the custom status of userID, a nil status clears it.
+func (chat Chat) SetStatus(userID string, status *CustomStatus) error {
+	if status != nil {
+		status.Text = strings.TrimSpace(status.Text)
+
+		if status.Text == "" && status.Emoji == "" {
+			status = nil
+		} else if uniseg.GraphemeClusterCount(status.Text) > MaxStatusLength {
+			return ErrInvalidStatus
+		} else if status.Emoji != "" && !ValidReaction(status.Emoji) {
+			return ErrInvalidStatus
+		} else if status.ExpiresAt != nil && !status.ExpiresAt.After(time.Now()) {
+			return ErrInvalidStatus
+		}
+	}
+
+	presence, err := chat.GetPresence(userID)
+	if err != nil {
+		return err
+	}
+
+	presence.Status = status
+
+	return chat.SetAttribute(userID, PresenceSettings, presence)
+}
+
+// Returns the presence of userID as seen by viewer. Invisible users appear offline,
+// idle users appear away, and last seen follows the privacy settings of userID.
+func (chat Chat) ViewPresence(userID, viewer string, connection Connection) (PresenceView, error) {
+	presence, err := chat.GetPresence(userID)
+	if err != nil {
+		return PresenceView{}, err
+	}
+
+	view := PresenceView{
+		UserID: userID,
+		State:  presence.Mode,
+		Status: presence.Status,
+	}
+
+	switch {
+	case !connection.Online || presence.Mode == PresenceInvisible:
+		view.State = PresenceOffline
+	case connection.Idle && presence.Mode == PresenceOnline:
+		view.State = PresenceAway
+	}
+
+	view.Online = view.State != PresenceOffline
+	if view.Online {
+		return view, nil
+	}
+
+	privacy, err := chat.GetPrivacy(userID)
+	if err != nil {
+		return PresenceView{}, err
+	}
+
+	visible := privacy.LastSeen == LastSeenEveryone
+	if privacy.LastSeen == LastSeenContacts {
+		relationship, err := chat.Relationship(userID, viewer)
+		if err != nil {
+			return PresenceView{}, err
+		}
+		visible = relationship.Connected
+	}
+
+	if visible {
+		err = chat.GetAttribute(userID, LastSeen, &view.LastSeen)
+		if err != nil && !errors.Is(err, attr.ErrKeyDoesNotExist) {
+			return PresenceView{}, err
+		}
+	}
+
+	return view, nil
+}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/db/mongo"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	PrivacySettings = "chat_privacy"
+)
+
+type Privacy struct {
+	ReadReceipts bool `json:"read_receipts"`
+	// Users who opt out of discovery are not found in the directory search.
+	Discoverable bool `json:"discoverable"`
+	// Who sees the last seen time: everyone, contacts or nobody.
+	LastSeen string `json:"last_seen"`
+}
+
+var DefaultPrivacy = Privacy{
+	ReadReceipts: true,
+	Discoverable: true,
+	LastSeen:     LastSeenEveryone,
+}
+
+// Settings are kept as JSON, so fields set to false are stored and fields added
+// later take their defaults.
+func encodePrivacy(privacy Privacy) ([]byte, error) {
+	return json.Marshal(privacy)
+}
+
+func decodePrivacy(data []byte) (Privacy, error) {
+	privacy := DefaultPrivacy
+
+	err := json.Unmarshal(data, &privacy)
+	if err != nil {
+		return Privacy{}, err
+	}
+
+	return privacy, nil
+}
+
+// Returns the privacy settings of the user, falling back to the defaults if never set.
+func (chat Chat) GetPrivacy(userID string) (Privacy, error) {
+	var data []byte
+
+	err := chat.GetAttribute(userID, PrivacySettings, &data)
+	if errors.Is(err, attr.ErrKeyDoesNotExist) {
+		return DefaultPrivacy, nil
+	}
+	if err != nil {
+		return Privacy{}, err
+	}
+
+	return decodePrivacy(data)
+}
+
+func (chat Chat) SetPrivacy(userID string, privacy Privacy) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	switch privacy.LastSeen {
+	case LastSeenEveryone, LastSeenContacts, LastSeenNobody:
+	default:
+		return ErrInvalidPrivacy
+	}
+
+	data, err := encodePrivacy(privacy)
+	if err != nil {
+		return err
+	}
+
+	err = chat.SetAttribute(userID, PrivacySettings, data)
+	if err != nil {
+		return err
+	}
+
+	// Kept on the account as well, so the directory search can filter on it.
+	_, err = chat.Database(mongo.Users).Collection(mongo.Accounts).UpdateOne(context, bson.D{
+		{Key: "userID", Value: userID},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "discoverable", Value: privacy.Discoverable},
+		}},
+	})
+
+	return err
+}
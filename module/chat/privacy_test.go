@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+// Stores and loads the settings the way attributes are kept, gob encoded.
+func storePrivacy(t *testing.T, privacy Privacy) Privacy {
+	t.Helper()
+
+	data, err := encodePrivacy(privacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := new(bytes.Buffer)
+	err = gob.NewEncoder(buffer).Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored []byte
+	err = gob.NewDecoder(buffer).Decode(&stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := decodePrivacy(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestPrivacyRoundTrip(t *testing.T) {
+	tests := []Privacy{
+		DefaultPrivacy,
+		{ReadReceipts: false, Discoverable: false, LastSeen: LastSeenEveryone},
+		{ReadReceipts: false, Discoverable: true, LastSeen: LastSeenContacts},
+		{ReadReceipts: true, Discoverable: false, LastSeen: LastSeenNobody},
+	}
+
+	for _, test := range tests {
+		if loaded := storePrivacy(t, test); loaded != test {
+			t.Errorf("stored %+v, loaded %+v", test, loaded)
+		}
+	}
+}
+
+func TestPrivacyMissingFields(t *testing.T) {
+	loaded, err := decodePrivacy([]byte(`{"read_receipts":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Privacy{ReadReceipts: false, Discoverable: true, LastSeen: LastSeenEveryone}
+	if loaded != expected {
+		t.Errorf("loaded %+v, expected %+v", loaded, expected)
+	}
+}
+
+// Turning discovery off and then changing another setting must not turn discovery back on.
+func TestPrivacyDiscoverableSequence(t *testing.T) {
+	privacy := storePrivacy(t, DefaultPrivacy)
+	if !privacy.Discoverable {
+		t.Fatal("discoverable by default")
+	}
+
+	privacy.Discoverable = false
+	privacy = storePrivacy(t, privacy)
+	if privacy.Discoverable {
+		t.Fatal("discoverable after turning it off")
+	}
+
+	privacy.LastSeen = LastSeenContacts
+	privacy = storePrivacy(t, privacy)
+	if privacy.Discoverable {
+		t.Error("discoverable after changing last seen")
+	}
+	if privacy.LastSeen != LastSeenContacts {
+		t.Errorf("last seen is %q", privacy.LastSeen)
+	}
+}
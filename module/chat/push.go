@@ -0,0 +1,70 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/attr"
+	"kevlar/module/push"
+	"time"
+)
+
+const (
+	// Oldest subscriptions are dropped beyond this many devices.
+	MaxSubscriptions = 10
+)
+
+// Returns the web push subscriptions of every device of userID.
+func (chat Chat) Subscriptions(userID string) ([]push.Subscription, error) {
+	var subscriptions []push.Subscription
+
+	err := chat.GetAttribute(userID, WebPushSubscription, &subscriptions)
+	if errors.Is(err, attr.ErrKeyDoesNotExist) {
+		return []push.Subscription{}, nil
+	}
+
+	return subscriptions, err
+}
+
+// Registers the subscription of a device, replacing an earlier one with the same endpoint.
+func (chat Chat) Subscribe(userID string, subscription push.Subscription) error {
+	subscriptions, err := chat.Subscriptions(userID)
+	if err != nil {
+		return err
+	}
+
+	subscription.CreatedAt = time.Now()
+
+	kept := []push.Subscription{}
+	for _, value := range subscriptions {
+		if value.Endpoint != subscription.Endpoint {
+			kept = append(kept, value)
+		}
+	}
+
+	kept = append(kept, subscription)
+	if len(kept) > MaxSubscriptions {
+		kept = kept[len(kept)-MaxSubscriptions:]
+	}
+
+	return chat.SetAttribute(userID, WebPushSubscription, kept)
+}
+
+// Removes the subscription with the endpoint, removing an unknown endpoint is not an error.
+func (chat Chat) Unsubscribe(userID, endpoint string) error {
+	subscriptions, err := chat.Subscriptions(userID)
+	if err != nil {
+		return err
+	}
+
+	kept := []push.Subscription{}
+	for _, value := range subscriptions {
+		if value.Endpoint != endpoint {
+			kept = append(kept, value)
+		}
+	}
+
+	if len(kept) == len(subscriptions) {
+		return nil
+	}
+
+	return chat.SetAttribute(userID, WebPushSubscription, kept)
+}
@@ -0,0 +1,224 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/db/mongo"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidReaction = errors.New("reaction is not a single emoji")
+)
+
+type Reaction struct {
+	UserID string `bson:"userID" json:"userID"`
+	Emoji  string `bson:"emoji" json:"emoji"`
+}
+
+type ReactionCount struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// Characters shown as emoji by default (Emoji_Presentation in Unicode 14), regional indicators
+// excepted as they only form an emoji in pairs.
+var emojiPresentation = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1},
+		{Lo: 0x23e9, Hi: 0x23ec, Stride: 1},
+		{Lo: 0x23f0, Hi: 0x23f3, Stride: 3},
+		{Lo: 0x25fd, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2614, Hi: 0x2615, Stride: 1},
+		{Lo: 0x2648, Hi: 0x2653, Stride: 1},
+		{Lo: 0x267f, Hi: 0x2693, Stride: 20},
+		{Lo: 0x26a1, Hi: 0x26a1, Stride: 1},
+		{Lo: 0x26aa, Hi: 0x26ab, Stride: 1},
+		{Lo: 0x26bd, Hi: 0x26be, Stride: 1},
+		{Lo: 0x26c4, Hi: 0x26c5, Stride: 1},
+		{Lo: 0x26ce, Hi: 0x26d4, Stride: 6},
+		{Lo: 0x26ea, Hi: 0x26ea, Stride: 1},
+		{Lo: 0x26f2, Hi: 0x26f3, Stride: 1},
+		{Lo: 0x26f5, Hi: 0x26fa, Stride: 5},
+		{Lo: 0x26fd, Hi: 0x26fd, Stride: 1},
+		{Lo: 0x2705, Hi: 0x2705, Stride: 1},
+		{Lo: 0x270a, Hi: 0x270b, Stride: 1},
+		{Lo: 0x2728, Hi: 0x2728, Stride: 1},
+		{Lo: 0x274c, Hi: 0x274e, Stride: 2},
+		{Lo: 0x2753, Hi: 0x2755, Stride: 1},
+		{Lo: 0x2757, Hi: 0x2757, Stride: 1},
+		{Lo: 0x2795, Hi: 0x2797, Stride: 1},
+		{Lo: 0x27b0, Hi: 0x27bf, Stride: 15},
+		{Lo: 0x2b1b, Hi: 0x2b1c, Stride: 1},
+		{Lo: 0x2b50, Hi: 0x2b55, Stride: 5},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f004, Hi: 0x1f004, Stride: 1},
+		{Lo: 0x1f0cf, Hi: 0x1f0cf, Stride: 1},
+		{Lo: 0x1f18e, Hi: 0x1f18e, Stride: 1},
+		{Lo: 0x1f191, Hi: 0x1f19a, Stride: 1},
+		{Lo: 0x1f201, Hi: 0x1f201, Stride: 1},
+		{Lo: 0x1f21a, Hi: 0x1f21a, Stride: 1},
+		{Lo: 0x1f22f, Hi: 0x1f22f, Stride: 1},
+		{Lo: 0x1f232, Hi: 0x1f236, Stride: 1},
+		{Lo: 0x1f238, Hi: 0x1f23a, Stride: 1},
+		{Lo: 0x1f250, Hi: 0x1f251, Stride: 1},
+		{Lo: 0x1f300, Hi: 0x1f64f, Stride: 1},
+		{Lo: 0x1f680, Hi: 0x1f6ff, Stride: 1},
+		{Lo: 0x1f7e0, Hi: 0x1f7f0, Stride: 1},
+		{Lo: 0x1f90c, Hi: 0x1f9ff, Stride: 1},
+		{Lo: 0x1fa70, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+// Characters that are text by default and become emoji when followed by the emoji
+// variation selector, such as ©️ or ☺️.
+var emojiText = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21a9, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x2328, Hi: 0x23cf, Stride: 167},
+		{Lo: 0x23ed, Hi: 0x23ef, Stride: 1},
+		{Lo: 0x23f1, Hi: 0x23f2, Stride: 1},
+		{Lo: 0x23f8, Hi: 0x23fa, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25ab, Stride: 1},
+		{Lo: 0x25b6, Hi: 0x25c0, Stride: 10},
+		{Lo: 0x25fb, Hi: 0x25fc, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b07, Stride: 1},
+		{Lo: 0x3030, Hi: 0x303d, Stride: 13},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1},
+	},
+}
+
+const (
+	variationEmoji = '\ufe0f'
+	keycap         = '\u20e3'
+)
+
+func regionalIndicator(letter rune) bool {
+	return letter >= 0x1f1e6 && letter <= 0x1f1ff
+}
+
+// Checks that the reaction is exactly one emoji: an emoji character with its modifiers and
+// joined sequences, a flag, a keycap, or a text symbol in emoji presentation. Other symbols
+// such as box drawing or a plain © are refused.
+func ValidReaction(emoji string) bool {
+	if uniseg.GraphemeClusterCount(emoji) != 1 {
+		return false
+	}
+
+	letters := []rune(emoji)
+
+	// Keycap sequences such as 1️⃣.
+	if len(letters) >= 2 && letters[len(letters)-1] == keycap {
+		base := letters[0]
+		return (base >= '0' && base <= '9') || base == '#' || base == '*'
+	}
+
+	// Flags are pairs of regional indicators.
+	if regionalIndicator(letters[0]) {
+		return len(letters) == 2 && regionalIndicator(letters[1])
+	}
+
+	for index, letter := range letters {
+		if unicode.Is(emojiPresentation, letter) {
+			return true
+		}
+		if unicode.Is(emojiText, letter) && index+1 < len(letters) && letters[index+1] == variationEmoji {
+			return true
+		}
+	}
+	return false
+}
+
+// Aggregates the reactions on a message in order of first use, marking the ones made by userID.
+func CountReactions(reactions []Reaction, userID string) []ReactionCount {
+	counts := []ReactionCount{}
+
+	for _, reaction := range reactions {
+		found := false
+
+		for index, value := range counts {
+			if value.Emoji == reaction.Emoji {
+				counts[index].Count++
+				if reaction.UserID == userID {
+					counts[index].Reacted = true
+				}
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			counts = append(counts, ReactionCount{
+				Emoji:   reaction.Emoji,
+				Count:   1,
+				Reacted: reaction.UserID == userID,
+			})
+		}
+	}
+
+	return counts
+}
+
+// Adds or removes the reaction of from on a message in the conversation with to, and returns the updated reactions.
+func (chat Chat) React(from, to, messageID, emoji string, remove bool) ([]Reaction, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	if !ValidReaction(emoji) {
+		return nil, ErrInvalidReaction
+	}
+
+	err := chat.CheckBlocked(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	contact, err := chat.contact(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	reaction := Reaction{
+		UserID: from,
+		Emoji:  emoji,
+	}
+
+	operator := "$addToSet"
+	if remove {
+		operator = "$pull"
+	}
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var message Message
+
+	err = collection.FindOneAndUpdate(context, bson.D{
+		{Key: "id", Value: messageID},
+	}, bson.D{
+		{Key: operator, Value: bson.D{
+			{Key: "reactions", Value: reaction},
+		}},
+	}, options).Decode(&message)
+	if err != nil {
+		return nil, err
+	}
+
+	return message.Reactions, nil
+}
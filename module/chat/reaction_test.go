@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidReaction(t *testing.T) {
+	tests := []struct {
+		emoji string
+		valid bool
+	}{
+		{"👍", true},
+		{"😂", true},
+		{"👍🏽", true},      // skin tone modifier
+		{"👩‍💻", true},     // ZWJ sequence
+		{"👨‍👩‍👧‍👦", true}, // family
+		{"🇳🇱", true},      // flag
+		{"🏴󠁧󠁢󠁥󠁮󠁧󠁿", true}, // tag sequence
+		{"1️⃣", true},     // keycap
+		{"#️⃣", true},     // keycap
+		{"❤️", true},      // text symbol in emoji presentation
+		{"©️", true},      // text symbol in emoji presentation
+		{"⭐", true},
+		{"✅", true},
+		{"", false},
+		{"a", false},
+		{"1", false},
+		{"©", false},
+		{"®", false},
+		{"─", false}, // box drawing
+		{"→", false},
+		{"★", false},
+		{"🇳", false}, // lone regional indicator
+		{"👍👍", false},
+		{"👍a", false},
+		{"a⃣", false},
+	}
+
+	for _, test := range tests {
+		if valid := ValidReaction(test.emoji); valid != test.valid {
+			t.Errorf("ValidReaction(%q) = %v, expected %v", test.emoji, valid, test.valid)
+		}
+	}
+}
+
+func TestCountReactions(t *testing.T) {
+	tests := []struct {
+		name      string
+		reactions []Reaction
+		userID    string
+		expected  []ReactionCount
+	}{
+		{
+			name:     "none",
+			expected: []ReactionCount{},
+		},
+		{
+			name: "grouped in order of first use",
+			reactions: []Reaction{
+				{UserID: "alice", Emoji: "👍"},
+				{UserID: "bob", Emoji: "😂"},
+				{UserID: "carol", Emoji: "👍"},
+			},
+			userID: "dave",
+			expected: []ReactionCount{
+				{Emoji: "👍", Count: 2},
+				{Emoji: "😂", Count: 1},
+			},
+		},
+		{
+			name: "reacted by the viewer",
+			reactions: []Reaction{
+				{UserID: "alice", Emoji: "👍"},
+				{UserID: "bob", Emoji: "👍"},
+				{UserID: "bob", Emoji: "🎉"},
+			},
+			userID: "bob",
+			expected: []ReactionCount{
+				{Emoji: "👍", Count: 2, Reacted: true},
+				{Emoji: "🎉", Count: 1, Reacted: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if counts := CountReactions(test.reactions, test.userID); !reflect.DeepEqual(counts, test.expected) {
+			t.Errorf("%s: CountReactions = %+v, expected %+v", test.name, counts, test.expected)
+		}
+	}
+}
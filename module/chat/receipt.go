@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"kevlar/module/db/mongo"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+
+	ReceiptUpdate = "receipt_update"
+)
+
+type Receipt struct {
+	UserID string    `json:"userID"`
+	Status string    `json:"status"`
+	IDs    []string  `json:"ids,omitempty"`
+	UpTo   string    `json:"up_to,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Marks the messages sent by to in the conversation with from as delivered to from.
+func (chat Chat) MarkDelivered(from, to string, messageIDs []string) (Receipt, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	contact, err := chat.contact(from, to)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	now := time.Now()
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	_, err = collection.UpdateMany(context, bson.D{
+		{Key: "id", Value: bson.D{
+			{Key: "$in", Value: messageIDs},
+		}},
+		{Key: "from", Value: to},
+		{Key: "status", Value: StatusSent},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: StatusDelivered},
+			{Key: "delivered_at", Value: now},
+		}},
+	})
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	return Receipt{
+		UserID: from,
+		Status: StatusDelivered,
+		IDs:    messageIDs,
+		Time:   now,
+	}, nil
+}
+
+// Marks every message sent by to in the conversation with from up to and including
+// messageID as read. The returned receipt only has StatusRead if from sends read receipts.
+func (chat Chat) MarkRead(from, to, messageID string) (Receipt, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	contact, err := chat.contact(from, to)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	privacy, err := chat.GetPrivacy(from)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	last, err := chat.findMessage(collection, messageID)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	now := time.Now()
+
+	filter := bson.D{
+		{Key: "from", Value: to},
+		{Key: "time", Value: bson.D{
+			{Key: "$lte", Value: last.Time},
+		}},
+		{Key: "read", Value: false},
+	}
+
+	update := bson.D{
+		{Key: "read", Value: true},
+	}
+
+	status := StatusDelivered
+	if privacy.ReadReceipts {
+		status = StatusRead
+		update = append(update, bson.E{Key: "read_at", Value: now})
+	}
+	update = append(update, bson.E{Key: "status", Value: status})
+
+	_, err = collection.UpdateMany(context, filter, bson.D{
+		{Key: "$set", Value: update},
+	})
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	return Receipt{
+		UserID: from,
+		Status: status,
+		UpTo:   messageID,
+		Time:   now,
+	}, nil
+}
@@ -0,0 +1,333 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"kevlar/module/db/mongo"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a relationship, a pair without a document is in RelationNone.
+const (
+	RelationNone     = "none"
+	RelationPending  = "pending"
+	RelationAccepted = "accepted"
+	RelationBlocked  = "blocked"
+)
+
+var (
+	ErrAlreadyContact = errors.New("user is already a contact")
+)
+
+// Relationship between two users, stored once per pair. Connected is kept while a pair is
+// blocked, so the pair returns to accepted once neither side blocks the other.
+type Relationship struct {
+	Pair  string   `bson:"pair"`
+	Users []string `bson:"users"`
+
+	State       string   `bson:"state"`
+	RequestedBy string   `bson:"requested_by,omitempty"`
+	BlockedBy   []string `bson:"blocked_by,omitempty"`
+	Connected   bool     `bson:"connected"`
+
+	// Conversation of the pair and the users who kept it after the contact was removed.
+	Store   string   `bson:"store,omitempty"`
+	Kept    []string `bson:"kept,omitempty"`
+	Subline string   `bson:"message,omitempty"`
+
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+func pair(from, to string) (string, []string) {
+	users := []string{from, to}
+	sort.Strings(users)
+
+	return users[0] + ":" + users[1], users
+}
+
+func has(list []string, userID string) bool {
+	for _, value := range list {
+		if value == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, userID string) []string {
+	kept := []string{}
+	for _, value := range list {
+		if value != userID {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// Returns the other user of the pair.
+func (relationship Relationship) Other(userID string) string {
+	for _, value := range relationship.Users {
+		if value != userID {
+			return value
+		}
+	}
+	return userID
+}
+
+// Derives the state from the fields of the relationship.
+func (relationship *Relationship) settle() {
+	switch {
+	case len(relationship.BlockedBy) != 0:
+		relationship.State = RelationBlocked
+		relationship.RequestedBy = ""
+	case relationship.Connected:
+		relationship.State = RelationAccepted
+		relationship.RequestedBy = ""
+	case relationship.RequestedBy != "":
+		relationship.State = RelationPending
+	default:
+		relationship.State = RelationNone
+	}
+
+	if relationship.Connected {
+		relationship.Kept = nil
+	}
+	if !relationship.Connected && len(relationship.Kept) == 0 {
+		relationship.Store = ""
+		relationship.Subline = ""
+	}
+}
+
+func (chat Chat) loadRelationship(context context.Context, from, to string) (Relationship, error) {
+	key, users := pair(from, to)
+
+	var relationship Relationship
+
+	err := chat.Database(mongo.Users).Collection(mongo.Relationships).FindOne(context, bson.D{
+		{Key: "pair", Value: key},
+	}).Decode(&relationship)
+	if err == mongodb.ErrNoDocuments {
+		return Relationship{
+			Pair:  key,
+			Users: users,
+			State: RelationNone,
+		}, nil
+	}
+
+	return relationship, err
+}
+
+// Returns the relationship between from and to.
+func (chat Chat) Relationship(from, to string) (Relationship, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	return chat.loadRelationship(context, from, to)
+}
+
+// Applies change to the relationship between from and to in a transaction and returns the
+// relationship before and after. Relationships that return to RelationNone without a kept
+// conversation are removed.
+func (chat Chat) transition(from, to string, change func(*Relationship) error) (Relationship, Relationship, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	session, err := chat.StartSession()
+	if err != nil {
+		return Relationship{}, Relationship{}, err
+	}
+	defer session.EndSession(context)
+
+	var before, after Relationship
+
+	_, err = session.WithTransaction(context, func(context mongodb.SessionContext) (interface{}, error) {
+		relationship, err := chat.loadRelationship(context, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		before = relationship
+
+		err = change(&relationship)
+		if err != nil {
+			return nil, err
+		}
+
+		relationship.settle()
+		relationship.UpdatedAt = time.Now()
+
+		after = relationship
+
+		collection := chat.Database(mongo.Users).Collection(mongo.Relationships)
+
+		filter := bson.D{
+			{Key: "pair", Value: relationship.Pair},
+		}
+
+		if relationship.State == RelationNone && relationship.Store == "" {
+			_, err = collection.DeleteOne(context, filter)
+			return nil, err
+		}
+
+		_, err = collection.ReplaceOne(context, filter, relationship, options.Replace().SetUpsert(true))
+		return nil, err
+	})
+
+	return before, after, err
+}
+
+// Returns the relationships of userID matching filter.
+func (chat Chat) relationships(userID string, filter bson.D) ([]Relationship, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	return chat.findRelationships(context, userID, filter)
+}
+
+func (chat Chat) findRelationships(context context.Context, userID string, filter bson.D) ([]Relationship, error) {
+	options := options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}})
+
+	cursor, err := chat.Database(mongo.Users).Collection(mongo.Relationships).Find(context,
+		append(bson.D{{Key: "users", Value: userID}}, filter...), options)
+	if err != nil {
+		return nil, err
+	}
+
+	relationships := []Relationship{}
+
+	err = cursor.All(context, &relationships)
+
+	return relationships, err
+}
+
+// Resolves the other users of the relationships, with the last message as the subline of conversations.
+func (chat Chat) users(userID string, relationships []Relationship) ([]User, error) {
+	users := []User{}
+
+	for _, relationship := range relationships {
+		user, err := chat.GetInformation(relationship.Other(userID))
+
+		// Conversations kept with a deleted account are still listed.
+		if err == mongodb.ErrNoDocuments {
+			user, err = User{UserID: relationship.Other(userID)}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if relationship.Store != "" {
+			user.Store = relationship.Store
+			user.Message = relationship.Subline
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// Returns the contacts of userID, including contacts blocked by either side.
+func (chat Chat) Contacts(userID string) ([]User, error) {
+	relationships, err := chat.relationships(userID, bson.D{
+		{Key: "connected", Value: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chat.users(userID, relationships)
+}
+
+// Returns the users with a pending request from userID.
+func (chat Chat) Outgoing(userID string) ([]User, error) {
+	relationships, err := chat.relationships(userID, bson.D{
+		{Key: "state", Value: RelationPending},
+		{Key: "requested_by", Value: userID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chat.users(userID, relationships)
+}
+
+// Returns the users with a pending request to userID.
+func (chat Chat) Incoming(userID string) ([]User, error) {
+	relationships, err := chat.relationships(userID, bson.D{
+		{Key: "state", Value: RelationPending},
+		{Key: "requested_by", Value: bson.D{{Key: "$ne", Value: userID}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chat.users(userID, relationships)
+}
+
+// Returns the users blocked by userID.
+func (chat Chat) Blocked(userID string) ([]User, error) {
+	relationships, err := chat.relationships(userID, bson.D{
+		{Key: "blocked_by", Value: userID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chat.users(userID, relationships)
+}
+
+// Returns the userIDs of the users who blocked userID.
+func (chat Chat) Blockers(userID string) ([]string, error) {
+	relationships, err := chat.relationships(userID, bson.D{
+		{Key: "state", Value: RelationBlocked},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blockers := []string{}
+	for _, relationship := range relationships {
+		other := relationship.Other(userID)
+		if has(relationship.BlockedBy, other) {
+			blockers = append(blockers, other)
+		}
+	}
+
+	return blockers, nil
+}
+
+// Returns the removed contacts whose conversation userID kept.
+func (chat Chat) FormerContacts(userID string) ([]User, error) {
+	relationships, err := chat.relationships(userID, bson.D{
+		{Key: "connected", Value: false},
+		{Key: "kept", Value: userID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chat.users(userID, relationships)
+}
+
+// Updates the subline shown for the conversation of the pair.
+func (chat Chat) setSubline(from, to, subline string) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	key, _ := pair(from, to)
+
+	_, err := chat.Database(mongo.Users).Collection(mongo.Relationships).UpdateOne(context, bson.D{
+		{Key: "pair", Value: key},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "message", Value: subline},
+		}},
+	})
+
+	return err
+}
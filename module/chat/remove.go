@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"kevlar/module/db/mongo"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	MessageRemoved = "message_removed"
+)
+
+// Removes a message from the conversation between from and to, along with the stars on it.
+func (chat Chat) RemoveMessage(from, to, messageID string) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	contact, err := chat.conversation(from, to)
+	if err != nil {
+		return err
+	}
+
+	result, err := chat.Database(mongo.Chat).Collection(contact.Store).DeleteOne(context, bson.D{
+		{Key: "id", Value: messageID},
+	})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrMessageDoesNotExist
+	}
+
+	_, err = chat.Database(mongo.Users).Collection(mongo.Stars).DeleteMany(context, bson.D{
+		{Key: "id", Value: messageID},
+	})
+
+	return err
+}
@@ -15,149 +15,79 @@ var (
 
 func (chat Chat) Request(from, to string) error {
 
-	var outgoing []User
-	err := chat.GetAttribute(from, OutgoingList, &outgoing)
-	if err != nil {
-		return err
+	if from == to {
+		return ErrSelfRequest
 	}
 
-	for _, user := range outgoing {
-		if user.UserID == to {
-			return ErrRequestSent
+	// Both users must exist.
+	for _, userID := range []string{from, to} {
+		_, err := chat.GetInformation(userID)
+		if err != nil {
+			return err
 		}
 	}
 
-	var incoming []User
-	err = chat.GetAttribute(to, IncomingList, &incoming)
-	if err != nil {
-		return err
-	}
-
-	from_user, err := chat.GetInformation(from)
-	if err != nil {
-		return err
-	}
-
-	to_user, err := chat.GetInformation(to)
-	if err != nil {
-		return err
-	}
-
-	incoming = append(incoming, from_user)
-	outgoing = append(outgoing, to_user)
-
-	err = chat.SetAttribute(to, IncomingList, incoming)
-	if err != nil {
-		return err
-	}
+	_, _, err := chat.transition(from, to, func(relationship *Relationship) error {
+		switch relationship.State {
+		case RelationBlocked:
+			return ErrBlocked
+		case RelationAccepted:
+			return ErrAlreadyContact
+		case RelationPending:
+			return ErrRequestSent
+		}
 
-	err = chat.SetAttribute(from, OutgoingList, outgoing)
-	if err != nil {
-		return err
-	}
+		relationship.RequestedBy = from
+		return nil
+	})
 
-	return nil
+	return err
 }
 
 func (chat Chat) Cancel(from, to string) error {
 
-	var outgoing []User
-	err := chat.GetAttribute(from, OutgoingList, &outgoing)
-	if err != nil {
-		return err
-	}
-
-	var incoming []User
-	err = chat.GetAttribute(to, IncomingList, &incoming)
-	if err != nil {
-		return err
-	}
-
-	found := false
-
-	for index, value := range outgoing {
-		if value.UserID == to {
-			outgoing = append(outgoing[:index], outgoing[index+1:]...)
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return ErrRequestNotSent
-	}
-
-	for index, value := range incoming {
-		if value.UserID == from {
-			incoming = append(incoming[:index], incoming[index+1:]...)
-			found = true
-			break
+	_, _, err := chat.transition(from, to, func(relationship *Relationship) error {
+		if relationship.State != RelationPending || relationship.RequestedBy != from {
+			return ErrRequestNotSent
 		}
-	}
-
-	if !found {
-		return ErrRequestNotSent
-	}
-
-	err = chat.SetAttribute(to, IncomingList, incoming)
-	if err != nil {
-		return err
-	}
 
-	err = chat.SetAttribute(from, OutgoingList, outgoing)
+		relationship.RequestedBy = ""
+		return nil
+	})
 
 	return err
 }
 
+// Accepts or rejects the request from to. An accepted pair continues the conversation
+// kept by either side after an earlier removal, or starts a new one.
 func (chat Chat) Decide(from, to string, decide bool) error {
 
-	err := chat.Cancel(to, from)
-	if err != nil {
-		return err
-	}
-
-	if decide {
-		store := uuid.New().String()
-
-		var from_contacts []User
-		err = chat.GetAttribute(from, ContactList, &from_contacts)
-		if err != nil {
-			return err
+	_, after, err := chat.transition(from, to, func(relationship *Relationship) error {
+		if relationship.State != RelationPending || relationship.RequestedBy != to {
+			return ErrRequestNotSent
 		}
 
-		to_user, err := chat.GetInformation(to)
-		if err != nil {
-			return err
-		}
-
-		to_user.Store = store
-
-		from_contacts = append(from_contacts, to_user)
+		relationship.RequestedBy = ""
 
-		err = chat.SetAttribute(from, ContactList, from_contacts)
-		if err != nil {
-			return err
-		}
+		if decide {
+			relationship.Connected = true
 
-		var to_contacts []User
-		err = chat.GetAttribute(to, ContactList, &to_contacts)
-		if err != nil {
-			return err
+			if relationship.Store == "" {
+				relationship.Store = uuid.New().String()
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		from_user, err := chat.GetInformation(from)
-		if err != nil {
-			return err
-		}
-
-		from_user.Store = store
-
-		to_contacts = append(to_contacts, from_user)
-
-		err = chat.SetAttribute(to, ContactList, to_contacts)
+	if decide {
+		err = chat.InitializeConversation(after.Store)
 		if err != nil {
 			return err
 		}
 	}
+
 	return nil
 }
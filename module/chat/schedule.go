@@ -0,0 +1,286 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/db/mongo"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ScheduledPending = "pending"
+	ScheduledSending = "sending"
+	ScheduledSent    = "sent"
+	ScheduledFailed  = "failed"
+
+	ScheduledUpdate = "scheduled_message_update"
+
+	MaxScheduleAhead = 365 * 24 * time.Hour
+
+	// A job claimed for longer than this is assumed to belong to a stopped server.
+	claimTimeout = 5 * time.Minute
+)
+
+var (
+	ErrScheduleInPast        = errors.New("scheduled time is in the past")
+	ErrScheduleTooFar        = errors.New("scheduled time is too far ahead")
+	ErrScheduledDoesNotExist = errors.New("scheduled message does not exist or was already sent")
+)
+
+type ScheduledMessage struct {
+	ID   string `bson:"id" json:"id"`
+	From string `bson:"from" json:"from"`
+	To   string `bson:"to" json:"to"`
+
+	Message Outgoing  `bson:"message" json:"message"`
+	SendAt  time.Time `bson:"send_at" json:"send_at"`
+
+	Status    string     `bson:"status" json:"status"`
+	ClaimedAt *time.Time `bson:"claimed_at,omitempty" json:"-"`
+	MessageID string     `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	Error     string     `bson:"error,omitempty" json:"error,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+func checkSchedule(outgoing Outgoing, sendAt time.Time) error {
+	if strings.TrimSpace(outgoing.Data) == "" && len(outgoing.Files) == 0 {
+		return ErrMessageBlank
+	}
+	if Reserved(outgoing.Type) {
+		return ErrReservedType
+	}
+	if !sendAt.After(time.Now()) {
+		return ErrScheduleInPast
+	}
+	if sendAt.After(time.Now().Add(MaxScheduleAhead)) {
+		return ErrScheduleTooFar
+	}
+	return checkPoll(outgoing.Type, outgoing.Data, outgoing.Poll, sendAt)
+}
+
+// Schedules a message from from to to, sent at sendAt.
+func (chat Chat) Schedule(from, to string, outgoing Outgoing, sendAt time.Time) (ScheduledMessage, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	err := checkSchedule(outgoing, sendAt)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+
+	_, err = chat.contact(from, to)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+
+	scheduled := ScheduledMessage{
+		ID:        uuid.New().String(),
+		From:      from,
+		To:        to,
+		Message:   outgoing,
+		SendAt:    sendAt,
+		Status:    ScheduledPending,
+		CreatedAt: time.Now(),
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Scheduled)
+
+	_, err = collection.InsertOne(context, scheduled)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+
+	return scheduled, nil
+}
+
+// Pending scheduled messages of a user, soonest first. Next loads the ones after them
+// and is empty when there are no more.
+type ScheduledPage struct {
+	Scheduled []ScheduledMessage `json:"scheduled"`
+	Next      string             `json:"next,omitempty"`
+}
+
+// Lists the pending scheduled messages of userID after the cursor, ordered by send time
+// and then ID. An empty cursor starts with the soonest.
+func (chat Chat) ListScheduled(userID, after string) (ScheduledPage, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	filter := bson.D{
+		{Key: "from", Value: userID},
+		{Key: "status", Value: ScheduledPending},
+	}
+
+	if after != "" {
+		cursor, err := DecodeCursor(after)
+		if err != nil {
+			return ScheduledPage{}, err
+		}
+
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.D{
+				{Key: "send_at", Value: bson.D{{Key: "$gt", Value: cursor.Time}}},
+			},
+			bson.D{
+				{Key: "send_at", Value: cursor.Time},
+				{Key: "id", Value: bson.D{{Key: "$gt", Value: cursor.ID}}},
+			},
+		}})
+	}
+
+	options := options.Find().SetLimit(MaxResults + 1)
+	options.Sort = bson.D{{Key: "send_at", Value: 1}, {Key: "id", Value: 1}}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Scheduled)
+
+	cursor, err := collection.Find(context, filter, options)
+	if err != nil {
+		return ScheduledPage{}, err
+	}
+
+	page := ScheduledPage{
+		Scheduled: []ScheduledMessage{},
+	}
+
+	err = cursor.All(context, &page.Scheduled)
+	if err != nil {
+		return ScheduledPage{}, err
+	}
+
+	if len(page.Scheduled) > MaxResults {
+		page.Scheduled = page.Scheduled[:MaxResults]
+
+		last := page.Scheduled[len(page.Scheduled)-1]
+		page.Next = Cursor{Time: last.SendAt, ID: last.ID}.Encode()
+	}
+
+	return page, nil
+}
+
+// Changes the content and send time of a pending scheduled message of userID.
+func (chat Chat) UpdateScheduled(userID, scheduledID string, outgoing Outgoing, sendAt time.Time) (ScheduledMessage, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	err := checkSchedule(outgoing, sendAt)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Scheduled)
+
+	var scheduled ScheduledMessage
+
+	err = collection.FindOneAndUpdate(context, bson.D{
+		{Key: "id", Value: scheduledID},
+		{Key: "from", Value: userID},
+		{Key: "status", Value: ScheduledPending},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "message", Value: outgoing},
+			{Key: "send_at", Value: sendAt},
+		}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&scheduled)
+	if err == mongodb.ErrNoDocuments {
+		return ScheduledMessage{}, ErrScheduledDoesNotExist
+	}
+
+	return scheduled, err
+}
+
+// Removes a pending scheduled message of userID.
+func (chat Chat) CancelScheduled(userID, scheduledID string) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Scheduled)
+
+	result, err := collection.DeleteOne(context, bson.D{
+		{Key: "id", Value: scheduledID},
+		{Key: "from", Value: userID},
+		{Key: "status", Value: ScheduledPending},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrScheduledDoesNotExist
+	}
+
+	return nil
+}
+
+// Claims the next scheduled message that is due, so that only one server sends it.
+// Returns ErrScheduledDoesNotExist when nothing is due.
+func (chat Chat) ClaimScheduled() (ScheduledMessage, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	now := time.Now()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Scheduled)
+
+	var scheduled ScheduledMessage
+
+	err := collection.FindOneAndUpdate(context, bson.D{
+		{Key: "send_at", Value: bson.D{{Key: "$lte", Value: now}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "status", Value: ScheduledPending}},
+			bson.D{
+				{Key: "status", Value: ScheduledSending},
+				{Key: "claimed_at", Value: bson.D{{Key: "$lt", Value: now.Add(-claimTimeout)}}},
+			},
+		}},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: ScheduledSending},
+			{Key: "claimed_at", Value: now},
+		}},
+	}, options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "send_at", Value: 1}}).
+		SetReturnDocument(options.After)).Decode(&scheduled)
+	if err == mongodb.ErrNoDocuments {
+		return ScheduledMessage{}, ErrScheduledDoesNotExist
+	}
+
+	return scheduled, err
+}
+
+// Records the result of sending a claimed scheduled message.
+func (chat Chat) CompleteScheduled(scheduled ScheduledMessage, messageID string, failure error) (ScheduledMessage, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	update := bson.D{
+		{Key: "status", Value: ScheduledSent},
+		{Key: "message_id", Value: messageID},
+	}
+	scheduled.Status = ScheduledSent
+	scheduled.MessageID = messageID
+
+	if failure != nil {
+		update = bson.D{
+			{Key: "status", Value: ScheduledFailed},
+			{Key: "error", Value: failure.Error()},
+		}
+		scheduled.Status = ScheduledFailed
+		scheduled.Error = failure.Error()
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Scheduled)
+
+	_, err := collection.UpdateOne(context, bson.D{
+		{Key: "id", Value: scheduled.ID},
+	}, bson.D{
+		{Key: "$set", Value: update},
+	})
+
+	return scheduled, err
+}
@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckSchedule(t *testing.T) {
+	soon := time.Now().Add(time.Hour)
+	poll := &Poll{Options: []string{"yes", "no"}}
+
+	tests := []struct {
+		name     string
+		outgoing Outgoing
+		sendAt   time.Time
+		err      error
+	}{
+		{"text", Outgoing{Type: "text", Data: "hello"}, soon, nil},
+		{"attachment only", Outgoing{Type: "attachment", Files: []FileReference{{}}}, soon, nil},
+		{"blank", Outgoing{Type: "text", Data: "  "}, soon, ErrMessageBlank},
+		{"reserved type", Outgoing{Type: TypeCall, Data: "{}"}, soon, ErrReservedType},
+		{"in the past", Outgoing{Type: "text", Data: "hello"}, time.Now().Add(-time.Minute), ErrScheduleInPast},
+		{"too far ahead", Outgoing{Type: "text", Data: "hello"}, time.Now().Add(MaxScheduleAhead + time.Hour), ErrScheduleTooFar},
+		{"poll", Outgoing{Type: TypePoll, Data: "lunch?", Poll: poll}, soon, nil},
+		{"invalid poll", Outgoing{Type: TypePoll, Data: "lunch?"}, soon, ErrInvalidPoll},
+		{"poll on text", Outgoing{Type: "text", Data: "hello", Poll: poll}, soon, ErrUnexpectedPoll},
+	}
+
+	for _, test := range tests {
+		if err := checkSchedule(test.outgoing, test.sendAt); !errors.Is(err, test.err) {
+			t.Errorf("%s: checkSchedule = %v, expected %v", test.name, err, test.err)
+		}
+	}
+}
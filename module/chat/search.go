@@ -0,0 +1,330 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/db/mongo"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	snippetLength = 160
+	snippetBefore = 40
+
+	// Conversations searched at the same time, all of them share the deadline of the search.
+	searchWorkers = 8
+)
+
+var (
+	ErrSearchBlank = errors.New("search has no terms")
+)
+
+type MessageSearch struct {
+	Query  string `json:"query,omitempty"`
+	Phrase string `json:"phrase,omitempty"`
+
+	// Optional filters, Contact limits the search to a single conversation.
+	Contact string     `json:"contact,omitempty"`
+	From    string     `json:"from,omitempty"`
+	Types   []string   `json:"types,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// Rune offsets of a match inside a snippet.
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Previous and Next are the IDs of the messages around the result in its conversation.
+type SearchResult struct {
+	UserID  string  `json:"userID"`
+	Message Message `json:"message"`
+	Score   float64 `json:"score"`
+
+	Snippet    string      `json:"snippet"`
+	Highlights []Highlight `json:"highlights"`
+
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+// Builds the $text search string, quoting the phrase so it must match exactly.
+func (search MessageSearch) text() string {
+	text := strings.TrimSpace(search.Query)
+
+	phrase := strings.TrimSpace(strings.ReplaceAll(search.Phrase, "\"", " "))
+	if phrase != "" {
+		text += " \"" + phrase + "\""
+	}
+
+	return strings.TrimSpace(text)
+}
+
+func (search MessageSearch) filter() bson.D {
+	filter := bson.D{
+		{Key: "$text", Value: bson.D{
+			{Key: "$search", Value: search.text()},
+		}},
+	}
+
+	if search.From != "" {
+		filter = append(filter, bson.E{Key: "from", Value: search.From})
+	}
+
+	if len(search.Types) != 0 {
+		filter = append(filter, bson.E{Key: "type", Value: bson.D{
+			{Key: "$in", Value: search.Types},
+		}})
+	}
+
+	if search.Since != nil || search.Until != nil {
+		period := bson.D{}
+		if search.Since != nil {
+			period = append(period, bson.E{Key: "$gte", Value: *search.Since})
+		}
+		if search.Until != nil {
+			period = append(period, bson.E{Key: "$lte", Value: *search.Until})
+		}
+		filter = append(filter, bson.E{Key: "time", Value: period})
+	}
+
+	return filter
+}
+
+// Returns a window of data around the first match of the terms with every match highlighted.
+func Snippet(data string, terms []string) (string, []Highlight) {
+	text := []rune(data)
+
+	lower := make([]rune, len(text))
+	for index, letter := range text {
+		lower[index] = unicode.ToLower(letter)
+	}
+
+	var matches []Highlight
+
+	for _, term := range terms {
+		needle := []rune(strings.ToLower(term))
+		if len(needle) == 0 {
+			continue
+		}
+
+		for index := 0; index+len(needle) <= len(lower); index++ {
+			if string(lower[index:index+len(needle)]) == string(needle) {
+				matches = append(matches, Highlight{Start: index, End: index + len(needle)})
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Start < matches[j].Start })
+
+	start := 0
+	if len(matches) != 0 && matches[0].Start > snippetBefore {
+		start = matches[0].Start - snippetBefore
+	}
+
+	end := start + snippetLength
+	if end > len(text) {
+		end = len(text)
+	}
+
+	highlights := []Highlight{}
+
+	for _, match := range matches {
+		if match.Start < start || match.End > end {
+			continue
+		}
+
+		match.Start -= start
+		match.End -= start
+
+		// Overlapping matches are merged into the previous highlight.
+		last := len(highlights) - 1
+		if last >= 0 && match.Start <= highlights[last].End {
+			if match.End > highlights[last].End {
+				highlights[last].End = match.End
+			}
+			continue
+		}
+
+		highlights = append(highlights, match)
+	}
+
+	return string(text[start:end]), highlights
+}
+
+// Returns the terms of the search used for highlighting.
+func (search MessageSearch) terms() []string {
+	terms := strings.Fields(strings.ReplaceAll(search.Query, "\"", " "))
+
+	phrase := strings.TrimSpace(strings.ReplaceAll(search.Phrase, "\"", " "))
+	if phrase != "" {
+		terms = append(terms, phrase)
+	}
+
+	return terms
+}
+
+// Returns the IDs of the messages either side of message in its timeline.
+func (chat Chat) neighbours(collection *mongodb.Collection, message Message) (string, string, error) {
+	filter := bson.D{
+		{Key: "thread", Value: bson.D{
+			{Key: "$exists", Value: false},
+		}},
+	}
+	if message.Thread != "" {
+		filter = bson.D{
+			{Key: "thread", Value: message.Thread},
+		}
+	}
+
+	cursor := messageCursor(message)
+
+	var previous, next string
+
+	older, _, err := chat.fetch(collection, filter, &cursor, true, 1)
+	if err != nil {
+		return "", "", err
+	}
+	if len(older) != 0 {
+		previous = older[0].ID
+	}
+
+	newer, _, err := chat.fetch(collection, filter, &cursor, false, 1)
+	if err != nil {
+		return "", "", err
+	}
+	if len(newer) != 0 {
+		next = newer[0].ID
+	}
+
+	return previous, next, nil
+}
+
+// Searches the messages of the conversations of userID, best matches first. The whole
+// search runs within one deadline however many conversations it covers.
+func (chat Chat) SearchMessages(userID string, search MessageSearch) ([]SearchResult, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	if search.text() == "" {
+		return nil, ErrSearchBlank
+	}
+
+	var contacts []User
+
+	if search.Contact != "" {
+		contact, err := chat.contact(userID, search.Contact)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	} else {
+		var err error
+		contacts, err = chat.Contacts(userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type Scored struct {
+		Message `bson:",inline"`
+		Score   float64 `bson:"score"`
+	}
+
+	options := options.Find().SetProjection(bson.D{
+		{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}},
+	}).SetLimit(MaxResults)
+	options.Sort = bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}
+
+	filter := search.filter()
+	terms := search.terms()
+
+	collections := make(map[string]*mongodb.Collection)
+	for _, contact := range contacts {
+		collections[contact.UserID] = chat.Database(mongo.Chat).Collection(contact.Store)
+	}
+
+	found := make([][]SearchResult, len(contacts))
+	failed := make([]error, len(contacts))
+
+	// Conversations are searched a few at a time rather than one after another.
+	workers := make(chan struct{}, searchWorkers)
+	wait := &sync.WaitGroup{}
+
+	for index, contact := range contacts {
+		wait.Add(1)
+		workers <- struct{}{}
+
+		go func(index int, contact User) {
+			defer wait.Done()
+			defer func() { <-workers }()
+
+			cursor, err := collections[contact.UserID].Find(context, filter, options)
+			if err != nil {
+				failed[index] = err
+				return
+			}
+
+			var scored []Scored
+
+			err = cursor.All(context, &scored)
+			if err != nil {
+				failed[index] = err
+				return
+			}
+
+			for _, value := range scored {
+				snippet, highlights := Snippet(value.Data, terms)
+
+				value.Message.ReactionCounts = CountReactions(value.Message.Reactions, userID)
+				tallyMessage(&value.Message, userID)
+
+				found[index] = append(found[index], SearchResult{
+					UserID:     contact.UserID,
+					Message:    value.Message,
+					Score:      value.Score,
+					Snippet:    snippet,
+					Highlights: highlights,
+				})
+			}
+		}(index, contact)
+	}
+
+	wait.Wait()
+
+	results := []SearchResult{}
+
+	for index := range contacts {
+		if failed[index] != nil {
+			return nil, failed[index]
+		}
+		results = append(results, found[index]...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+
+	if len(results) > MaxResults {
+		results = results[:MaxResults]
+	}
+
+	for index, result := range results {
+		previous, next, err := chat.neighbours(collections[result.UserID], result.Message)
+		if err != nil {
+			return nil, err
+		}
+
+		results[index].Previous = previous
+		results[index].Next = next
+	}
+
+	return results, nil
+}
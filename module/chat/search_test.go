@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("a", 100) + " needle " + strings.Repeat("b", 200)
+
+	tests := []struct {
+		name       string
+		data       string
+		terms      []string
+		snippet    string
+		highlights []Highlight
+	}{
+		{
+			name:       "no match",
+			data:       "hello world",
+			terms:      []string{"moon"},
+			snippet:    "hello world",
+			highlights: []Highlight{},
+		},
+		{
+			name:       "case insensitive",
+			data:       "Hello World",
+			terms:      []string{"world"},
+			snippet:    "Hello World",
+			highlights: []Highlight{{Start: 6, End: 11}},
+		},
+		{
+			name:       "every match in order",
+			data:       "cat dog cat",
+			terms:      []string{"dog", "cat"},
+			snippet:    "cat dog cat",
+			highlights: []Highlight{{Start: 0, End: 3}, {Start: 4, End: 7}, {Start: 8, End: 11}},
+		},
+		{
+			name:       "overlapping matches merged",
+			data:       "football",
+			terms:      []string{"foot", "tball"},
+			snippet:    "football",
+			highlights: []Highlight{{Start: 0, End: 8}},
+		},
+		{
+			name:       "positions count characters",
+			data:       "größe straße",
+			terms:      []string{"straße"},
+			snippet:    "größe straße",
+			highlights: []Highlight{{Start: 6, End: 12}},
+		},
+		{
+			name:       "empty terms ignored",
+			data:       "hello",
+			terms:      []string{""},
+			snippet:    "hello",
+			highlights: []Highlight{},
+		},
+		{
+			name:       "window around the first match",
+			data:       long,
+			terms:      []string{"needle"},
+			snippet:    string([]rune(long)[61 : 61+snippetLength]),
+			highlights: []Highlight{{Start: snippetBefore, End: snippetBefore + 6}},
+		},
+	}
+
+	for _, test := range tests {
+		snippet, highlights := Snippet(test.data, test.terms)
+		if snippet != test.snippet {
+			t.Errorf("%s: snippet %q, expected %q", test.name, snippet, test.snippet)
+		}
+		if !reflect.DeepEqual(highlights, test.highlights) {
+			t.Errorf("%s: highlights %+v, expected %+v", test.name, highlights, test.highlights)
+		}
+	}
+}
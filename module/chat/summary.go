@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"fmt"
+	"kevlar/module/db/mongo"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	TypeText = "text"
+)
+
+// Latest message of a conversation as shown in the contact list.
+type LastMessage struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Preview string    `json:"preview"`
+}
+
+// Returns a short text describing the message for contact lists and notifications.
+func Preview(message Message) string {
+	switch message.Type {
+	case TypeAttachment:
+		if len(message.Attachments) == 0 {
+			return "📎 Attachment"
+		}
+
+		preview := "📎 " + message.Attachments[0].Name
+		if len(message.Attachments) > 1 {
+			preview += fmt.Sprintf(" +%d", len(message.Attachments)-1)
+		}
+		if message.Data != "" {
+			preview += " " + message.Data
+		}
+		return preview
+
+	case TypeCall:
+		return callPreview(message)
+
+	case TypePoll:
+		return pollPreview(message)
+
+	case TypeVoice:
+		return voicePreview(message)
+
+	case TypeText, "":
+		return message.Data
+	}
+
+	// Types from older clients keep a fileID as data.
+	return "📎 " + message.Type
+}
+
+// State of a conversation as shown in the contact list.
+type Summary struct {
+	Unread      int          `json:"unread"`
+	Pinned      int          `json:"pinned"`
+	LastMessage *LastMessage `json:"last_message,omitempty"`
+}
+
+// Counts the unread messages from the contact and the pinned messages, and finds
+// the latest message of the conversation, each with a query on an index.
+func (chat Chat) summary(contact User) (Summary, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	var summary Summary
+
+	unread, err := collection.CountDocuments(context, bson.D{
+		{Key: "from", Value: contact.UserID},
+		{Key: "read", Value: false},
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+	summary.Unread = int(unread)
+
+	pinned, err := collection.CountDocuments(context, bson.D{
+		{Key: "pinned_at", Value: bson.D{{Key: "$exists", Value: true}}},
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+	summary.Pinned = int(pinned)
+
+	options := options.FindOne().SetSort(bson.D{
+		{Key: "time", Value: -1},
+		{Key: "id", Value: -1},
+	})
+
+	var last Message
+
+	err = collection.FindOne(context, bson.D{}, options).Decode(&last)
+	if err == mongodb.ErrNoDocuments {
+		return summary, nil
+	}
+	if err != nil {
+		return Summary{}, err
+	}
+
+	summary.LastMessage = &LastMessage{
+		ID:      last.ID,
+		From:    last.From,
+		Type:    last.Type,
+		Time:    last.Time,
+		Preview: Preview(last),
+	}
+
+	return summary, nil
+}
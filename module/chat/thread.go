@@ -0,0 +1,172 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/db/mongo"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ThreadReplyIncoming = "thread_reply_incoming"
+)
+
+var (
+	ErrMessageDoesNotExist = errors.New("message does not exist in conversation")
+	ErrNestedThread        = errors.New("cannot start a thread on a thread reply")
+)
+
+// Quoted message shown with a reply, Deleted is set when the original is gone.
+type Quote struct {
+	ID   string `json:"id"`
+	From string `json:"from,omitempty"`
+	Type string `json:"type,omitempty"`
+	Data string `json:"data,omitempty"`
+
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+func (chat Chat) findMessage(collection *mongodb.Collection, messageID string) (Message, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	var message Message
+
+	err := collection.FindOne(context, bson.D{
+		{Key: "id", Value: messageID},
+	}).Decode(&message)
+	if err == mongodb.ErrNoDocuments {
+		return Message{}, ErrMessageDoesNotExist
+	}
+
+	return message, err
+}
+
+// Increments the reply count on the thread root of the reply.
+func (chat Chat) countReply(collection *mongodb.Collection, reply Message) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	_, err := collection.UpdateOne(context, bson.D{
+		{Key: "id", Value: reply.Thread},
+	}, bson.D{
+		{Key: "$inc", Value: bson.D{
+			{Key: "reply_count", Value: 1},
+		}},
+		{Key: "$max", Value: bson.D{
+			{Key: "last_reply", Value: reply.Time},
+		}},
+	})
+
+	return err
+}
+
+// Resolves the quotes of replies with one query, keeping a placeholder for quoted messages
+// that were deleted.
+func (chat Chat) resolveQuotes(collection *mongodb.Collection, messages []Message) error {
+	ids := []string{}
+	for _, message := range messages {
+		if message.ReplyTo != "" && !has(ids, message.ReplyTo) {
+			ids = append(ids, message.ReplyTo)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	options := options.Find().SetProjection(bson.D{
+		{Key: "id", Value: 1},
+		{Key: "from", Value: 1},
+		{Key: "type", Value: 1},
+		{Key: "data", Value: 1},
+	})
+
+	cursor, err := collection.Find(context, bson.D{
+		{Key: "id", Value: bson.D{{Key: "$in", Value: ids}}},
+	}, options)
+	if err != nil {
+		return err
+	}
+
+	var found []Message
+
+	err = cursor.All(context, &found)
+	if err != nil {
+		return err
+	}
+
+	quoted := make(map[string]Message, len(found))
+	for _, message := range found {
+		quoted[message.ID] = message
+	}
+
+	for index := range messages {
+		messages[index].Quote = quote(messages[index].ReplyTo, quoted)
+	}
+
+	return nil
+}
+
+// Returns the quote of a reply to id from the loaded messages, nil if the message is no reply.
+func quote(id string, quoted map[string]Message) *Quote {
+	if id == "" {
+		return nil
+	}
+
+	message, ok := quoted[id]
+	if !ok {
+		return &Quote{
+			ID:      id,
+			Deleted: true,
+		}
+	}
+
+	return &Quote{
+		ID:   message.ID,
+		From: message.From,
+		Type: message.Type,
+		Data: message.Data,
+	}
+}
+
+// Loads the root message of a thread and a page of its replies.
+func (chat Chat) LoadThread(from, to, threadID string, query PageQuery) (Message, Page, error) {
+
+	contact, err := chat.conversation(from, to)
+	if err != nil {
+		return Message{}, Page{}, err
+	}
+
+	collection := chat.Database(mongo.Chat).Collection(contact.Store)
+
+	root, err := chat.findMessage(collection, threadID)
+	if err != nil {
+		return Message{}, Page{}, err
+	}
+
+	roots := []Message{root}
+
+	err = chat.resolveQuotes(collection, roots)
+	if err != nil {
+		return Message{}, Page{}, err
+	}
+	root = roots[0]
+
+	root.ReactionCounts = CountReactions(root.Reactions, from)
+	tallyMessage(&root, from)
+
+	page, err := chat.page(collection, bson.D{
+		{Key: "thread", Value: threadID},
+	}, query, from)
+	if err != nil {
+		return Message{}, Page{}, err
+	}
+
+	return root, page, nil
+}
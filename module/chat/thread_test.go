@@ -0,0 +1,23 @@
+package chat
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	quoted := map[string]Message{
+		"original": {ID: "original", From: "alice", Type: "text", Data: "hello"},
+	}
+
+	if quote("", quoted) != nil {
+		t.Fatal("quote set on a message that is no reply")
+	}
+
+	found := quote("original", quoted)
+	if found == nil || found.Deleted || found.From != "alice" || found.Type != "text" || found.Data != "hello" {
+		t.Fatalf("unexpected quote %+v", found)
+	}
+
+	deleted := quote("removed", quoted)
+	if deleted == nil || !deleted.Deleted || deleted.ID != "removed" || deleted.Data != "" {
+		t.Fatalf("expected a placeholder for a deleted message, got %+v", deleted)
+	}
+}
@@ -0,0 +1,266 @@
+package chat
+
+import (
+	"errors"
+	"kevlar/module/db/mongo"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	TimerUpdate = "disappearing_timer_update"
+)
+
+var (
+	ErrInvalidTimer = errors.New("unsupported disappearing message timer")
+
+	// Allowed disappearing message timers in seconds, zero turns the timer off.
+	Timers = []int64{0, 60 * 60, 24 * 60 * 60, 7 * 24 * 60 * 60}
+)
+
+// Settings shared by both parties of a conversation.
+type Conversation struct {
+	Store     string    `bson:"store" json:"-"`
+	Timer     int64     `bson:"timer" json:"timer"`
+	UpdatedBy string    `bson:"updated_by" json:"updated_by"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// File attached to a disappearing message, removed from the store of Owner once it expires.
+type ExpiringFile struct {
+	Owner     string    `bson:"owner"`
+	FileID    string    `bson:"fileID"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+func (chat Chat) conversationSettings(store string) (Conversation, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Conversations)
+
+	var conversation Conversation
+
+	err := collection.FindOne(context, bson.D{
+		{Key: "store", Value: store},
+	}).Decode(&conversation)
+	if err == mongodb.ErrNoDocuments {
+		return Conversation{Store: store}, nil
+	}
+
+	return conversation, err
+}
+
+// Drops a conversation collection along with its settings.
+func (chat Chat) dropConversation(store string) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	err := chat.Database(mongo.Chat).Collection(store).Drop(context)
+	if err != nil {
+		return err
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Conversations)
+
+	_, err = collection.DeleteOne(context, bson.D{
+		{Key: "store", Value: store},
+	})
+
+	return err
+}
+
+// Returns the disappearing message timer of the conversation between from and to.
+func (chat Chat) GetTimer(from, to string) (Conversation, error) {
+	contact, err := chat.conversation(from, to)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return chat.conversationSettings(contact.Store)
+}
+
+// Sets the disappearing message timer of the conversation between from and to, in seconds.
+func (chat Chat) SetTimer(from, to string, timer int64) (Conversation, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	valid := false
+	for _, value := range Timers {
+		if value == timer {
+			valid = true
+		}
+	}
+	if !valid {
+		return Conversation{}, ErrInvalidTimer
+	}
+
+	contact, err := chat.contact(from, to)
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	conversation := Conversation{
+		Store:     contact.Store,
+		Timer:     timer,
+		UpdatedBy: from,
+		UpdatedAt: time.Now(),
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.Conversations)
+
+	_, err = collection.ReplaceOne(context, bson.D{
+		{Key: "store", Value: contact.Store},
+	}, conversation, options.Replace().SetUpsert(true))
+	if err != nil {
+		return Conversation{}, err
+	}
+
+	return conversation, nil
+}
+
+// Stamps the expiry of a message from the timer of its conversation and
+// records its attachments for removal once it expires.
+func (chat Chat) expire(store string, message *Message) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	conversation, err := chat.conversationSettings(store)
+	if err != nil {
+		return err
+	}
+
+	if conversation.Timer == 0 {
+		return nil
+	}
+
+	expiry := message.Time.Add(time.Duration(conversation.Timer) * time.Second)
+	message.ExpiresAt = &expiry
+
+	if len(message.Attachments) == 0 {
+		return nil
+	}
+
+	var files []interface{}
+
+	for _, attachment := range message.Attachments {
+		files = append(files, ExpiringFile{
+			Owner:     message.From,
+			FileID:    attachment.FileID,
+			ExpiresAt: expiry,
+		})
+
+		if attachment.Thumbnail != "" {
+			files = append(files, ExpiringFile{
+				Owner:     message.From,
+				FileID:    attachment.Thumbnail,
+				ExpiresAt: expiry,
+			})
+		}
+	}
+
+	collection := chat.Database(mongo.Users).Collection(mongo.ExpiringFiles)
+
+	_, err = collection.InsertMany(context, files)
+
+	return err
+}
+
+// Removes the file of owner from the store once expiresAt has passed.
+func (chat Chat) ExpireFile(owner, fileID string, expiresAt time.Time) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.ExpiringFiles)
+
+	_, err := collection.InsertOne(context, ExpiringFile{
+		Owner:     owner,
+		FileID:    fileID,
+		ExpiresAt: expiresAt,
+	})
+
+	return err
+}
+
+// Returns the files of expired messages that are still in the store.
+func (chat Chat) ExpiredFiles() ([]ExpiringFile, error) {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.ExpiringFiles)
+
+	cursor, err := collection.Find(context, bson.D{
+		{Key: "expiresAt", Value: bson.D{
+			{Key: "$lte", Value: time.Now()},
+		}},
+	}, options.Find().SetLimit(MaxResults))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []ExpiringFile{}
+
+	err = cursor.All(context, &files)
+
+	return files, err
+}
+
+// Reports whether the file of owner is still attached to a message that has not expired,
+// in any conversation of owner. Such a file stays in the store.
+func (chat Chat) FileAttached(owner, fileID string) (bool, error) {
+	relationships, err := chat.relationships(owner, bson.D{
+		{Key: "store", Value: bson.D{{Key: "$exists", Value: true}}},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{
+		{Key: "$and", Value: bson.A{
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "attachments.fileID", Value: fileID}},
+				bson.D{{Key: "attachments.thumbnail", Value: fileID}},
+			}}},
+			bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: "expiresAt", Value: bson.D{{Key: "$exists", Value: false}}}},
+				bson.D{{Key: "expiresAt", Value: bson.D{{Key: "$gt", Value: time.Now()}}}},
+			}}},
+		}},
+	}
+
+	for _, relationship := range relationships {
+		attached, err := func() (bool, error) {
+			context, cancel := chat.DefaultContext()
+			defer cancel()
+
+			count, err := chat.Database(mongo.Chat).Collection(relationship.Store).CountDocuments(context, filter,
+				options.Count().SetLimit(1))
+
+			return count != 0, err
+		}()
+		if err != nil || attached {
+			return attached, err
+		}
+	}
+
+	return false, nil
+}
+
+func (chat Chat) RemoveExpiredFile(expired ExpiringFile) error {
+	context, cancel := chat.DefaultContext()
+	defer cancel()
+
+	collection := chat.Database(mongo.Users).Collection(mongo.ExpiringFiles)
+
+	// Files attached to several messages have an entry per message, only this one is removed.
+	_, err := collection.DeleteOne(context, bson.D{
+		{Key: "owner", Value: expired.Owner},
+		{Key: "fileID", Value: expired.FileID},
+		{Key: "expiresAt", Value: expired.ExpiresAt},
+	})
+
+	return err
+}
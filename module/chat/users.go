@@ -6,7 +6,7 @@ import (
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -18,22 +18,7 @@ var (
 
 func (chat Chat) CreateUser(userID string) error {
 
-	err := chat.SetAttribute(userID, ContactList, []User{})
-	if err != nil {
-		return err
-	}
-
-	err = chat.SetAttribute(userID, IncomingList, []User{})
-	if err != nil {
-		return err
-	}
-
-	err = chat.SetAttribute(userID, OutgoingList, []User{})
-	if err != nil {
-		return err
-	}
-
-	err = chat.SetAttribute(userID, About, "Hello! I am a new user on kevlar!")
+	err := chat.SetAttribute(userID, About, "Hello! I am a new user on kevlar!")
 	if err != nil {
 		return err
 	}
@@ -43,185 +28,95 @@ func (chat Chat) CreateUser(userID string) error {
 	return err
 }
 
-func (chat Chat) Search(from, query string, last string) ([]User, string, error) {
-	context, cancel := chat.DefaultContext()
-	defer cancel()
-
-	if query == "*" {
-		return nil, "", ErrInvalidQuery
-	}
-
-	collection := chat.Database(mongo.Users).Collection(mongo.Accounts)
-
-	options := options.Find().SetProjection(bson.D{
-		{Key: "userID", Value: 1},
-		{Key: "_id", Value: 1},
-	}).SetLimit(MaxResults)
-	options.Sort = bson.D{{Key: "_id", Value: -1}}
-
-	var sort bson.D
-
-	last_id, err := primitive.ObjectIDFromHex(last)
-	if err != nil {
-		last_id = primitive.NilObjectID
-	}
-
-	if last_id == primitive.NilObjectID {
-		sort = bson.D{
-			{Key: "userID", Value: primitive.Regex{
-				Pattern: "^" + query,
-				Options: "i",
-			}},
-		}
-	} else {
-		sort = bson.D{
-			{Key: "userID", Value: primitive.Regex{
-				Pattern: "^" + query,
-				Options: "i",
-			}},
-			{Key: "_id", Value: bson.D{
-				{Key: "$lt", Value: last_id},
-			}},
-		}
-	}
-
-	cursor, err := collection.Find(context, sort, options)
-	if err != nil {
-		return nil, "", err
-	}
-
-	var results []struct {
-		ID     primitive.ObjectID `bson:"_id"`
-		UserID string             `bson:"userID"`
-	}
+func (chat Chat) DeleteUser(userID string) error {
 
-	err = cursor.All(context, &results)
+	err := chat.DeleteEvents(userID)
 	if err != nil {
-		return nil, "", err
+		return err
 	}
 
-	var new_last primitive.ObjectID
-
-	if len(results) != 0 {
-		new_last = results[len(results)-1].ID
-	}
+	context, cancel := chat.DefaultContext()
+	defer cancel()
 
-	var contacts []User
-	err = chat.GetAttribute(from, ContactList, &contacts)
+	_, err = chat.Database(mongo.Users).Collection(mongo.Scheduled).DeleteMany(context, bson.D{
+		{Key: "from", Value: userID},
+	})
 	if err != nil {
-		return nil, "", err
+		return err
 	}
 
-	var incoming []User
-	err = chat.GetAttribute(from, IncomingList, &incoming)
+	_, err = chat.Database(mongo.Users).Collection(mongo.Stars).DeleteMany(context, bson.D{
+		{Key: "userID", Value: userID},
+	})
 	if err != nil {
-		return nil, "", err
+		return err
 	}
 
-	var outgoing []User
-	err = chat.GetAttribute(from, OutgoingList, &outgoing)
+	session, err := chat.StartSession()
 	if err != nil {
-		return nil, "", err
+		return err
 	}
+	defer session.EndSession(context)
 
-	check_accounts := append(contacts, incoming...)
-	check_accounts = append(check_accounts, outgoing...)
+	var dropped []string
 
-	var accounts []User
+	// Kept conversations stay readable for the other side, every other relationship is removed
+	// and its conversation dropped once the transaction committed.
+	_, err = session.WithTransaction(context, func(context mongodb.SessionContext) (interface{}, error) {
+		dropped = nil
 
-	for _, user := range results {
-
-		var found bool
-
-		for _, value := range check_accounts {
-			if value.UserID == user.UserID || value.UserID == from {
-				found = true
-			}
-		}
-
-		if found || user.UserID == from {
-			continue
-		}
-
-		user, err := chat.GetInformation(user.UserID)
+		relationships, err := chat.findRelationships(context, userID, bson.D{})
 		if err != nil {
-			return nil, "", err
+			return nil, err
 		}
 
-		accounts = append(accounts, user)
-	}
+		collection := chat.Database(mongo.Users).Collection(mongo.Relationships)
 
-	if len(results) < MaxResults {
-		return accounts, primitive.NilObjectID.Hex(), nil
-	}
-
-	return accounts, new_last.Hex(), nil
-}
-
-func (chat Chat) DeleteUser(userID string) error {
-	context, cancel := chat.DefaultContext()
-	defer cancel()
-
-	remove_user := func(key string) error {
-
-		var list []User
-		err := chat.GetAttribute(userID, key, &list)
-		if err != nil {
-			return err
-		}
-
-		for _, value := range list {
-			var list []User
-			err := chat.GetAttribute(value.UserID, key, &list)
-			if err != nil {
-				return err
+		for _, relationship := range relationships {
+			filter := bson.D{
+				{Key: "pair", Value: relationship.Pair},
 			}
 
-			for index, value := range list {
-				if value.UserID == userID {
-					list = append(list[:index], list[index+1:]...)
-					break
+			if !relationship.Connected && has(relationship.Kept, relationship.Other(userID)) {
+				_, err = collection.UpdateOne(context, filter, bson.D{
+					{Key: "$set", Value: bson.D{
+						{Key: "state", Value: RelationNone},
+						{Key: "kept", Value: []string{relationship.Other(userID)}},
+						{Key: "updated_at", Value: time.Now()},
+					}},
+					{Key: "$unset", Value: bson.D{
+						{Key: "requested_by", Value: ""},
+						{Key: "blocked_by", Value: ""},
+					}},
+				})
+			} else {
+				_, err = collection.DeleteOne(context, filter)
+
+				if relationship.Store != "" {
+					dropped = append(dropped, relationship.Store)
 				}
 			}
-
-			err = chat.SetAttribute(value.UserID, key, list)
 			if err != nil {
-				return err
+				return nil, err
 			}
 		}
-		return nil
-	}
-
-	err := remove_user(ContactList)
-	if err != nil {
-		return err
-	}
 
-	var contacts []User
-
-	err = chat.GetAttribute(userID, ContactList, &contacts)
+		return nil, nil
+	})
 	if err != nil {
 		return err
 	}
 
-	for _, value := range contacts {
-
-		collection := chat.Database(mongo.Chat).Collection(value.Store)
-
-		err = collection.Drop(context)
+	for _, store := range dropped {
+		err = chat.dropConversation(store)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = remove_user(OutgoingList)
-	if err != nil {
-		return err
-	}
-	err = remove_user(IncomingList)
-
-	return err
+	return nil
 }
+
 func (chat Chat) GetInformation(userID string) (User, error) {
 
 	context, cancel := chat.DefaultContext()
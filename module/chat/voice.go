@@ -0,0 +1,32 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	TypeVoice = "voice"
+)
+
+var (
+	ErrInvalidVoice = errors.New("voice message needs exactly one readable audio file")
+)
+
+// Voice messages carry one audio file the server read when it was uploaded, and may have a caption.
+func checkVoice(message Message) error {
+	if len(message.Attachments) != 1 || message.Attachments[0].Audio == nil {
+		return ErrInvalidVoice
+	}
+	return nil
+}
+
+func voicePreview(message Message) string {
+	if len(message.Attachments) == 0 || message.Attachments[0].Audio == nil {
+		return "🎤 Voice message"
+	}
+
+	seconds := int(math.Round(message.Attachments[0].Audio.Duration))
+	return fmt.Sprintf("🎤 Voice message (%d:%02d)", seconds/60, seconds%60)
+}
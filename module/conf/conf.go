@@ -5,11 +5,18 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"kevlar/module/attr"
+	"kevlar/module/audio"
 	"kevlar/module/auth"
+	"kevlar/module/call"
 	"kevlar/module/db/minio"
 	"kevlar/module/db/mongo"
+	"kevlar/module/export"
+	"kevlar/module/limit"
 	"kevlar/module/log"
+	"kevlar/module/moderation"
+	"kevlar/module/push"
 	"kevlar/module/store"
+	"kevlar/module/webhook"
 	"os"
 
 	"github.com/creasty/defaults"
@@ -30,13 +37,20 @@ type Http struct {
 }
 
 type RootConfig struct {
-	Mongo mongo.Config
-	Log   log.Config
-	Auth  auth.Config
-	Http  Http
-	Minio minio.Config
-	Attr  attr.Config
-	Store store.Config
+	Mongo      mongo.Config
+	Log        log.Config
+	Auth       auth.Config
+	Http       Http
+	Minio      minio.Config
+	Attr       attr.Config
+	Store      store.Config
+	Push       push.Config
+	Call       call.Config
+	Webhook    webhook.Config
+	Moderation moderation.Config
+	Limit      limit.Config
+	Audio      audio.Config
+	Export     export.Config
 }
 
 const (
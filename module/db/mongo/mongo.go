@@ -23,11 +23,27 @@ type Config struct {
 }
 
 var (
-	Users      = "user"
-	Chat       = "chat"
-	Accounts   = "accounts"
-	Relogin    = "relogin"
-	Attributes = "attr"
+	Users          = "user"
+	Chat           = "chat"
+	Accounts       = "accounts"
+	Relogin        = "relogin"
+	Attributes     = "attr"
+	Conversations  = "conversations"
+	ExpiringFiles  = "expiring_files"
+	Scheduled      = "scheduled"
+	Stars          = "stars"
+	Relationships  = "relationships"
+	Keys           = "keys"
+	Events         = "events"
+	EventSequences = "event_sequences"
+
+	Webhooks          = "webhooks"
+	WebhookDeliveries = "webhook_deliveries"
+	ModerationQueue   = "moderation_queue"
+	Reports           = "reports"
+	AuditLog          = "audit_log"
+	RateLimits        = "rate_limits"
+	Exports           = "exports"
 )
 
 func New(config Config) MongoClient {
@@ -39,6 +55,65 @@ func (db MongoClient) DefaultContext() (context.Context, context.CancelFunc) {
 		time.Duration(db.config.RequestTimeoutDuration)*time.Second)
 }
 
+// Creates the indexes of a conversation collection in the chat database.
+func (db *MongoClient) InitializeConversation(name string) error {
+	context, cancel := db.DefaultContext()
+	defer cancel()
+
+	collection := db.Database(Chat).Collection(name)
+
+	_, err := collection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "time", Value: -1}, {Key: "id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "type", Value: 1}, {Key: "time", Value: -1}, {Key: "id", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "from", Value: 1}, {Key: "read", Value: 1}, {Key: "time", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+		{
+			// Messages in any language are searched, so no stemming or stop words.
+			Keys:    bson.D{{Key: "data", Value: "text"}},
+			Options: options.Index().SetDefaultLanguage("none"),
+		},
+		{
+			Keys: bson.D{{Key: "thread", Value: 1}, {Key: "time", Value: -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.D{
+				{Key: "thread", Value: bson.D{{Key: "$exists", Value: true}}},
+			}),
+		},
+		{
+			Keys: bson.D{{Key: "attachments.fileID", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.D{
+				{Key: "attachments", Value: bson.D{{Key: "$exists", Value: true}}},
+			}),
+		},
+		{
+			Keys: bson.D{{Key: "attachments.thumbnail", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.D{
+				{Key: "attachments", Value: bson.D{{Key: "$exists", Value: true}}},
+			}),
+		},
+		{
+			Keys: bson.D{{Key: "pinned_at", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.D{
+				{Key: "pinned_at", Value: bson.D{{Key: "$exists", Value: true}}},
+			}),
+		},
+	})
+
+	return err
+}
+
 func (db *MongoClient) InitializeDatabase() error {
 	context, cancel := db.DefaultContext()
 	defer cancel()
@@ -63,7 +138,20 @@ func (db *MongoClient) InitializeDatabase() error {
 	reloginCollection := db.Database(Users).Collection(Relogin)
 	attributesCollection := db.Database(Users).Collection(Attributes)
 
-	_, err := accountsCollection.Indexes().CreateOne(context, uniqueUserID)
+	// Directory search compares case insensitively, which needs indexes with the same collation.
+	directoryCollation := &options.Collation{Locale: "en", Strength: 2}
+
+	_, err := accountsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueUserID,
+		{
+			Keys:    bson.D{{Key: "userID", Value: 1}},
+			Options: options.Index().SetName("userID_directory").SetCollation(directoryCollation),
+		},
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}, {Key: "_id", Value: 1}},
+			Options: options.Index().SetName("username_directory").SetCollation(directoryCollation),
+		},
+	})
 	if err != nil {
 		return err
 	}
@@ -76,6 +164,188 @@ func (db *MongoClient) InitializeDatabase() error {
 		return err
 	}
 
+	conversationsCollection := db.Database(Users).Collection(Conversations)
+	expiringCollection := db.Database(Users).Collection(ExpiringFiles)
+
+	_, err = conversationsCollection.Indexes().CreateOne(context, uniqueFeild("store"))
+	if err != nil {
+		return err
+	}
+	_, err = expiringCollection.Indexes().CreateOne(context, mongo.IndexModel{
+		Keys: bson.M{
+			"expiresAt": 1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	starsCollection := db.Database(Users).Collection(Stars)
+
+	_, err = starsCollection.Indexes().CreateOne(context, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	eventsCollection := db.Database(Users).Collection(Events)
+	sequencesCollection := db.Database(Users).Collection(EventSequences)
+
+	_, err = eventsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		expirySet,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = sequencesCollection.Indexes().CreateOne(context, uniqueUserID)
+	if err != nil {
+		return err
+	}
+
+	relationshipsCollection := db.Database(Users).Collection(Relationships)
+
+	_, err = relationshipsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueFeild("pair"),
+		{
+			Keys: bson.D{{Key: "users", Value: 1}, {Key: "state", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	keysCollection := db.Database(Users).Collection(Keys)
+
+	_, err = keysCollection.Indexes().CreateOne(context, uniqueFeild("name"))
+	if err != nil {
+		return err
+	}
+
+	scheduledCollection := db.Database(Users).Collection(Scheduled)
+
+	_, err = scheduledCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueFeild("id"),
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "send_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "from", Value: 1}, {Key: "send_at", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	webhooksCollection := db.Database(Users).Collection(Webhooks)
+	deliveriesCollection := db.Database(Users).Collection(WebhookDeliveries)
+
+	_, err = webhooksCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueFeild("id"),
+		{
+			Keys: bson.D{{Key: "events", Value: 1}, {Key: "owner", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = deliveriesCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueFeild("id"),
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "owner", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	moderationCollection := db.Database(Users).Collection(ModerationQueue)
+
+	_, err = moderationCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueFeild("id"),
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	reportsCollection := db.Database(Users).Collection(Reports)
+	auditCollection := db.Database(Users).Collection(AuditLog)
+
+	_, err = reportsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueFeild("id"),
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "reporter", Value: 1}, {Key: "target", Value: 1}, {Key: "status", Value: 1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = auditCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "target", Value: 1}, {Key: "time", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "time", Value: -1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	rateLimitsCollection := db.Database(Users).Collection(RateLimits)
+
+	_, err = rateLimitsCollection.Indexes().CreateMany(context, []mongo.IndexModel{uniqueFeild("key"), expirySet})
+	if err != nil {
+		return err
+	}
+
+	exportsCollection := db.Database(Users).Collection(Exports)
+
+	_, err = exportsCollection.Indexes().CreateMany(context, []mongo.IndexModel{
+		uniqueFeild("id"),
+		{
+			Keys: bson.D{{Key: "userID", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+		expirySet,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Conversations created before their indexes existed.
+	conversations, err := db.Database(Chat).ListCollectionNames(context, bson.D{})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range conversations {
+		err = db.InitializeConversation(name)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"archive/zip"
+	"io"
+	"kevlar/module/chat"
+)
+
+// Calls each with the messages of a conversation in the order they were sent.
+type Source func(each func(chat.Message) error) error
+
+// Loads the data of a file owned by owner.
+type Fetch func(owner, fileID string) ([]byte, error)
+
+// Name of the exported file, a zip archive when attachments are bundled.
+func FileName(format string, bundled bool) string {
+	if bundled {
+		return "conversation.zip"
+	}
+	return "conversation." + extension(format)
+}
+
+// Writes the conversation from source to out in format. With fetch, the transcript is
+// zipped together with the attachments it references, up to limit bytes of them, and
+// the number of attachments left out is returned.
+func Build(out io.Writer, format string, header Header, source Source, fetch Fetch, limit int64) (int, error) {
+	if fetch == nil {
+		return 0, write(out, format, header, source, false, nil)
+	}
+
+	archive := zip.NewWriter(out)
+
+	entry, err := archive.Create("conversation." + extension(format))
+	if err != nil {
+		return 0, err
+	}
+
+	// Attachments are only fetched once the transcript is written, a file shared in
+	// several messages is bundled once.
+	var attachments []chat.Attachment
+	var owners []string
+	seen := make(map[string]bool)
+
+	err = write(entry, format, header, source, true, func(message chat.Message) {
+		for _, attachment := range message.Attachments {
+			if seen[attachment.FileID] {
+				continue
+			}
+			seen[attachment.FileID] = true
+
+			attachments = append(attachments, attachment)
+			owners = append(owners, message.From)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	skipped := 0
+	size := int64(0)
+
+	for index, attachment := range attachments {
+		if size+int64(attachment.Size) > limit {
+			skipped++
+			continue
+		}
+
+		// Files deleted by their owner since they were sent are left out.
+		data, err := fetch(owners[index], attachment.FileID)
+		if err != nil {
+			skipped++
+			continue
+		}
+		size += int64(len(data))
+
+		entry, err := archive.Create(attachmentPath(attachment))
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = entry.Write(data)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return skipped, archive.Close()
+}
+
+func write(out io.Writer, format string, header Header, source Source, bundled bool, visit func(chat.Message)) error {
+	writer, err := NewWriter(format, out, bundled)
+	if err != nil {
+		return err
+	}
+
+	err = writer.Begin(header)
+	if err != nil {
+		return err
+	}
+
+	err = source(func(message chat.Message) error {
+		if visit != nil {
+			visit(message)
+		}
+		return writer.Message(message)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writer.End()
+}
@@ -0,0 +1,263 @@
+package export
+
+import (
+	"errors"
+	mongodb "kevlar/module/db/mongo"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Formats a conversation can be exported to.
+const (
+	FormatJSON = "json"
+	FormatHTML = "html"
+	FormatText = "text"
+)
+
+// States of an export job.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+
+	// Unfinished exports a user may have at once.
+	MaxActive = 3
+)
+
+// Running jobs not completed within this time are claimed again.
+const claimTimeout = 10 * time.Minute
+
+var (
+	ErrInvalidFormat      = errors.New("export format is not supported")
+	ErrExportDoesNotExist = errors.New("export does not exist")
+	ErrExportInProgress   = errors.New("conversation is already being exported")
+	ErrTooManyExports     = errors.New("too many exports in progress")
+)
+
+type Config struct {
+	// Hours a finished export stays in the store of the user, and its job in the list.
+	ExpiryHours int `default:"24"`
+	// Attachments past this total size are left out of the archive.
+	MaxAttachmentsMB int64 `default:"256"`
+}
+
+type Job struct {
+	ID          string `bson:"id" json:"id"`
+	UserID      string `bson:"userID" json:"-"`
+	Contact     string `bson:"contact" json:"contact"`
+	Format      string `bson:"format" json:"format"`
+	Attachments bool   `bson:"attachments" json:"attachments"`
+
+	Status string `bson:"status" json:"status"`
+	FileID string `bson:"fileID,omitempty" json:"fileID,omitempty"`
+	URL    string `bson:"url,omitempty" json:"url,omitempty"`
+	Error  string `bson:"error,omitempty" json:"error,omitempty"`
+	// Attachments that could not be bundled, because they were deleted or over the size limit.
+	Skipped int `bson:"skipped,omitempty" json:"skipped,omitempty"`
+
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+	ClaimedAt *time.Time `bson:"claimed_at,omitempty" json:"-"`
+	ExpiresAt *time.Time `bson:"expiresAt,omitempty" json:"expires_at,omitempty"`
+}
+
+// Outcome of building an export.
+type Result struct {
+	FileID  string
+	URL     string
+	Skipped int
+}
+
+type Export struct {
+	*mongodb.MongoClient
+	Config
+}
+
+func New(mongo *mongodb.MongoClient, config Config) Export {
+	return Export{
+		MongoClient: mongo,
+		Config:      config,
+	}
+}
+
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatJSON, FormatHTML, FormatText:
+		return true
+	}
+	return false
+}
+
+// Queues an export of the conversation of userID with contact.
+func (export Export) Create(userID, contact, format string, attachments bool) (Job, error) {
+	context, cancel := export.DefaultContext()
+	defer cancel()
+
+	if !ValidFormat(format) {
+		return Job{}, ErrInvalidFormat
+	}
+
+	collection := export.Database(mongodb.Users).Collection(mongodb.Exports)
+
+	active := bson.D{
+		{Key: "userID", Value: userID},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{ExportPending, ExportRunning}}}},
+	}
+
+	cursor, err := collection.Find(context, active)
+	if err != nil {
+		return Job{}, err
+	}
+
+	jobs := []Job{}
+
+	err = cursor.All(context, &jobs)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if len(jobs) >= MaxActive {
+		return Job{}, ErrTooManyExports
+	}
+	for _, job := range jobs {
+		if job.Contact == contact {
+			return Job{}, ErrExportInProgress
+		}
+	}
+
+	job := Job{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Contact:     contact,
+		Format:      format,
+		Attachments: attachments,
+		Status:      ExportPending,
+		CreatedAt:   time.Now(),
+	}
+
+	_, err = collection.InsertOne(context, job)
+	if err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// Returns the exports of userID, newest first. Finished ones are removed once they expire.
+func (export Export) Jobs(userID string) ([]Job, error) {
+	context, cancel := export.DefaultContext()
+	defer cancel()
+
+	cursor, err := export.Database(mongodb.Users).Collection(mongodb.Exports).Find(context, bson.D{
+		{Key: "userID", Value: userID},
+	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := []Job{}
+	err = cursor.All(context, &jobs)
+	return jobs, err
+}
+
+func (export Export) Get(userID, id string) (Job, error) {
+	context, cancel := export.DefaultContext()
+	defer cancel()
+
+	var job Job
+
+	err := export.Database(mongodb.Users).Collection(mongodb.Exports).FindOne(context, bson.D{
+		{Key: "id", Value: id},
+		{Key: "userID", Value: userID},
+	}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return Job{}, ErrExportDoesNotExist
+	}
+
+	return job, err
+}
+
+// Claims the oldest pending export, so that only one server builds it. Returns
+// ErrExportDoesNotExist when there is nothing to export.
+func (export Export) Claim() (Job, error) {
+	context, cancel := export.DefaultContext()
+	defer cancel()
+
+	now := time.Now()
+
+	var job Job
+
+	err := export.Database(mongodb.Users).Collection(mongodb.Exports).FindOneAndUpdate(context, bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "status", Value: ExportPending}},
+			bson.D{
+				{Key: "status", Value: ExportRunning},
+				{Key: "claimed_at", Value: bson.D{{Key: "$lt", Value: now.Add(-claimTimeout)}}},
+			},
+		}},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: ExportRunning},
+			{Key: "claimed_at", Value: now},
+		}},
+	}, options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return Job{}, ErrExportDoesNotExist
+	}
+
+	return job, err
+}
+
+// Records the outcome of a claimed export, the job expires along with the exported file.
+func (export Export) Complete(job Job, result Result, failure error) (Job, error) {
+	context, cancel := export.DefaultContext()
+	defer cancel()
+
+	expires := time.Now().Add(time.Duration(export.ExpiryHours) * time.Hour)
+
+	job.ExpiresAt = &expires
+
+	if failure != nil {
+		job.Status = ExportFailed
+		job.Error = failure.Error()
+	} else {
+		job.Status = ExportReady
+		job.FileID = result.FileID
+		job.URL = result.URL
+		job.Skipped = result.Skipped
+	}
+
+	_, err := export.Database(mongodb.Users).Collection(mongodb.Exports).UpdateOne(context, bson.D{
+		{Key: "id", Value: job.ID},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: job.Status},
+			{Key: "fileID", Value: job.FileID},
+			{Key: "url", Value: job.URL},
+			{Key: "error", Value: job.Error},
+			{Key: "skipped", Value: job.Skipped},
+			{Key: "expiresAt", Value: expires},
+		}},
+	})
+	if err != nil {
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+func (export Export) DeleteOwner(userID string) error {
+	context, cancel := export.DefaultContext()
+	defer cancel()
+
+	_, err := export.Database(mongodb.Users).Collection(mongodb.Exports).DeleteMany(context, bson.D{
+		{Key: "userID", Value: userID},
+	})
+	return err
+}
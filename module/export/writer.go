@@ -0,0 +1,272 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"kevlar/module/chat"
+	"path"
+	"strings"
+	"time"
+)
+
+// Participants of an exported conversation.
+type Header struct {
+	UserID          string    `json:"userID"`
+	Username        string    `json:"username"`
+	ContactID       string    `json:"contactID"`
+	ContactUsername string    `json:"contact_username"`
+	ExportedAt      time.Time `json:"exported_at"`
+}
+
+func (header Header) name(userID string) string {
+	switch userID {
+	case header.UserID:
+		return header.Username
+	case header.ContactID:
+		return header.ContactUsername
+	}
+	return userID
+}
+
+// Writes a transcript one message at a time, so conversations never have to be held in memory.
+type Writer interface {
+	Begin(header Header) error
+	Message(message chat.Message) error
+	End() error
+}
+
+// Returns the writer of format. Attachments link to their copy in the archive when bundled.
+func NewWriter(format string, out io.Writer, bundled bool) (Writer, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonWriter{out: out}, nil
+	case FormatHTML:
+		return &htmlWriter{out: out, bundled: bundled}, nil
+	case FormatText:
+		return &textWriter{out: out}, nil
+	}
+	return nil, ErrInvalidFormat
+}
+
+func extension(format string) string {
+	if format == FormatText {
+		return "txt"
+	}
+	return format
+}
+
+// Path of an attachment inside the archive, the file ID keeps equal names apart.
+func attachmentPath(attachment chat.Attachment) string {
+	return path.Join("attachments", attachment.FileID, path.Base("/"+attachment.Name))
+}
+
+// Text of a message and the lines listed under it, its attachments and poll options.
+func describe(message chat.Message) (string, []string) {
+	text := message.Data
+	if message.Type != chat.TypeText && message.Type != "" {
+		text = chat.Preview(message)
+	}
+
+	var lines []string
+
+	for _, attachment := range message.Attachments {
+		lines = append(lines, fmt.Sprintf("📎 %s (%d bytes)", attachment.Name, attachment.Size))
+	}
+
+	if message.Poll != nil && message.Poll.Results != nil {
+		for index, option := range message.Poll.Options {
+			lines = append(lines, fmt.Sprintf("%d. %s (%d)", index+1, option, message.Poll.Results.Counts[index]))
+		}
+	}
+
+	return text, lines
+}
+
+type jsonWriter struct {
+	out   io.Writer
+	count int
+}
+
+// Message with what the API leaves out, so the export keeps everything stored.
+type record struct {
+	chat.Message
+	Reacted []chat.Reaction `json:"reacted,omitempty"`
+	Votes   []chat.Vote     `json:"votes,omitempty"`
+}
+
+func (writer *jsonWriter) Begin(header Header) error {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(writer.out, "{\"conversation\":%s,\"messages\":[", data)
+	return err
+}
+
+func (writer *jsonWriter) Message(message chat.Message) error {
+	value := record{
+		Message: message,
+		Reacted: message.Reactions,
+	}
+
+	// Voters of anonymous polls are not revealed to the other participants.
+	if message.Poll != nil && !message.Poll.Anonymous {
+		value.Votes = message.Poll.Votes
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if writer.count != 0 {
+		_, err = writer.out.Write([]byte(","))
+		if err != nil {
+			return err
+		}
+	}
+	writer.count++
+
+	_, err = writer.out.Write(data)
+	return err
+}
+
+func (writer *jsonWriter) End() error {
+	_, err := writer.out.Write([]byte("]}\n"))
+	return err
+}
+
+type textWriter struct {
+	out    io.Writer
+	header Header
+}
+
+func (writer *textWriter) Begin(header Header) error {
+	writer.header = header
+
+	_, err := fmt.Fprintf(writer.out, "Conversation between %s and %s\nExported on %s\n\n",
+		header.Username, header.ContactUsername, header.ExportedAt.UTC().Format(time.RFC1123))
+	return err
+}
+
+func (writer *textWriter) Message(message chat.Message) error {
+	text, lines := describe(message)
+
+	prefix := ""
+	if message.Thread != "" {
+		prefix = "↳ "
+	}
+
+	// Continuation lines are indented so multi-line messages stay readable.
+	text = strings.ReplaceAll(text, "\n", "\n    ")
+
+	_, err := fmt.Fprintf(writer.out, "[%s] %s%s: %s\n",
+		message.Time.UTC().Format("2006-01-02 15:04"), prefix, writer.header.name(message.From), text)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		_, err = fmt.Fprintf(writer.out, "    %s\n", line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (writer *textWriter) End() error {
+	return nil
+}
+
+// Styles are inlined so the transcript opens offline and without the server.
+var transcript = template.Must(template.New("transcript").Parse(`
+{{define "begin"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Conversation between {{.Username}} and {{.ContactUsername}}</title>
+<style>
+body { font-family: sans-serif; background: #f4f4f6; margin: 0; padding: 2em; }
+header { margin-bottom: 2em; color: #555; }
+.message { background: #fff; border-radius: 8px; padding: 0.6em 1em; margin: 0.5em 0; max-width: 40em; }
+.message.own { margin-left: auto; background: #dcf1ff; }
+.message.reply { margin-left: 2em; }
+.meta { font-size: 0.8em; color: #777; }
+.text { white-space: pre-wrap; word-wrap: break-word; }
+ul { margin: 0.3em 0; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Username}} and {{.ContactUsername}}</h1>
+<p>Exported on {{.ExportedAt.UTC.Format "Mon, 02 Jan 2006 15:04 MST"}}</p>
+</header>
+{{end}}
+{{define "message"}}<div class="message{{if .Own}} own{{end}}{{if .Reply}} reply{{end}}" id="{{.ID}}">
+<div class="meta">{{.From}} · {{.Time}}</div>
+<div class="text">{{.Text}}</div>
+{{if .Lines}}<ul>{{range .Lines}}<li>{{if .Link}}<a href="{{.Link}}">{{.Text}}</a>{{else}}{{.Text}}{{end}}</li>{{end}}</ul>{{end}}
+</div>
+{{end}}
+{{define "end"}}</body>
+</html>
+{{end}}`))
+
+type htmlWriter struct {
+	out     io.Writer
+	header  Header
+	bundled bool
+}
+
+func (writer *htmlWriter) Begin(header Header) error {
+	writer.header = header
+	return transcript.ExecuteTemplate(writer.out, "begin", header)
+}
+
+func (writer *htmlWriter) Message(message chat.Message) error {
+	type Line struct {
+		Text string
+		Link string
+	}
+
+	text, lines := describe(message)
+
+	data := struct {
+		ID    string
+		From  string
+		Time  string
+		Text  string
+		Own   bool
+		Reply bool
+		Lines []Line
+	}{
+		ID:    message.ID,
+		From:  writer.header.name(message.From),
+		Time:  message.Time.UTC().Format("2006-01-02 15:04"),
+		Text:  text,
+		Own:   message.From == writer.header.UserID,
+		Reply: message.Thread != "",
+	}
+
+	for index, line := range lines {
+		value := Line{Text: line}
+
+		// Attachments come first in the lines.
+		if writer.bundled && index < len(message.Attachments) {
+			value.Link = attachmentPath(message.Attachments[index])
+		}
+
+		data.Lines = append(data.Lines, value)
+	}
+
+	return transcript.ExecuteTemplate(writer.out, "message", data)
+}
+
+func (writer *htmlWriter) End() error {
+	return transcript.ExecuteTemplate(writer.out, "end", nil)
+}
@@ -2,6 +2,7 @@ package file
 
 import (
 	"io/ioutil"
+	"kevlar/module/audio"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/google/uuid"
@@ -11,7 +12,11 @@ type Attributes struct {
 	Perm []string // holds the list of userIDs that are allowed to use the file
 	Size int
 	Name string
+	Mime string
 	ID   string
+
+	// Set for audio files the server could read.
+	Audio *audio.Info `json:",omitempty"`
 }
 
 // Basic file with userID permissions
@@ -22,15 +27,22 @@ type File struct {
 }
 
 func New(data []byte, name string, perm []string) File {
+	mime := mimetype.Detect(data)
 	return File{
-		Data: data,
-		Mime: mimetype.Detect(data),
-		Attributes: Attributes{
-			Perm: perm,
-			Size: len(data),
-			Name: name,
-			ID:   uuid.New().String(),
-		},
+		Data:       data,
+		Mime:       mime,
+		Attributes: NewAttributes(len(data), name, mime, perm),
+	}
+}
+
+// Returns the attributes of a new file whose data is not held in memory.
+func NewAttributes(size int, name string, mime *mimetype.MIME, perm []string) Attributes {
+	return Attributes{
+		Perm: perm,
+		Size: size,
+		Name: name,
+		Mime: mime.String(),
+		ID:   uuid.New().String(),
 	}
 }
 
@@ -0,0 +1,135 @@
+package limit
+
+import (
+	mongodb "kevlar/module/db/mongo"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Full buckets are forgotten once there are more than this many, they behave the same as new ones.
+const maxBuckets = 10000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+// Keeps buckets in the memory of this instance.
+type Memory struct {
+	lock    *sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewMemory() Memory {
+	return Memory{
+		lock:    &sync.Mutex{},
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (memory Memory) Take(key string, rule Rule) (Result, error) {
+	memory.lock.Lock()
+	defer memory.lock.Unlock()
+
+	now := time.Now()
+
+	value, ok := memory.buckets[key]
+	if !ok {
+		if len(memory.buckets) > maxBuckets {
+			for key, value := range memory.buckets {
+				if now.After(value.full) {
+					delete(memory.buckets, key)
+				}
+			}
+		}
+
+		value = &bucket{tokens: float64(rule.Burst), last: now}
+		memory.buckets[key] = value
+	}
+
+	value.tokens += now.Sub(value.last).Seconds() * rule.rate()
+	if value.tokens > float64(rule.Burst) {
+		value.tokens = float64(rule.Burst)
+	}
+	value.last = now
+
+	allowed := value.tokens >= 1
+	if allowed {
+		value.tokens--
+	}
+
+	result := rule.result(allowed, value.tokens)
+	value.full = now.Add(result.Reset)
+
+	return result, nil
+}
+
+// Keeps buckets in mongo, shared by every instance using the same database.
+type Mongo struct {
+	*mongodb.MongoClient
+}
+
+func NewMongo(mongo *mongodb.MongoClient) Mongo {
+	return Mongo{MongoClient: mongo}
+}
+
+// Refills and takes from the bucket in a single pipeline update, so concurrent
+// requests on different instances never take the same token.
+func (backend Mongo) Take(key string, rule Rule) (Result, error) {
+	context, cancel := backend.DefaultContext()
+	defer cancel()
+
+	now := time.Now()
+	burst := float64(rule.Burst)
+
+	// Buckets untouched until they would be full are removed by the TTL index.
+	expires := now.Add(time.Duration(burst / rule.rate() * float64(time.Second)))
+
+	var stored struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+
+	err := backend.Database(mongodb.Users).Collection(mongodb.RateLimits).FindOneAndUpdate(context, bson.D{
+		{Key: "key", Value: key},
+	}, bson.A{
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "tokens", Value: bson.D{{Key: "$min", Value: bson.A{
+				burst,
+				bson.D{{Key: "$add", Value: bson.A{
+					bson.D{{Key: "$ifNull", Value: bson.A{"$tokens", burst}}},
+					bson.D{{Key: "$multiply", Value: bson.A{
+						bson.D{{Key: "$divide", Value: bson.A{
+							bson.D{{Key: "$subtract", Value: bson.A{now, bson.D{{Key: "$ifNull", Value: bson.A{"$last", now}}}}}},
+							1000,
+						}}},
+						rule.rate(),
+					}}},
+				}}},
+			}}}},
+			{Key: "last", Value: now},
+			{Key: "expiresAt", Value: expires},
+		}}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "allowed", Value: bson.D{{Key: "$gte", Value: bson.A{"$tokens", 1}}}},
+		}}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "tokens", Value: bson.D{{Key: "$cond", Value: bson.A{
+				"$allowed",
+				bson.D{{Key: "$subtract", Value: bson.A{"$tokens", 1}}},
+				"$tokens",
+			}}}},
+		}}},
+	}, options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)).Decode(&stored)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return rule.result(stored.Allowed, stored.Tokens), nil
+}
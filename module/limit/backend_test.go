@@ -0,0 +1,54 @@
+package limit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBurst(t *testing.T) {
+	memory := NewMemory()
+	// Slow enough that no token is refilled during the test.
+	rule := Rule{Count: 1, Every: 3600, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		result, err := memory.Take("user:alice", rule)
+		if err != nil || !result.Allowed || result.Remaining != 2-i {
+			t.Fatalf("take %d: %+v, %v", i, result, err)
+		}
+	}
+
+	result, _ := memory.Take("user:alice", rule)
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > time.Hour {
+		t.Fatalf("expected the fourth take to be refused, got %+v", result)
+	}
+
+	// Buckets are kept per key.
+	if result, _ := memory.Take("user:bob", rule); !result.Allowed {
+		t.Fatal("bucket shared between keys")
+	}
+}
+
+func TestMemoryRefill(t *testing.T) {
+	memory := NewMemory()
+	rule := Rule{Count: 1, Every: 3600, Burst: 2}
+
+	memory.Take("key", rule)
+	memory.Take("key", rule)
+
+	// An hour later one token is back.
+	memory.buckets["key"].last = memory.buckets["key"].last.Add(-time.Hour)
+
+	if result, _ := memory.Take("key", rule); !result.Allowed {
+		t.Fatal("token was not refilled")
+	}
+	if result, _ := memory.Take("key", rule); result.Allowed {
+		t.Fatal("refilled more than one token")
+	}
+
+	// Refills never go beyond the burst.
+	memory.buckets["key"].last = memory.buckets["key"].last.Add(-24 * time.Hour)
+
+	if result, _ := memory.Take("key", rule); !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("expected a full bucket less one token, got %+v", result)
+	}
+}
@@ -0,0 +1,111 @@
+package limit
+
+import (
+	"math"
+	"time"
+)
+
+// Route classes limits are configured for.
+const (
+	Default   = "default"
+	Auth      = "auth"
+	Message   = "message"
+	Search    = "search"
+	Upload    = "upload"
+	Websocket = "websocket"
+)
+
+// Backends keeping the buckets.
+const (
+	BackendMemory = "memory"
+	BackendMongo  = "mongo"
+)
+
+// Token bucket refilled with Count tokens every Every seconds, holding at most Burst tokens.
+type Rule struct {
+	Count int
+	Every int
+	Burst int
+}
+
+type Config struct {
+	Enabled bool `default:"true"`
+	// Memory keeps buckets per instance, mongo shares them between instances.
+	Backend string `default:"memory"`
+	// Websocket methods use the class websocket.<head> when configured, websocket otherwise.
+	Classes map[string]Rule `default:"{\"default\":{\"Count\":300,\"Every\":60,\"Burst\":60},\"auth\":{\"Count\":10,\"Every\":60,\"Burst\":5},\"message\":{\"Count\":60,\"Every\":60,\"Burst\":20},\"search\":{\"Count\":30,\"Every\":60,\"Burst\":10},\"upload\":{\"Count\":20,\"Every\":60,\"Burst\":5},\"websocket\":{\"Count\":120,\"Every\":60,\"Burst\":40}}"`
+	// Takes the remote address from the last X-Forwarded-For entry, for servers behind a reverse proxy.
+	TrustProxy bool `default:"false"`
+}
+
+// State of a bucket after taking a token.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time until the bucket is full again, and until the next token when not allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Keeps token buckets, implementations must be safe for concurrent use.
+type Backend interface {
+	Take(key string, rule Rule) (Result, error)
+}
+
+func (rule Rule) rate() float64 {
+	return float64(rule.Count) / float64(rule.Every)
+}
+
+// Seconds a bucket takes to fill up from empty, the window of its quota of Burst tokens.
+func (rule Rule) Window() int {
+	return int(math.Ceil(float64(rule.Burst) / rule.rate()))
+}
+
+// Result for a bucket holding tokens after a take that was allowed or not.
+func (rule Rule) result(allowed bool, tokens float64) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     rule.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(rule.Burst) - tokens) / rule.rate() * float64(time.Second)),
+	}
+
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rule.rate() * float64(time.Second))
+	}
+
+	return result
+}
+
+type Limiter struct {
+	Config
+
+	backend Backend
+}
+
+func New(config Config, backend Backend) Limiter {
+	return Limiter{
+		Config:  config,
+		backend: backend,
+	}
+}
+
+// Returns the rule of class, falling back to the default class.
+func (limiter Limiter) Rule(class string) (Rule, bool) {
+	rule, ok := limiter.Classes[class]
+	if !ok {
+		rule, ok = limiter.Classes[Default]
+	}
+	return rule, ok && rule.Count > 0 && rule.Every > 0 && rule.Burst > 0
+}
+
+// Takes a token from the bucket of key in class. Classes without a valid rule are not limited.
+func (limiter Limiter) Take(class, key string) (Result, error) {
+	rule, ok := limiter.Rule(class)
+	if !limiter.Enabled || !ok {
+		return Result{Allowed: true}, nil
+	}
+
+	return limiter.backend.Take(class+":"+key, rule)
+}
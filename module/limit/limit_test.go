@@ -0,0 +1,82 @@
+package limit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleResult(t *testing.T) {
+	// One token per second, up to ten.
+	rule := Rule{Count: 60, Every: 60, Burst: 10}
+
+	tests := []struct {
+		name    string
+		allowed bool
+		tokens  float64
+		result  Result
+	}{
+		{"full", true, 10, Result{Allowed: true, Limit: 10, Remaining: 10}},
+		{"partly used", true, 7.5, Result{Allowed: true, Limit: 10, Remaining: 7, Reset: 2500 * time.Millisecond}},
+		{"empty", true, 0, Result{Allowed: true, Limit: 10, Remaining: 0, Reset: 10 * time.Second}},
+		{"refused", false, 0.25, Result{Allowed: false, Limit: 10, Remaining: 0, Reset: 9750 * time.Millisecond, RetryAfter: 750 * time.Millisecond}},
+	}
+
+	for _, test := range tests {
+		if result := rule.result(test.allowed, test.tokens); result != test.result {
+			t.Errorf("%s: result %+v, expected %+v", test.name, result, test.result)
+		}
+	}
+}
+
+func TestLimiterRules(t *testing.T) {
+	limiter := New(Config{
+		Enabled: true,
+		Classes: map[string]Rule{
+			Default: {Count: 1, Every: 3600, Burst: 1},
+			Auth:    {Count: 1, Every: 3600, Burst: 2},
+			Search:  {Count: 0, Every: 60, Burst: 5},
+		},
+	}, NewMemory())
+
+	if rule, ok := limiter.Rule(Upload); !ok || rule.Burst != 1 {
+		t.Fatalf("expected classes without a rule to use the default, got %+v", rule)
+	}
+	if _, ok := limiter.Rule(Search); ok {
+		t.Fatal("accepted a rule without a refill rate")
+	}
+
+	// Classes use buckets of their own.
+	limiter.Take(Default, "ip:1")
+	if result, _ := limiter.Take(Auth, "ip:1"); !result.Allowed {
+		t.Fatal("bucket shared between classes")
+	}
+
+	for i := 0; i < 3; i++ {
+		if result, _ := limiter.Take(Search, "ip:1"); !result.Allowed {
+			t.Fatal("class with an invalid rule was limited")
+		}
+	}
+
+	limiter.Enabled = false
+	if result, _ := limiter.Take(Default, "ip:1"); !result.Allowed {
+		t.Fatal("disabled limiter refused a request")
+	}
+}
+
+func TestRuleWindow(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		window int
+	}{
+		{Rule{Count: 60, Every: 60, Burst: 60}, 60},
+		{Rule{Count: 300, Every: 60, Burst: 60}, 12},
+		{Rule{Count: 10, Every: 60, Burst: 5}, 30},
+		{Rule{Count: 7, Every: 10, Burst: 2}, 3},
+	}
+
+	for _, test := range tests {
+		if window := test.rule.Window(); window != test.window {
+			t.Errorf("%+v: window %d, expected %d", test.rule, window, test.window)
+		}
+	}
+}
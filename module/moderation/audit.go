@@ -0,0 +1,59 @@
+package moderation
+
+import (
+	mongodb "kevlar/module/db/mongo"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Decision of an admin on a report or a flagged message. Subject is the ID of the report
+// or flagged message, Target the user the decision is about.
+type AuditEntry struct {
+	ID      string    `bson:"id" json:"id"`
+	Admin   string    `bson:"admin" json:"admin"`
+	Action  string    `bson:"action" json:"action"`
+	Target  string    `bson:"target" json:"target"`
+	Subject string    `bson:"subject" json:"subject"`
+	Note    string    `bson:"note,omitempty" json:"note,omitempty"`
+	Time    time.Time `bson:"time" json:"time"`
+}
+
+func (moderation Moderation) audit(entry AuditEntry) error {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	entry.ID = uuid.New().String()
+	entry.Time = time.Now()
+
+	_, err := moderation.Database(mongodb.Users).Collection(mongodb.AuditLog).InsertOne(context, entry)
+	return err
+}
+
+// Returns the audit trail, newest first, optionally narrowed to decisions about target.
+func (moderation Moderation) AuditTrail(target string, before time.Time, limit int64) ([]AuditEntry, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	filter := bson.D{}
+	if target != "" {
+		filter = append(filter, bson.E{Key: "target", Value: target})
+	}
+	if !before.IsZero() {
+		filter = append(filter, bson.E{Key: "time", Value: bson.D{{Key: "$lt", Value: before}}})
+	}
+
+	cursor, err := moderation.Database(mongodb.Users).Collection(mongodb.AuditLog).Find(context, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "time", Value: -1}}).
+			SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []AuditEntry{}
+	err = cursor.All(context, &entries)
+	return entries, err
+}
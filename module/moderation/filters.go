@@ -0,0 +1,257 @@
+package moderation
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+)
+
+var (
+	wordPattern = regexp.MustCompile(`[\p{L}\p{N}']+`)
+	linkPattern = regexp.MustCompile(`(?i)\b(?:https?://)?(?:[\p{L}\p{N}-]+\.)+\p{L}{2,}(?::\d+)?(?:/\S*)?`)
+)
+
+// Replaces every character of the matched ranges with an asterisk.
+func redact(data string, matches [][]int) string {
+	var builder strings.Builder
+
+	last := 0
+	for _, match := range matches {
+		builder.WriteString(data[last:match[0]])
+		builder.WriteString(strings.Repeat("*", uniseg.GraphemeClusterCount(data[match[0]:match[1]])))
+		last = match[1]
+	}
+	builder.WriteString(data[last:])
+
+	return builder.String()
+}
+
+// Rejects messages longer than the maximum of their type.
+type lengthFilter struct {
+	max      map[string]int
+	fallback int
+}
+
+func newLengthFilter(max map[string]int, fallback int) lengthFilter {
+	return lengthFilter{max: max, fallback: fallback}
+}
+
+func (filter lengthFilter) Name() string {
+	return "length"
+}
+
+func (filter lengthFilter) Check(message Message) Decision {
+	max, ok := filter.max[message.Type]
+	if !ok {
+		max = filter.fallback
+	}
+
+	if max > 0 && uniseg.GraphemeClusterCount(message.Data) > max {
+		return Decision{
+			Action: Reject,
+			Reason: fmt.Sprintf("longer than %d characters", max),
+		}
+	}
+	return Decision{Action: Allow}
+}
+
+// Acts on links to denied domains, subdomains included, and links starting with a denied prefix.
+type linkFilter struct {
+	domains []string
+	links   []string
+	action  string
+}
+
+func newLinkFilter(domains, links []string, action string) linkFilter {
+	filter := linkFilter{action: action}
+
+	for _, domain := range domains {
+		filter.domains = append(filter.domains, strings.Trim(strings.ToLower(domain), "."))
+	}
+	for _, link := range links {
+		filter.links = append(filter.links, strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(link, "https://"), "http://")))
+	}
+
+	return filter
+}
+
+func (filter linkFilter) Name() string {
+	return "link"
+}
+
+func (filter linkFilter) denied(link string) bool {
+	lower := strings.ToLower(link)
+	if !strings.Contains(lower, "://") {
+		lower = "http://" + lower
+	}
+
+	parsed, err := url.Parse(lower)
+	if err != nil {
+		return false
+	}
+
+	host := parsed.Hostname()
+	for _, domain := range filter.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	bare := host + parsed.EscapedPath()
+	for _, prefix := range filter.links {
+		if strings.HasPrefix(bare, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (filter linkFilter) Check(message Message) Decision {
+	if len(filter.domains) == 0 && len(filter.links) == 0 {
+		return Decision{Action: Allow}
+	}
+
+	var matches [][]int
+	for _, match := range linkPattern.FindAllStringIndex(message.Data, -1) {
+		if filter.denied(message.Data[match[0]:match[1]]) {
+			matches = append(matches, match)
+		}
+	}
+
+	if len(matches) == 0 {
+		return Decision{Action: Allow}
+	}
+
+	return Decision{
+		Action: filter.action,
+		Data:   redact(message.Data, matches),
+		Reason: "contains a denied link",
+	}
+}
+
+// Acts on messages containing words of the word list.
+type wordFilter struct {
+	words  map[string]bool
+	action string
+}
+
+func newWordFilter(words []string, action string) wordFilter {
+	filter := wordFilter{
+		words:  make(map[string]bool),
+		action: action,
+	}
+
+	for _, word := range words {
+		filter.words[strings.ToLower(strings.TrimSpace(word))] = true
+	}
+
+	return filter
+}
+
+func (filter wordFilter) Name() string {
+	return "words"
+}
+
+func (filter wordFilter) Check(message Message) Decision {
+	if len(filter.words) == 0 {
+		return Decision{Action: Allow}
+	}
+
+	var matches [][]int
+	for _, match := range wordPattern.FindAllStringIndex(message.Data, -1) {
+		if filter.words[strings.ToLower(message.Data[match[0]:match[1]])] {
+			matches = append(matches, match)
+		}
+	}
+
+	if len(matches) == 0 {
+		return Decision{Action: Allow}
+	}
+
+	return Decision{
+		Action: filter.action,
+		Data:   redact(message.Data, matches),
+		Reason: fmt.Sprintf("contains %d listed words", len(matches)),
+	}
+}
+
+// Acts on users sending the same text over and over, to the same or different users.
+// Texts are compared ignoring case and whitespace.
+type repeatFilter struct {
+	limit  int
+	window time.Duration
+	action string
+
+	lock  *sync.Mutex
+	sent  map[[sha256.Size]byte][]time.Time
+	swept time.Time
+}
+
+func newRepeatFilter(limit, window int, action string) *repeatFilter {
+	return &repeatFilter{
+		limit:  limit,
+		window: time.Duration(window) * time.Second,
+		action: action,
+		lock:   &sync.Mutex{},
+		sent:   make(map[[sha256.Size]byte][]time.Time),
+	}
+}
+
+func (filter *repeatFilter) Name() string {
+	return "repeat"
+}
+
+func recent(times []time.Time, since time.Time) []time.Time {
+	for len(times) > 0 && times[0].Before(since) {
+		times = times[1:]
+	}
+	return times
+}
+
+func (filter *repeatFilter) Check(message Message) Decision {
+	normalized := strings.Join(strings.FieldsFunc(strings.ToLower(message.Data), unicode.IsSpace), " ")
+	if normalized == "" {
+		return Decision{Action: Allow}
+	}
+
+	key := sha256.Sum256([]byte(message.From + "\x00" + normalized))
+
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	now := time.Now()
+	since := now.Add(-filter.window)
+
+	// Forget texts nobody repeated within the window.
+	if now.Sub(filter.swept) > filter.window {
+		for other, times := range filter.sent {
+			if times = recent(times, since); len(times) == 0 {
+				delete(filter.sent, other)
+			} else {
+				filter.sent[other] = times
+			}
+		}
+		filter.swept = now
+	}
+
+	times := append(recent(filter.sent[key], since), now)
+	filter.sent[key] = times
+
+	if len(times) <= filter.limit {
+		return Decision{Action: Allow}
+	}
+
+	return Decision{
+		Action: filter.action,
+		Data:   strings.Repeat("*", uniseg.GraphemeClusterCount(message.Data)),
+		Reason: fmt.Sprintf("sent %d times within %s", len(times), filter.window),
+	}
+}
@@ -0,0 +1,141 @@
+package moderation
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		data     string
+		matches  [][]int
+		expected string
+	}{
+		{"hello", nil, "hello"},
+		{"hello world", [][]int{{6, 11}}, "hello *****"},
+		{"a bad bad day", [][]int{{2, 5}, {6, 9}}, "a *** *** day"},
+		{"schön", [][]int{{0, 6}}, "*****"},
+	}
+
+	for _, test := range tests {
+		if redacted := redact(test.data, test.matches); redacted != test.expected {
+			t.Errorf("redact(%q) = %q, expected %q", test.data, redacted, test.expected)
+		}
+	}
+}
+
+func TestLengthFilter(t *testing.T) {
+	filter := newLengthFilter(map[string]int{"text": 5, "attachment": 0}, 3)
+
+	tests := []struct {
+		kind   string
+		data   string
+		action string
+	}{
+		{"text", "hello", Allow},
+		{"text", "hello!", Reject},
+		{"text", "👍🏽👍🏽👍🏽👍🏽👍🏽", Allow}, // characters, not bytes
+		{"poll", "abc", Allow},
+		{"poll", "abcd", Reject},
+		{"attachment", "a long caption without a limit", Allow},
+	}
+
+	for _, test := range tests {
+		if decision := filter.Check(Message{Type: test.kind, Data: test.data}); decision.Action != test.action {
+			t.Errorf("%s %q: %s, expected %s", test.kind, test.data, decision.Action, test.action)
+		}
+	}
+}
+
+func TestLinkFilter(t *testing.T) {
+	filter := newLinkFilter([]string{"Spam.example."}, []string{"https://good.example/bad"}, Redact)
+
+	tests := []struct {
+		data     string
+		action   string
+		redacted string
+	}{
+		{"see https://spam.example/offer", Redact, "see " + strings.Repeat("*", 26)},
+		{"see sub.spam.example", Redact, "see " + strings.Repeat("*", 16)},
+		{"see SPAM.EXAMPLE/x", Redact, "see " + strings.Repeat("*", 14)},
+		{"see notspam.example", Allow, ""},
+		{"see good.example/bad/page", Redact, "see " + strings.Repeat("*", 21)},
+		{"see good.example/good", Allow, ""},
+		{"no links here", Allow, ""},
+	}
+
+	for _, test := range tests {
+		decision := filter.Check(Message{Data: test.data})
+		if decision.Action != test.action {
+			t.Errorf("%q: %s, expected %s", test.data, decision.Action, test.action)
+			continue
+		}
+		if test.action == Redact && decision.Data != test.redacted {
+			t.Errorf("%q: redacted to %q, expected %q", test.data, decision.Data, test.redacted)
+		}
+	}
+
+	if decision := newLinkFilter(nil, nil, Reject).Check(Message{Data: "https://spam.example"}); decision.Action != Allow {
+		t.Error("filter without denied links acted on a message")
+	}
+}
+
+func TestWordFilter(t *testing.T) {
+	filter := newWordFilter([]string{" Darn ", "heck"}, Redact)
+
+	tests := []struct {
+		data     string
+		action   string
+		redacted string
+	}{
+		{"darn it", Redact, "**** it"},
+		{"DARN, heck!", Redact, "****, ****!"},
+		{"darned", Allow, ""},
+		{"clean text", Allow, ""},
+	}
+
+	for _, test := range tests {
+		decision := filter.Check(Message{Data: test.data})
+		if decision.Action != test.action {
+			t.Errorf("%q: %s, expected %s", test.data, decision.Action, test.action)
+			continue
+		}
+		if test.action == Redact && decision.Data != test.redacted {
+			t.Errorf("%q: redacted to %q, expected %q", test.data, decision.Data, test.redacted)
+		}
+	}
+}
+
+func TestRepeatFilter(t *testing.T) {
+	filter := newRepeatFilter(2, 60, Flag)
+
+	check := func(from, data string) string {
+		return filter.Check(Message{From: from, Data: data}).Action
+	}
+
+	if check("alice", "buy now") != Allow || check("alice", "BUY   now") != Allow {
+		t.Fatal("flagged within the limit")
+	}
+	if check("alice", " buy now ") != Flag {
+		t.Fatal("third repeat within the window was not flagged")
+	}
+	if check("bob", "buy now") != Allow {
+		t.Fatal("repeats counted across senders")
+	}
+	if check("alice", "") != Allow || check("alice", "") != Allow || check("alice", "") != Allow {
+		t.Fatal("blank messages counted as repeats")
+	}
+
+	// Repeats older than the window are forgotten.
+	for key, times := range filter.sent {
+		for index := range times {
+			times[index] = times[index].Add(-2 * time.Minute)
+		}
+		filter.sent[key] = times
+	}
+
+	if check("alice", "buy now") != Allow {
+		t.Fatal("repeats outside the window still counted")
+	}
+}
@@ -0,0 +1,152 @@
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	mongodb "kevlar/module/db/mongo"
+)
+
+// Outcomes a filter can decide on.
+const (
+	Allow  = "allow"
+	Reject = "reject"
+	Redact = "redact"
+	Flag   = "flag"
+)
+
+var (
+	ErrRejected = errors.New("message rejected by moderation")
+)
+
+type Config struct {
+	// Words matched case-insensitively as whole words, and what to do with messages containing them.
+	Words      []string `default:"[]"`
+	WordAction string   `default:"redact"`
+	// Links to these domains or their subdomains, and links starting with one of DenyLinks.
+	DenyDomains []string `default:"[]"`
+	DenyLinks   []string `default:"[]"`
+	LinkAction  string   `default:"reject"`
+	// Maximum length in characters per message type, other types use DefaultMaxLength.
+	MaxLength        map[string]int `default:"{\"text\":4000,\"attachment\":1000}"`
+	DefaultMaxLength int            `default:"4000"`
+	// Sending the same text more than RepeatLimit times within RepeatWindow seconds counts as spam,
+	// a limit of zero turns the check off.
+	RepeatLimit  int    `default:"3"`
+	RepeatWindow int    `default:"60"`
+	RepeatAction string `default:"flag"`
+}
+
+// Message as seen by filters.
+type Message struct {
+	From string
+	To   string
+	Type string
+	Data string
+}
+
+// Decision of a filter on a message. Redact decisions carry the redacted text in Data.
+type Decision struct {
+	Action string
+	Data   string
+	Reason string
+}
+
+type Filter interface {
+	Name() string
+	Check(message Message) Decision
+}
+
+// Reason a filter gave for flagging or redacting a message.
+type Note struct {
+	Filter string `bson:"filter" json:"filter"`
+	Action string `bson:"action" json:"action"`
+	Reason string `bson:"reason" json:"reason"`
+}
+
+// Outcome of running a message through every filter.
+type Result struct {
+	Data    string
+	Flagged bool
+	Notes   []Note
+}
+
+type Moderation struct {
+	*mongodb.MongoClient
+	Config
+
+	filters []Filter
+	// Filters judging the text alone, without the history of the sender.
+	content []Filter
+}
+
+// Returns the pipeline of built-in filters configured by config, followed by filters.
+func New(mongo *mongodb.MongoClient, config Config, filters ...Filter) Moderation {
+	builtin := []Filter{
+		newLengthFilter(config.MaxLength, config.DefaultMaxLength),
+		newLinkFilter(config.DenyDomains, config.DenyLinks, action(config.LinkAction, Reject)),
+		newWordFilter(config.Words, action(config.WordAction, Redact)),
+	}
+
+	content := append(append([]Filter{}, builtin...), filters...)
+
+	if config.RepeatLimit > 0 {
+		builtin = append(builtin, newRepeatFilter(config.RepeatLimit, config.RepeatWindow, action(config.RepeatAction, Flag)))
+	}
+
+	return Moderation{
+		MongoClient: mongo,
+		Config:      config,
+		filters:     append(builtin, filters...),
+		content:     content,
+	}
+}
+
+// Returns the configured action, or fallback when it is not one filters can take.
+func action(configured, fallback string) string {
+	switch configured {
+	case Reject, Redact, Flag:
+		return configured
+	}
+	return fallback
+}
+
+// Runs the message through the filters in order. Redactions are seen by the filters that
+// follow, and the first rejection stops the pipeline with ErrRejected.
+func (moderation Moderation) Check(message Message) (Result, error) {
+	return run(moderation.filters, message)
+}
+
+// Same as Check with only the filters judging the text itself. Used for parts of a message,
+// such as poll options, which would otherwise count as repeats of each other.
+func (moderation Moderation) CheckContent(message Message) (Result, error) {
+	return run(moderation.content, message)
+}
+
+func run(filters []Filter, message Message) (Result, error) {
+	result := Result{Data: message.Data}
+
+	for _, filter := range filters {
+		message.Data = result.Data
+
+		decision := filter.Check(message)
+
+		switch decision.Action {
+		case Reject:
+			return Result{}, fmt.Errorf("%w: %s", ErrRejected, decision.Reason)
+		case Redact:
+			result.Data = decision.Data
+		case Flag:
+			result.Flagged = true
+		default:
+			continue
+		}
+
+		result.Notes = append(result.Notes, Note{
+			Filter: filter.Name(),
+			Action: decision.Action,
+			Reason: decision.Reason,
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,61 @@
+package moderation
+
+import (
+	"errors"
+	"testing"
+)
+
+func testModeration() Moderation {
+	return New(nil, Config{
+		Words:            []string{"darn"},
+		WordAction:       Redact,
+		DenyDomains:      []string{"spam.example"},
+		LinkAction:       Reject,
+		MaxLength:        map[string]int{"text": 20},
+		DefaultMaxLength: 20,
+		RepeatLimit:      2,
+		RepeatWindow:     60,
+		RepeatAction:     Flag,
+	})
+}
+
+func TestCheckContentSkipsRepeats(t *testing.T) {
+	moderation := testModeration()
+
+	option := Message{From: "alice", To: "bob", Type: "poll", Data: "yes"}
+
+	for i := 0; i < 5; i++ {
+		result, err := moderation.CheckContent(option)
+		if err != nil || result.Flagged {
+			t.Fatalf("poll option %d flagged: %+v, %v", i, result, err)
+		}
+	}
+
+	// The same text sent as messages is still spam.
+	var result Result
+	for i := 0; i < 3; i++ {
+		result, _ = moderation.Check(option)
+	}
+	if !result.Flagged {
+		t.Fatal("repeated message was not flagged")
+	}
+}
+
+func TestCheckContentFilters(t *testing.T) {
+	moderation := testModeration()
+
+	result, err := moderation.CheckContent(Message{From: "alice", Type: "poll", Data: "darn it"})
+	if err != nil || result.Data != "**** it" || len(result.Notes) != 1 {
+		t.Fatalf("word filter not applied to poll options: %+v, %v", result, err)
+	}
+
+	_, err = moderation.CheckContent(Message{From: "alice", Type: "poll", Data: "spam.example/win"})
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("link filter not applied to poll options: %v", err)
+	}
+
+	_, err = moderation.CheckContent(Message{From: "alice", Type: "poll", Data: "an option far too long to fit"})
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("length filter not applied to poll options: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package moderation
+
+import (
+	"errors"
+	mongodb "kevlar/module/db/mongo"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of flagged messages in the review queue.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRemoved  = "removed"
+)
+
+var (
+	ErrFlaggedDoesNotExist = errors.New("flagged message does not exist or was already reviewed")
+	ErrInvalidReview       = errors.New("invalid review decision")
+)
+
+// Stored message a filter flagged, waiting for an admin to approve or remove it.
+type Flagged struct {
+	ID        string `bson:"id" json:"id"`
+	MessageID string `bson:"message_id" json:"message_id"`
+	From      string `bson:"from" json:"from"`
+	To        string `bson:"to" json:"to"`
+	Type      string `bson:"type" json:"type"`
+	Data      string `bson:"data" json:"data"`
+	Notes     []Note `bson:"notes" json:"notes"`
+
+	Status     string     `bson:"status" json:"status"`
+	ReviewedBy string     `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+}
+
+// Returns the other participant of the conversation of the flagged message.
+func (flagged Flagged) Peer(userID string) string {
+	if userID == flagged.From {
+		return flagged.To
+	}
+	return flagged.From
+}
+
+// Adds the stored message with messageID to the review queue.
+func (moderation Moderation) Enqueue(message Message, messageID string, notes []Note) (Flagged, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	flagged := Flagged{
+		ID:        uuid.New().String(),
+		MessageID: messageID,
+		From:      message.From,
+		To:        message.To,
+		Type:      message.Type,
+		Data:      message.Data,
+		Notes:     notes,
+		Status:    ReviewPending,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := moderation.Database(mongodb.Users).Collection(mongodb.ModerationQueue).InsertOne(context, flagged)
+	return flagged, err
+}
+
+// Returns flagged messages with status created before before, oldest first for pending
+// messages so the queue is worked in order, newest first otherwise.
+func (moderation Moderation) Queue(status string, before time.Time, limit int64) ([]Flagged, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	if status == "" {
+		status = ReviewPending
+	}
+
+	filter := bson.D{{Key: "status", Value: status}}
+	sort := -1
+
+	if status == ReviewPending {
+		sort = 1
+		if !before.IsZero() {
+			filter = append(filter, bson.E{Key: "created_at", Value: bson.D{{Key: "$gt", Value: before}}})
+		}
+	} else if !before.IsZero() {
+		filter = append(filter, bson.E{Key: "created_at", Value: bson.D{{Key: "$lt", Value: before}}})
+	}
+
+	cursor, err := moderation.Database(mongodb.Users).Collection(mongodb.ModerationQueue).Find(context, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: sort}}).
+			SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	queue := []Flagged{}
+	err = cursor.All(context, &queue)
+	return queue, err
+}
+
+// Records the decision of admin on a pending flagged message and adds it to the audit trail,
+// decision is ReviewApproved or ReviewRemoved. Removing the message itself is left to the caller.
+func (moderation Moderation) Review(id, admin, decision string) (Flagged, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	if decision != ReviewApproved && decision != ReviewRemoved {
+		return Flagged{}, ErrInvalidReview
+	}
+
+	var flagged Flagged
+
+	err := moderation.Database(mongodb.Users).Collection(mongodb.ModerationQueue).FindOneAndUpdate(context, bson.D{
+		{Key: "id", Value: id},
+		{Key: "status", Value: ReviewPending},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: decision},
+			{Key: "reviewed_by", Value: admin},
+			{Key: "reviewed_at", Value: time.Now()},
+		}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&flagged)
+	if err == mongo.ErrNoDocuments {
+		return Flagged{}, ErrFlaggedDoesNotExist
+	}
+	if err != nil {
+		return Flagged{}, err
+	}
+
+	err = moderation.audit(AuditEntry{
+		Admin:   admin,
+		Action:  decision,
+		Target:  flagged.From,
+		Subject: flagged.ID,
+	})
+
+	return flagged, err
+}
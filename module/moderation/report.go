@@ -0,0 +1,252 @@
+package moderation
+
+import (
+	"errors"
+	"kevlar/module/chat"
+	mongodb "kevlar/module/db/mongo"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rivo/uniseg"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reasons a user can report another user for.
+const (
+	ReasonSpam          = "spam"
+	ReasonHarassment    = "harassment"
+	ReasonHate          = "hate"
+	ReasonImpersonation = "impersonation"
+	ReasonOther         = "other"
+)
+
+// States of a report, every state but ReportOpen is the outcome of a decision.
+const (
+	ReportOpen      = "open"
+	ReportWarned    = "warned"
+	ReportDisabled  = "disabled"
+	ReportDismissed = "dismissed"
+)
+
+// Decisions admins can take on a report.
+const (
+	ActionWarn    = "warn"
+	ActionDisable = "disable"
+	ActionDismiss = "dismiss"
+)
+
+const (
+	MaxReportDetails  = 1000
+	MaxReportMessages = 20
+)
+
+var (
+	ErrSelfReport          = errors.New("cannot report self")
+	ErrInvalidReport       = errors.New("invalid report")
+	ErrAlreadyReported     = errors.New("user already has an open report from this user")
+	ErrReportDoesNotExist  = errors.New("report does not exist or was already decided")
+	ErrInvalidReportAction = errors.New("invalid report action")
+)
+
+type Report struct {
+	ID       string `bson:"id" json:"id"`
+	Reporter string `bson:"reporter" json:"reporter"`
+	Target   string `bson:"target" json:"target"`
+	Reason   string `bson:"reason" json:"reason"`
+	Details  string `bson:"details,omitempty" json:"details,omitempty"`
+
+	// Copies of the reported messages, kept even if the messages are deleted later.
+	MessageIDs []string       `bson:"message_ids,omitempty" json:"message_ids,omitempty"`
+	Evidence   []chat.Message `bson:"evidence,omitempty" json:"evidence,omitempty"`
+
+	Status    string     `bson:"status" json:"status"`
+	DecidedBy string     `bson:"decided_by,omitempty" json:"decided_by,omitempty"`
+	DecidedAt *time.Time `bson:"decided_at,omitempty" json:"decided_at,omitempty"`
+	Note      string     `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt time.Time  `bson:"created_at" json:"created_at"`
+}
+
+func validReason(reason string) bool {
+	switch reason {
+	case ReasonSpam, ReasonHarassment, ReasonHate, ReasonImpersonation, ReasonOther:
+		return true
+	}
+	return false
+}
+
+// Returns ids without duplicates, in their original order.
+func unique(ids []string) []string {
+	seen := make(map[string]bool)
+
+	var result []string
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// Checks a report before its evidence is collected, and returns the message IDs to copy.
+func CheckReport(reporter, target, reason, details string, messageIDs []string) ([]string, error) {
+	if reporter == target {
+		return nil, ErrSelfReport
+	}
+
+	messageIDs = unique(messageIDs)
+
+	if !validReason(reason) || uniseg.GraphemeClusterCount(details) > MaxReportDetails || len(messageIDs) > MaxReportMessages {
+		return nil, ErrInvalidReport
+	}
+
+	return messageIDs, nil
+}
+
+// Files a report of target by reporter, with copies of the reported messages as evidence.
+func (moderation Moderation) Report(reporter, target, reason, details string, evidence []chat.Message) (Report, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	collection := moderation.Database(mongodb.Users).Collection(mongodb.Reports)
+
+	count, err := collection.CountDocuments(context, bson.D{
+		{Key: "reporter", Value: reporter},
+		{Key: "target", Value: target},
+		{Key: "status", Value: ReportOpen},
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	if count != 0 {
+		return Report{}, ErrAlreadyReported
+	}
+
+	report := Report{
+		ID:        uuid.New().String(),
+		Reporter:  reporter,
+		Target:    target,
+		Reason:    reason,
+		Details:   strings.TrimSpace(details),
+		Evidence:  evidence,
+		Status:    ReportOpen,
+		CreatedAt: time.Now(),
+	}
+
+	for _, message := range evidence {
+		report.MessageIDs = append(report.MessageIDs, message.ID)
+	}
+
+	_, err = collection.InsertOne(context, report)
+	return report, err
+}
+
+// Returns the report with id.
+func (moderation Moderation) GetReport(id string) (Report, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	var report Report
+
+	err := moderation.Database(mongodb.Users).Collection(mongodb.Reports).FindOne(context, bson.D{
+		{Key: "id", Value: id},
+	}).Decode(&report)
+	if err == mongo.ErrNoDocuments {
+		return Report{}, ErrReportDoesNotExist
+	}
+
+	return report, err
+}
+
+// Returns reports with status, open reports oldest first so they are triaged in order,
+// decided ones newest first. Passing a target narrows the list to reports of that user.
+func (moderation Moderation) Reports(status, target string, before time.Time, limit int64) ([]Report, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	if status == "" {
+		status = ReportOpen
+	}
+
+	filter := bson.D{{Key: "status", Value: status}}
+	if target != "" {
+		filter = append(filter, bson.E{Key: "target", Value: target})
+	}
+
+	sort := -1
+
+	if status == ReportOpen {
+		sort = 1
+		if !before.IsZero() {
+			filter = append(filter, bson.E{Key: "created_at", Value: bson.D{{Key: "$gt", Value: before}}})
+		}
+	} else if !before.IsZero() {
+		filter = append(filter, bson.E{Key: "created_at", Value: bson.D{{Key: "$lt", Value: before}}})
+	}
+
+	cursor, err := moderation.Database(mongodb.Users).Collection(mongodb.Reports).Find(context, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: sort}}).
+			SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []Report{}
+	err = cursor.All(context, &reports)
+	return reports, err
+}
+
+// Records the decision of admin on an open report and adds it to the audit trail.
+// Carrying out the decision, such as disabling the account, is left to the caller.
+func (moderation Moderation) Decide(id, admin, action, note string) (Report, error) {
+	context, cancel := moderation.DefaultContext()
+	defer cancel()
+
+	var status string
+	switch action {
+	case ActionWarn:
+		status = ReportWarned
+	case ActionDisable:
+		status = ReportDisabled
+	case ActionDismiss:
+		status = ReportDismissed
+	default:
+		return Report{}, ErrInvalidReportAction
+	}
+
+	note = strings.TrimSpace(note)
+
+	var report Report
+
+	err := moderation.Database(mongodb.Users).Collection(mongodb.Reports).FindOneAndUpdate(context, bson.D{
+		{Key: "id", Value: id},
+		{Key: "status", Value: ReportOpen},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: status},
+			{Key: "decided_by", Value: admin},
+			{Key: "decided_at", Value: time.Now()},
+			{Key: "note", Value: note},
+		}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&report)
+	if err == mongo.ErrNoDocuments {
+		return Report{}, ErrReportDoesNotExist
+	}
+	if err != nil {
+		return Report{}, err
+	}
+
+	err = moderation.audit(AuditEntry{
+		Admin:   admin,
+		Action:  action,
+		Target:  report.Target,
+		Subject: report.ID,
+		Note:    note,
+	})
+
+	return report, err
+}
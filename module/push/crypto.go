@@ -0,0 +1,172 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	recordSize = 4096
+	// Salt, record size, key ID length and the uncompressed public key.
+	headerSize = 16 + 4 + 1 + 65
+	// Padding delimiter and the AES-GCM tag.
+	overhead = 1 + 16
+
+	vapidExpiry = 12 * time.Hour
+)
+
+// Decodes the P-256 public key of the user agent.
+func publicKey(subscription Subscription) ([]byte, error) {
+	key, err := decode(subscription.Keys.P256dh)
+	if err != nil || len(key) != 65 {
+		return nil, ErrInvalidSubscription
+	}
+
+	x, _ := elliptic.Unmarshal(elliptic.P256(), key)
+	if x == nil {
+		return nil, ErrInvalidSubscription
+	}
+
+	return key, nil
+}
+
+func derive(secret, salt, info []byte, length int) ([]byte, error) {
+	output := make([]byte, length)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), output)
+	return output, err
+}
+
+// Encrypts the payload as a single aes128gcm record (RFC 8188) with keys derived as in RFC 8291.
+func encrypt(subscription Subscription, payload []byte) ([]byte, error) {
+	user_public, err := publicKey(subscription)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := decode(subscription.Keys.Auth)
+	if err != nil {
+		return nil, ErrInvalidSubscription
+	}
+
+	curve := elliptic.P256()
+
+	// A new key pair is used for every message.
+	private, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	server_public := elliptic.Marshal(curve, x, y)
+
+	user_x, user_y := elliptic.Unmarshal(curve, user_public)
+	shared_x, _ := curve.ScalarMult(user_x, user_y, private)
+
+	shared := make([]byte, 32)
+	shared_x.FillBytes(shared)
+
+	info := append([]byte("WebPush: info\x00"), user_public...)
+	info = append(info, server_public...)
+
+	ikm, err := derive(shared, auth, info, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := derive(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := derive(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, salt...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[16:], recordSize)
+	header = append(header, byte(len(server_public)))
+	header = append(header, server_public...)
+
+	// The last and only record ends with the 0x02 delimiter and no padding.
+	record := append(append([]byte{}, payload...), 0x02)
+
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// Builds the VAPID authorization header (RFC 8292) for the push service of the endpoint.
+func (push Push) vapid(endpoint string, private *ecdsa.PrivateKey) (string, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(struct {
+		Type      string `json:"typ"`
+		Algorithm string `json:"alg"`
+	}{"JWT", "ES256"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(struct {
+		Audience string `json:"aud"`
+		Expiry   int64  `json:"exp"`
+		Subject  string `json:"sub"`
+	}{
+		Audience: parsed.Scheme + "://" + parsed.Host,
+		Expiry:   time.Now().Add(vapidExpiry).Unix(),
+		Subject:  push.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+
+	r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// JWS uses the fixed size concatenation of r and s rather than ASN.1.
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	token := unsigned + "." + encoding.EncodeToString(signature)
+	key := encoding.EncodeToString(elliptic.Marshal(elliptic.P256(), private.X, private.Y))
+
+	return "vapid t=" + token + ", k=" + key, nil
+}
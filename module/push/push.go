@@ -0,0 +1,283 @@
+package push
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mongodb "kevlar/module/db/mongo"
+	"kevlar/module/sec"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	vapidKey = "vapid"
+
+	// Push services must accept payloads of at least 4096 bytes, including the encryption overhead.
+	MaxPayload = recordSize - headerSize - overhead
+)
+
+var (
+	ErrSubscriptionGone    = errors.New("push subscription has expired")
+	ErrInvalidSubscription = errors.New("invalid push subscription")
+	ErrPayloadTooLarge     = errors.New("push payload is too large")
+)
+
+type Config struct {
+	// Contact for the push service operator, a mailto: or https: URL.
+	Subject         string `default:"mailto:admin@example.com"`
+	TTL             int    `default:"86400"`
+	TimeoutDuration int    `default:"10"`
+	// Allows plain http endpoints, for running against a local push service.
+	AllowInsecure bool `default:"false"`
+}
+
+// Subscription of a single device, in the format of PushSubscription.toJSON() in browsers.
+type Subscription struct {
+	Endpoint string `bson:"endpoint" json:"endpoint"`
+	Keys     struct {
+		P256dh string `bson:"p256dh" json:"p256dh"`
+		Auth   string `bson:"auth" json:"auth"`
+	} `bson:"keys" json:"keys"`
+
+	Device    string    `bson:"device" json:"device"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+type Push struct {
+	*mongodb.MongoClient
+	Config
+
+	Client *http.Client
+
+	keys *keys
+}
+
+type keys struct {
+	lock    sync.Mutex
+	private *ecdsa.PrivateKey
+}
+
+func New(mongo *mongodb.MongoClient, config Config) Push {
+	dialer := &net.Dialer{
+		Timeout: time.Duration(config.TimeoutDuration) * time.Second,
+	}
+	// Endpoints are given by clients, so they must not reach into the network of the server.
+	if !config.AllowInsecure {
+		dialer = sec.PublicDialer(time.Duration(config.TimeoutDuration) * time.Second)
+	}
+
+	return Push{
+		MongoClient: mongo,
+		Config:      config,
+		Client: &http.Client{
+			Timeout: time.Duration(config.TimeoutDuration) * time.Second,
+			Transport: &http.Transport{
+				Proxy:       http.ProxyFromEnvironment,
+				DialContext: dialer.DialContext,
+			},
+		},
+		keys: &keys{},
+	}
+}
+
+// Decodes base64url with or without padding, as both are found in the wild.
+func decode(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// Checks the endpoint and keys of a subscription.
+func (push Push) Validate(subscription Subscription) error {
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return ErrInvalidSubscription
+	}
+
+	if endpoint.Scheme != "https" && !(push.AllowInsecure && endpoint.Scheme == "http") {
+		return ErrInvalidSubscription
+	}
+
+	// Host names are checked when connecting, addresses are refused right away.
+	ip := net.ParseIP(endpoint.Hostname())
+	if ip != nil && !push.AllowInsecure && !sec.PublicIP(ip) {
+		return ErrInvalidSubscription
+	}
+
+	_, err = publicKey(subscription)
+	if err != nil {
+		return err
+	}
+
+	auth, err := decode(subscription.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return ErrInvalidSubscription
+	}
+
+	return nil
+}
+
+// Loads the VAPID key pair of the server, generating and storing it on first use.
+func (push Push) key() (*ecdsa.PrivateKey, error) {
+	push.keys.lock.Lock()
+	defer push.keys.lock.Unlock()
+
+	if push.keys.private != nil {
+		return push.keys.private, nil
+	}
+
+	context, cancel := push.DefaultContext()
+	defer cancel()
+
+	collection := push.Database(mongodb.Users).Collection(mongodb.Keys)
+
+	var stored struct {
+		Name string `bson:"name"`
+		Key  []byte `bson:"key"`
+	}
+
+	err := collection.FindOne(context, bson.D{
+		{Key: "name", Value: vapidKey},
+	}).Decode(&stored)
+
+	if err == mongo.ErrNoDocuments {
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded, err := x509.MarshalECPrivateKey(private)
+		if err != nil {
+			return nil, err
+		}
+
+		stored.Name = vapidKey
+		stored.Key = encoded
+
+		// Another instance may have stored a key first, in which case that key is used.
+		_, err = collection.InsertOne(context, stored)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		if err == nil {
+			push.keys.private = private
+			return private, nil
+		}
+
+		err = collection.FindOne(context, bson.D{
+			{Key: "name", Value: vapidKey},
+		}).Decode(&stored)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	private, err := x509.ParseECPrivateKey(stored.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	push.keys.private = private
+	return private, nil
+}
+
+// Returns the VAPID public key to be used as applicationServerKey by clients.
+func (push Push) PublicKey() (string, error) {
+	private, err := push.key()
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(
+		elliptic.Marshal(elliptic.P256(), private.X, private.Y)), nil
+}
+
+// Encrypts and sends the payload to the push service of the subscription.
+// ErrSubscriptionGone is returned when the push service no longer knows the subscription.
+func (push Push) Send(subscription Subscription, payload []byte) error {
+	if len(payload) > MaxPayload {
+		return ErrPayloadTooLarge
+	}
+
+	err := push.Validate(subscription)
+	if err != nil {
+		return err
+	}
+
+	private, err := push.key()
+	if err != nil {
+		return err
+	}
+
+	body, err := encrypt(subscription, payload)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := push.vapid(subscription.Endpoint, private)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", authorization)
+	request.Header.Set("Content-Encoding", "aes128gcm")
+	request.Header.Set("Content-Type", "application/octet-stream")
+	request.Header.Set("TTL", strconv.Itoa(push.TTL))
+	request.Header.Set("Urgency", "high")
+
+	response, err := push.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// Drain the body so the connection can be reused.
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 4096))
+
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		return ErrSubscriptionGone
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return fmt.Errorf("push service responded with %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// Sends the payload to every subscription and passes those the push service no longer knows
+// to remove. A failing device does not stop the others, the errors are returned per device.
+func (push Push) SendAll(subscriptions []Subscription, payload []byte, remove func(Subscription) error) map[string]error {
+	failures := map[string]error{}
+
+	for _, subscription := range subscriptions {
+		err := push.Send(subscription, payload)
+
+		if errors.Is(err, ErrSubscriptionGone) {
+			err = remove(subscription)
+		}
+		if err != nil {
+			failures[subscription.Device] = err
+		}
+	}
+
+	return failures
+}
@@ -0,0 +1,334 @@
+package push
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"kevlar/module/sec"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Key pair and auth secret of a browser.
+type userAgent struct {
+	private []byte
+	public  []byte
+	auth    []byte
+}
+
+func newUserAgent(t *testing.T) userAgent {
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := make([]byte, 16)
+	rand.Read(auth)
+
+	return userAgent{
+		private: private,
+		public:  elliptic.Marshal(elliptic.P256(), x, y),
+		auth:    auth,
+	}
+}
+
+func (agent userAgent) subscription(endpoint string) Subscription {
+	var subscription Subscription
+	subscription.Endpoint = endpoint
+	subscription.Keys.P256dh = base64.RawURLEncoding.EncodeToString(agent.public)
+	subscription.Keys.Auth = base64.RawURLEncoding.EncodeToString(agent.auth)
+	subscription.Device = endpoint
+	return subscription
+}
+
+// Decrypts an aes128gcm body the way the user agent does.
+func (agent userAgent) decrypt(t *testing.T, body []byte) []byte {
+	if len(body) < headerSize {
+		t.Fatalf("body of %d bytes is shorter than the header", len(body))
+	}
+
+	salt := body[:16]
+	if size := binary.BigEndian.Uint32(body[16:20]); size != recordSize {
+		t.Fatalf("record size %d, expected %d", size, recordSize)
+	}
+	if body[20] != 65 {
+		t.Fatalf("key id of %d bytes, expected the 65 byte server key", body[20])
+	}
+	server_public := body[21:86]
+
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, server_public)
+	if x == nil {
+		t.Fatal("invalid server public key")
+	}
+	shared_x, _ := curve.ScalarMult(x, y, agent.private)
+
+	shared := make([]byte, 32)
+	shared_x.FillBytes(shared)
+
+	info := append([]byte("WebPush: info\x00"), agent.public...)
+	info = append(info, server_public...)
+
+	ikm, _ := derive(shared, agent.auth, info, 32)
+	key, _ := derive(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce, _ := derive(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+
+	record, err := gcm.Open(nil, nonce, body[headerSize:], nil)
+	if err != nil {
+		t.Fatalf("decrypting record: %v", err)
+	}
+	if len(record) == 0 || record[len(record)-1] != 0x02 {
+		t.Fatal("record does not end with the last record delimiter")
+	}
+
+	return record[:len(record)-1]
+}
+
+func testPush(t *testing.T) Push {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push := New(nil, Config{
+		Subject:         "mailto:admin@example.com",
+		TTL:             60,
+		TimeoutDuration: 2,
+		AllowInsecure:   true,
+	})
+	push.keys.private = private
+
+	return push
+}
+
+// Checks the VAPID authorization header against the key of the server.
+func checkVAPID(t *testing.T, push Push, header, audience string) {
+	if !strings.HasPrefix(header, "vapid t=") {
+		t.Fatalf("unexpected authorization %q", header)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(header, "vapid t="), ", k=", 2)
+	if len(parts) != 2 {
+		t.Fatalf("unexpected authorization %q", header)
+	}
+	token, key := parts[0], parts[1]
+
+	private := push.keys.private
+	if key != base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), private.X, private.Y)) {
+		t.Fatal("k is not the public key of the server")
+	}
+
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		t.Fatalf("token has %d segments", len(segments))
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+	if err != nil || len(signature) != 64 {
+		t.Fatalf("invalid signature %q", segments[2])
+	}
+
+	digest := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&private.PublicKey, digest[:], r, s) {
+		t.Fatal("token signature does not verify")
+	}
+
+	var jwt struct {
+		Type      string `json:"typ"`
+		Algorithm string `json:"alg"`
+	}
+	decoded, _ := base64.RawURLEncoding.DecodeString(segments[0])
+	if json.Unmarshal(decoded, &jwt) != nil || jwt.Type != "JWT" || jwt.Algorithm != "ES256" {
+		t.Fatalf("unexpected token header %s", decoded)
+	}
+
+	var claims struct {
+		Audience string `json:"aud"`
+		Expiry   int64  `json:"exp"`
+		Subject  string `json:"sub"`
+	}
+	decoded, _ = base64.RawURLEncoding.DecodeString(segments[1])
+	if json.Unmarshal(decoded, &claims) != nil {
+		t.Fatalf("invalid claims %s", decoded)
+	}
+	if claims.Audience != audience || claims.Subject != push.Subject {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	// Push services reject tokens valid for more than 24 hours.
+	if expiry := time.Until(time.Unix(claims.Expiry, 0)); expiry <= 0 || expiry > 24*time.Hour {
+		t.Fatalf("token expires in %v", expiry)
+	}
+}
+
+func TestSend(t *testing.T) {
+	push := testPush(t)
+	agent := newUserAgent(t)
+
+	var request *http.Request
+	var body []byte
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, received *http.Request) {
+		request = received
+		body, _ = ioutil.ReadAll(received.Body)
+		writer.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	payload := []byte(`{"head":"message_incoming"}`)
+
+	err := push.Send(agent.subscription(server.URL+"/push/device"), payload)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if request.Header.Get("Content-Encoding") != "aes128gcm" || request.Header.Get("TTL") != "60" {
+		t.Fatalf("unexpected headers %v", request.Header)
+	}
+	checkVAPID(t, push, request.Header.Get("Authorization"), server.URL)
+
+	if decrypted := agent.decrypt(t, body); string(decrypted) != string(payload) {
+		t.Fatalf("decrypted %q, expected %q", decrypted, payload)
+	}
+}
+
+func TestEncryptUsesFreshKeys(t *testing.T) {
+	agent := newUserAgent(t)
+	subscription := agent.subscription("https://push.example.com/device")
+
+	first, err := encrypt(subscription, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encrypt(subscription, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(first[:headerSize]) == string(second[:headerSize]) {
+		t.Fatal("salt and server key are reused between messages")
+	}
+	if string(agent.decrypt(t, second)) != "payload" {
+		t.Fatal("second message does not decrypt")
+	}
+}
+
+func TestSendAllRemovesGone(t *testing.T) {
+	push := testPush(t)
+	agent := newUserAgent(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/gone":
+			writer.WriteHeader(http.StatusGone)
+		case "/missing":
+			writer.WriteHeader(http.StatusNotFound)
+		case "/failing":
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			writer.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	subscriptions := []Subscription{
+		agent.subscription(server.URL + "/gone"),
+		agent.subscription(server.URL + "/missing"),
+		agent.subscription(server.URL + "/failing"),
+		agent.subscription(server.URL + "/active"),
+	}
+
+	removed := map[string]bool{}
+
+	failures := push.SendAll(subscriptions, []byte("payload"), func(subscription Subscription) error {
+		removed[subscription.Endpoint] = true
+		return nil
+	})
+
+	if len(removed) != 2 || !removed[server.URL+"/gone"] || !removed[server.URL+"/missing"] {
+		t.Fatalf("expected the 404 and 410 subscriptions to be removed, got %v", removed)
+	}
+	if len(failures) != 1 || failures[server.URL+"/failing"] == nil {
+		t.Fatalf("expected only the failing device to be reported, got %v", failures)
+	}
+}
+
+func TestSendPayloadTooLarge(t *testing.T) {
+	push := testPush(t)
+	agent := newUserAgent(t)
+
+	err := push.Send(agent.subscription("https://push.example.com/device"), make([]byte, MaxPayload+1))
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected ErrPayloadTooLarge, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	agent := newUserAgent(t)
+
+	tests := []struct {
+		endpoint string
+		insecure bool
+		valid    bool
+	}{
+		{"https://fcm.googleapis.com/fcm/send/abc", false, true},
+		{"http://push.example.com/abc", false, false},
+		{"http://push.example.com/abc", true, true},
+		{"https://127.0.0.1/abc", false, false},
+		{"https://10.0.0.8/abc", false, false},
+		{"https://[::1]/abc", false, false},
+		{"https://169.254.169.254/latest", false, false},
+		{"https://127.0.0.1/abc", true, true},
+		{"/abc", false, false},
+	}
+
+	for _, test := range tests {
+		push := Push{Config: Config{AllowInsecure: test.insecure}}
+		err := push.Validate(agent.subscription(test.endpoint))
+		if (err == nil) != test.valid {
+			t.Errorf("Validate(%q, insecure %v) = %v, expected valid %v", test.endpoint, test.insecure, err, test.valid)
+		}
+	}
+
+	subscription := agent.subscription("https://push.example.com/abc")
+	subscription.Keys.Auth = base64.RawURLEncoding.EncodeToString([]byte("short"))
+	if (Push{}).Validate(subscription) == nil {
+		t.Error("accepted an auth secret that is not 16 bytes")
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		t.Error("request reached a loopback push service")
+	}))
+	defer server.Close()
+
+	push := testPush(t)
+	push.Client = New(nil, Config{TimeoutDuration: 2}).Client
+
+	agent := newUserAgent(t)
+
+	// Host names are only checked once resolved, which the dialer refuses for loopback.
+	endpoint := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	err := push.Send(agent.subscription(endpoint), []byte("payload"))
+	if !errors.Is(err, sec.ErrForbiddenAddress) {
+		t.Fatalf("expected ErrForbiddenAddress for a loopback push service, got %v", err)
+	}
+}
@@ -45,6 +45,13 @@ func Start() error {
 
 	// Start http server
 	server := http.New(&mongoClient, &minioClient, config)
+
+	err = server.Migrate()
+	if err != nil {
+		logrus.WithError(err).Error("unable to migrate data")
+		return err
+	}
+
 	go server.Start()
 
 	// Set closing function
@@ -79,6 +79,12 @@ func (store Store) CreateUser(userID string) error {
 // of the fileID in mongodb and returns the fileID.
 
 func (store Store) Upload(userID string, data *file.File) error {
+	return store.UploadFrom(userID, data.Attributes, bytes.NewReader(data.Data))
+}
+
+// Same as Upload, with the data read from reader, which must return exactly
+// attributes.Size bytes. Used for files too large to be held in memory.
+func (store Store) UploadFrom(userID string, attributes file.Attributes, reader io.Reader) error {
 
 	wrapper := func(err error) error { return fmt.Errorf("[store][%s]error while uploading file: %w", userID, err) }
 
@@ -90,7 +96,7 @@ func (store Store) Upload(userID string, data *file.File) error {
 		return wrapper(err)
 	}
 
-	size := sizeMB(data.Attributes.Size)
+	size := sizeMB(attributes.Size)
 	if (used + size) > store.QuotaLimitMB {
 		return wrapper(ErrQuotaFull)
 	}
@@ -100,7 +106,7 @@ func (store Store) Upload(userID string, data *file.File) error {
 		return wrapper(err)
 	}
 
-	meta, err := json.Marshal(data.Attributes)
+	meta, err := json.Marshal(attributes)
 	if err != nil {
 		return wrapper(err)
 	}
@@ -130,7 +136,7 @@ func (store Store) Upload(userID string, data *file.File) error {
 	options := minio.PutObjectOptions{}
 
 	// Store metadata separately
-	name := fmt.Sprintf("%s.%s", data.Attributes.ID, "meta")
+	name := fmt.Sprintf("%s.%s", attributes.ID, "meta")
 
 	info, err := store.PutObject(context, bucket, name, bytes.NewBuffer(meta), int64(len(meta)), options)
 	if err != nil {
@@ -139,13 +145,13 @@ func (store Store) Upload(userID string, data *file.File) error {
 
 	logrus.WithField("minio_upload_info", info).Trace()
 
-	name = fmt.Sprintf("%s.%s", data.Attributes.ID, "data")
+	name = fmt.Sprintf("%s.%s", attributes.ID, "data")
 
 	options = minio.PutObjectOptions{
-		ContentType: data.Mime.String(),
+		ContentType: attributes.Mime,
 	}
 
-	info, err = store.PutObject(context, bucket, name, bytes.NewBuffer(data.Data), int64(data.Attributes.Size), options)
+	info, err = store.PutObject(context, bucket, name, reader, int64(attributes.Size), options)
 
 	logrus.WithField("minio_upload_info", info).Trace()
 
@@ -423,6 +429,34 @@ func (store Store) SetFileAttributes(userID, fileID string, attributes file.Attr
 	return err
 }
 
+// Gives with permission to a file owned by userID and returns the file attributes.
+// Files without a permission list are already accessible to everyone and are left as is.
+func (store Store) ShareFile(userID, fileID, with string) (file.Attributes, error) {
+	wrapper := func(err error) error {
+		return fmt.Errorf("[store][%s]error while sharing file: %w", userID, err)
+	}
+
+	attributes, err := store.GetFileAttributes(userID, fileID)
+	if err != nil {
+		return file.Attributes{}, wrapper(err)
+	}
+
+	shared := file.File{Attributes: attributes}
+
+	if len(attributes.Perm) == 0 || shared.UserPermitted(with) {
+		return attributes, nil
+	}
+
+	shared.Add(with)
+
+	err = store.SetFileAttributes(userID, fileID, shared.Attributes)
+	if err != nil {
+		return file.Attributes{}, wrapper(err)
+	}
+
+	return shared.Attributes, nil
+}
+
 func (store Store) DeleteAll(userID string) error {
 
 	wrapper := func(err error) error { return fmt.Errorf("[store][%s]error while deleting storage: %w", userID, err) }
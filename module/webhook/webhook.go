@@ -0,0 +1,589 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mongodb "kevlar/module/db/mongo"
+	"kevlar/module/sec"
+	"math"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Event types subscriptions can be registered for.
+const (
+	MessageStored  = "message.stored"
+	RequestSent    = "request.sent"
+	RequestDecided = "request.decided"
+	AccountCreated = "account.created"
+	AccountDeleted = "account.deleted"
+	FileUploaded   = "file.uploaded"
+
+	MaxSubscriptions = 10
+)
+
+// Delivery states, dead deliveries ran out of attempts and form the dead-letter store.
+const (
+	DeliveryPending   = "pending"
+	DeliverySending   = "sending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Kevlar-Event"
+	HeaderDelivery  = "X-Kevlar-Delivery"
+	HeaderTimestamp = "X-Kevlar-Timestamp"
+	HeaderSignature = "X-Kevlar-Signature"
+)
+
+var (
+	ErrInvalidURL               = errors.New("invalid webhook URL")
+	ErrInvalidEvent             = errors.New("unknown webhook event")
+	ErrTooManySubscriptions     = errors.New("too many webhook subscriptions")
+	ErrSubscriptionDoesNotExist = errors.New("webhook subscription does not exist")
+	ErrDeliveryDoesNotExist     = errors.New("webhook delivery does not exist")
+	ErrForbiddenAddress         = sec.ErrForbiddenAddress
+)
+
+var Events = []string{MessageStored, RequestSent, RequestDecided, AccountCreated, AccountDeleted, FileUploaded}
+
+type Config struct {
+	TimeoutDuration int `default:"10"`
+	MaxAttempts     int `default:"8"`
+	// Seconds before the first retry, doubled for every further attempt up to BackoffMax.
+	BackoffBase int `default:"10"`
+	BackoffMax  int `default:"3600"`
+	// Days delivered and dead deliveries are kept in the delivery log.
+	LogRetention  int `default:"7"`
+	DeadRetention int `default:"30"`
+	// Allows plain http URLs and addresses on private networks, for receivers next to the server.
+	AllowInsecure bool `default:"false"`
+}
+
+type Subscription struct {
+	ID     string   `bson:"id" json:"id"`
+	Owner  string   `bson:"owner" json:"owner"`
+	URL    string   `bson:"url" json:"url"`
+	Events []string `bson:"events" json:"events"`
+	// Subscriptions with All set receive events of every user, others only events involving the owner.
+	All bool `bson:"all" json:"all"`
+	// Only returned when the subscription is created.
+	Secret string `bson:"secret" json:"secret,omitempty"`
+
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Result of a single delivery attempt.
+type Attempt struct {
+	Time     time.Time `bson:"time" json:"time"`
+	Status   int       `bson:"status,omitempty" json:"status,omitempty"`
+	Error    string    `bson:"error,omitempty" json:"error,omitempty"`
+	Duration int64     `bson:"duration" json:"duration"` // milliseconds
+}
+
+type Delivery struct {
+	ID             string `bson:"id" json:"id"`
+	SubscriptionID string `bson:"subscription_id" json:"subscription_id"`
+	Owner          string `bson:"owner" json:"-"`
+	URL            string `bson:"url" json:"url"`
+	Event          string `bson:"event" json:"event"`
+	Payload        string `bson:"payload" json:"payload"`
+
+	Status      string     `bson:"status" json:"status"`
+	Attempts    []Attempt  `bson:"attempts" json:"attempts"`
+	NextAttempt time.Time  `bson:"next_attempt" json:"next_attempt"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt   *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// Body of every delivery, Data depends on the event.
+type Payload struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type Webhook struct {
+	*mongodb.MongoClient
+	Config
+
+	Client *http.Client
+}
+
+// Deliveries stuck in sending for longer, for example after a crash, are claimed again.
+var claimTimeout = time.Minute
+
+func New(mongo *mongodb.MongoClient, config Config) Webhook {
+	dialer := &net.Dialer{
+		Timeout: time.Duration(config.TimeoutDuration) * time.Second,
+	}
+	if !config.AllowInsecure {
+		dialer = sec.PublicDialer(time.Duration(config.TimeoutDuration) * time.Second)
+	}
+
+	return Webhook{
+		MongoClient: mongo,
+		Config:      config,
+		Client: &http.Client{
+			Timeout: time.Duration(config.TimeoutDuration) * time.Second,
+			Transport: &http.Transport{
+				Proxy:       http.ProxyFromEnvironment,
+				DialContext: dialer.DialContext,
+			},
+			// Redirects are not followed, the receiver has to answer at the registered URL.
+			CheckRedirect: func(request *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func validEvent(event string) bool {
+	for _, known := range Events {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (webhook Webhook) validURL(raw string) bool {
+	endpoint, err := url.Parse(raw)
+	if err != nil || endpoint.Host == "" || endpoint.User != nil {
+		return false
+	}
+	return endpoint.Scheme == "https" || (webhook.AllowInsecure && endpoint.Scheme == "http")
+}
+
+// Returns the signature of a delivery, a hex HMAC-SHA256 over the timestamp and body
+// joined by a dot, so receivers can reject replayed deliveries by their timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Registers url for events. The returned subscription holds the signing secret,
+// which is not returned again.
+func (webhook Webhook) Subscribe(owner, url string, events []string, all bool) (Subscription, error) {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	if !webhook.validURL(url) {
+		return Subscription{}, ErrInvalidURL
+	}
+
+	if len(events) == 0 {
+		return Subscription{}, ErrInvalidEvent
+	}
+	for _, event := range events {
+		if !validEvent(event) {
+			return Subscription{}, ErrInvalidEvent
+		}
+	}
+
+	collection := webhook.Database(mongodb.Users).Collection(mongodb.Webhooks)
+
+	count, err := collection.CountDocuments(context, bson.D{{Key: "owner", Value: owner}})
+	if err != nil {
+		return Subscription{}, err
+	}
+	if count >= MaxSubscriptions {
+		return Subscription{}, ErrTooManySubscriptions
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	subscription := Subscription{
+		ID:        uuid.New().String(),
+		Owner:     owner,
+		URL:       url,
+		Events:    events,
+		All:       all,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now(),
+	}
+
+	_, err = collection.InsertOne(context, subscription)
+	return subscription, err
+}
+
+// Returns the subscriptions of owner, without their secrets.
+func (webhook Webhook) Subscriptions(owner string) ([]Subscription, error) {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	collection := webhook.Database(mongodb.Users).Collection(mongodb.Webhooks)
+
+	cursor, err := collection.Find(context, bson.D{
+		{Key: "owner", Value: owner},
+	}, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetProjection(bson.D{{Key: "secret", Value: 0}}))
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := []Subscription{}
+	err = cursor.All(context, &subscriptions)
+	return subscriptions, err
+}
+
+// Removes the subscription, pending deliveries of it are dropped.
+func (webhook Webhook) Unsubscribe(owner, id string) error {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	result, err := webhook.Database(mongodb.Users).Collection(mongodb.Webhooks).DeleteOne(context, bson.D{
+		{Key: "owner", Value: owner},
+		{Key: "id", Value: id},
+	})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrSubscriptionDoesNotExist
+	}
+
+	_, err = webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).DeleteMany(context, bson.D{
+		{Key: "subscription_id", Value: id},
+		{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{DeliveryPending, DeliverySending}}}},
+	})
+	return err
+}
+
+// Removes every subscription of owner along with its delivery log.
+func (webhook Webhook) DeleteOwner(owner string) error {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	filter := bson.D{{Key: "owner", Value: owner}}
+
+	_, err := webhook.Database(mongodb.Users).Collection(mongodb.Webhooks).DeleteMany(context, filter)
+	if err != nil {
+		return err
+	}
+
+	_, err = webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).DeleteMany(context, filter)
+	return err
+}
+
+// Queues a delivery of the event for every subscription that receives it. Subscriptions
+// without All only receive events that involve their owner, one of users.
+func (webhook Webhook) Publish(event string, users []string, data interface{}) error {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	collection := webhook.Database(mongodb.Users).Collection(mongodb.Webhooks)
+
+	cursor, err := collection.Find(context, bson.D{
+		{Key: "events", Value: event},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "all", Value: true}},
+			bson.D{{Key: "owner", Value: bson.D{{Key: "$in", Value: users}}}},
+		}},
+	}, options.Find().SetProjection(bson.D{{Key: "secret", Value: 0}}))
+	if err != nil {
+		return err
+	}
+
+	var subscriptions []Subscription
+	err = cursor.All(context, &subscriptions)
+	if err != nil || len(subscriptions) == 0 {
+		return err
+	}
+
+	now := time.Now()
+
+	var deliveries []interface{}
+	for _, subscription := range subscriptions {
+		id := uuid.New().String()
+
+		payload, err := json.Marshal(Payload{
+			ID:        id,
+			Event:     event,
+			CreatedAt: now,
+			Data:      data,
+		})
+		if err != nil {
+			return err
+		}
+
+		deliveries = append(deliveries, Delivery{
+			ID:             id,
+			SubscriptionID: subscription.ID,
+			Owner:          subscription.Owner,
+			URL:            subscription.URL,
+			Event:          event,
+			Payload:        string(payload),
+			Status:         DeliveryPending,
+			Attempts:       []Attempt{},
+			NextAttempt:    now,
+			CreatedAt:      now,
+		})
+	}
+
+	_, err = webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).InsertMany(context, deliveries)
+	return err
+}
+
+// Claims the next due delivery, ErrDeliveryDoesNotExist is returned when none is due.
+func (webhook Webhook) Claim() (Delivery, error) {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	now := time.Now()
+
+	var delivery Delivery
+
+	err := webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).FindOneAndUpdate(context, bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{
+				{Key: "status", Value: DeliveryPending},
+				{Key: "next_attempt", Value: bson.D{{Key: "$lte", Value: now}}},
+			},
+			bson.D{
+				{Key: "status", Value: DeliverySending},
+				{Key: "claimed_at", Value: bson.D{{Key: "$lt", Value: now.Add(-claimTimeout)}}},
+			},
+		}},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: DeliverySending},
+			{Key: "claimed_at", Value: now},
+		}},
+	}, options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt", Value: 1}}).
+		SetReturnDocument(options.After)).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return Delivery{}, ErrDeliveryDoesNotExist
+	}
+
+	return delivery, err
+}
+
+// Returns the wait before the retry following the given number of failed attempts, with jitter
+// so that receivers coming back up are not hit by every retry at once.
+func (webhook Webhook) backoff(attempts int) time.Duration {
+	wait := float64(webhook.BackoffBase) * math.Pow(2, float64(attempts-1))
+	if wait > float64(webhook.BackoffMax) {
+		wait = float64(webhook.BackoffMax)
+	}
+	wait *= 0.8 + 0.4*mathrand.Float64()
+	return time.Duration(wait * float64(time.Second))
+}
+
+func (webhook Webhook) post(delivery Delivery, secret string) (int, error) {
+	timestamp := time.Now().Unix()
+	body := []byte(delivery.Payload)
+
+	// The request has its own deadline, so a slow receiver never eats into the time of the database writes.
+	context, cancel := context.WithTimeout(context.Background(), time.Duration(webhook.TimeoutDuration)*time.Second)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(context, "POST", delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Kevlar-Webhook")
+	request.Header.Set(HeaderEvent, delivery.Event)
+	request.Header.Set(HeaderDelivery, delivery.ID)
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(secret, timestamp, body))
+
+	response, err := webhook.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver responded with status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// Posts the delivery once and returns it in the state that follows. Failed deliveries are
+// retried with exponential backoff until MaxAttempts, after which they are dead.
+func (webhook Webhook) attempt(delivery Delivery, secret string) (Delivery, Attempt) {
+	started := time.Now()
+	status, failure := webhook.post(delivery, secret)
+
+	attempt := Attempt{
+		Time:     started,
+		Status:   status,
+		Duration: time.Since(started).Milliseconds(),
+	}
+	if failure != nil {
+		attempt.Error = failure.Error()
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case failure == nil:
+		expires := time.Now().AddDate(0, 0, webhook.LogRetention)
+		delivery.Status = DeliveryDelivered
+		delivery.ExpiresAt = &expires
+	case len(delivery.Attempts) >= webhook.MaxAttempts:
+		expires := time.Now().AddDate(0, 0, webhook.DeadRetention)
+		delivery.Status = DeliveryDead
+		delivery.ExpiresAt = &expires
+	default:
+		delivery.Status = DeliveryPending
+		delivery.NextAttempt = time.Now().Add(webhook.backoff(len(delivery.Attempts)))
+	}
+
+	return delivery, attempt
+}
+
+// Returns the delivery queued again with a fresh set of attempts.
+func requeue(delivery Delivery, now time.Time) Delivery {
+	delivery.Status = DeliveryPending
+	delivery.Attempts = []Attempt{}
+	delivery.NextAttempt = now
+	delivery.ExpiresAt = nil
+	return delivery
+}
+
+func (webhook Webhook) subscription(delivery Delivery) (Subscription, error) {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	var subscription Subscription
+
+	err := webhook.Database(mongodb.Users).Collection(mongodb.Webhooks).FindOne(context, bson.D{
+		{Key: "id", Value: delivery.SubscriptionID},
+	}).Decode(&subscription)
+	if err == mongo.ErrNoDocuments {
+		// The subscription was removed after the delivery was claimed.
+		_, err = webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).DeleteOne(context, bson.D{
+			{Key: "id", Value: delivery.ID},
+		})
+		if err != nil {
+			return Subscription{}, err
+		}
+		return Subscription{}, ErrSubscriptionDoesNotExist
+	}
+
+	return subscription, err
+}
+
+// Makes one attempt at sending a claimed delivery and records the result.
+func (webhook Webhook) Send(delivery Delivery) (Delivery, error) {
+	subscription, err := webhook.subscription(delivery)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	delivery, attempt := webhook.attempt(delivery, subscription.Secret)
+
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	update := bson.D{
+		{Key: "status", Value: delivery.Status},
+		{Key: "expires_at", Value: delivery.ExpiresAt},
+	}
+	if delivery.Status == DeliveryPending {
+		update = append(update, bson.E{Key: "next_attempt", Value: delivery.NextAttempt})
+	}
+
+	_, err = webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).UpdateOne(context, bson.D{
+		{Key: "id", Value: delivery.ID},
+	}, bson.D{
+		{Key: "$set", Value: update},
+		{Key: "$push", Value: bson.D{{Key: "attempts", Value: attempt}}},
+	})
+
+	return delivery, err
+}
+
+// Returns the delivery log of owner, newest first, optionally narrowed to a subscription
+// and a status. Passing DeliveryDead lists the dead-letter store.
+func (webhook Webhook) Deliveries(owner, subscriptionID, status string, before time.Time, limit int64) ([]Delivery, error) {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	filter := bson.D{{Key: "owner", Value: owner}}
+	if subscriptionID != "" {
+		filter = append(filter, bson.E{Key: "subscription_id", Value: subscriptionID})
+	}
+	if status != "" {
+		filter = append(filter, bson.E{Key: "status", Value: status})
+	}
+	if !before.IsZero() {
+		filter = append(filter, bson.E{Key: "created_at", Value: bson.D{{Key: "$lt", Value: before}}})
+	}
+
+	cursor, err := webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).Find(context, filter,
+		options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := []Delivery{}
+	err = cursor.All(context, &deliveries)
+	return deliveries, err
+}
+
+// Queues a dead delivery again, with a fresh set of attempts.
+func (webhook Webhook) Redeliver(owner, id string) (Delivery, error) {
+	context, cancel := webhook.DefaultContext()
+	defer cancel()
+
+	var delivery Delivery
+
+	queued := requeue(Delivery{}, time.Now())
+
+	err := webhook.Database(mongodb.Users).Collection(mongodb.WebhookDeliveries).FindOneAndUpdate(context, bson.D{
+		{Key: "owner", Value: owner},
+		{Key: "id", Value: id},
+		{Key: "status", Value: DeliveryDead},
+	}, bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: queued.Status},
+			{Key: "attempts", Value: queued.Attempts},
+			{Key: "next_attempt", Value: queued.NextAttempt},
+		}},
+		{Key: "$unset", Value: bson.D{{Key: "expires_at", Value: ""}}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return Delivery{}, ErrDeliveryDoesNotExist
+	}
+
+	return delivery, err
+}